@@ -2,11 +2,17 @@ package azure
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"path"
+	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/axkit/bsw"
@@ -32,6 +38,8 @@ func (p *AzureCompletedPart) PartNumberPtr() *int64 {
 
 type Service struct {
 	cfg             Config
+	cred            *azblob.SharedKeyCredential
+	delegation      *delegation
 	blobClient      *azblob.Client
 	containerClient *container.Client
 }
@@ -40,6 +48,31 @@ type Config struct {
 	AccountName   string `json:"accountName"`
 	AccountKey    string `json:"accountKey"`
 	ContainerName string `json:"containerName"`
+
+	// ConnectionString is an alternative to AccountName and AccountKey.
+	ConnectionString string `json:"connectionString"`
+
+	// ServiceURL replaces https://<account>.blob.core.windows.net/. Used for
+	// Azurite (http://127.0.0.1:10000/devstoreaccount1), sovereign clouds
+	// and private endpoints.
+	ServiceURL string `json:"serviceUrl"`
+
+	// AzureAD is used if neither account key nor SAS is given. Presigned
+	// URLs are signed by user delegation key then.
+	AzureAD AzureADConfig `json:"azureAD"`
+
+	// TokenCredential replaces credential configured by AzureAD.
+	TokenCredential azcore.TokenCredential `json:"-"`
+
+	// ClientOptions are passed to the SDK client.
+	ClientOptions *azblob.ClientOptions `json:"-"`
+
+	// BucketMode is BucketAsPrefix if empty.
+	BucketMode BucketMode `json:"bucketMode"`
+
+	// ContainerNameFunc maps bucket to container name in BucketAsContainer
+	// mode. Bucket is used as is if nil.
+	ContainerNameFunc func(bucket string) string `json:"-"`
 }
 
 // check that Service implements interface bsw.ObjectService
@@ -51,137 +84,556 @@ func New(cfg *Config) *Service {
 }
 
 func (s *Service) Init(ctx context.Context) error {
-	var err error
 
-	if s.cfg.AccountName == "" {
-		return errors.NewCritical("account name is not set")
+	switch s.cfg.BucketMode {
+	case "", BucketAsPrefix:
+		if s.cfg.ContainerName == "" {
+			return errors.NewCritical("container name is not set")
+		}
+	case BucketAsContainer:
+	default:
+		return errors.NewCritical("unknown bucket mode").Set("mode", s.cfg.BucketMode)
+	}
+
+	if err := s.newClient(); err != nil {
+		return err
+	}
+
+	if s.cfg.BucketMode != BucketAsContainer {
+		s.containerClient = s.blobClient.ServiceClient().NewContainerClient(s.cfg.ContainerName)
+	}
+	return nil
+}
+
+// PreSignPutObjectURL_ returns presigned URL for PUT object request.
+func (s *Service) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignPutObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignPutObjectURLContext returns SAS URL for PUT blob request. SAS signing
+// is local, ctx is accepted for interface compatibility.
+func (s *Service) PreSignPutObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+
+	if _, _, err := encryptionOf(o); err != nil {
+		return "", err
+	}
+
+	sasURL, err := s.signedURL(ctx, o, sas.BlobPermissions{Add: true, Create: true, Write: true}, time.Now().Add(timeout), bsw.Headers{})
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(503).Msg("failed to create SAS put URL")
+	}
+	return sasURL, nil
+}
+
+func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignGetObjectURLContext(context.Background(), o, timeout)
+}
+
+func (s *Service) PreSignGetObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+
+	if _, _, err := encryptionOf(o); err != nil {
+		return "", err
+	}
+
+	sasURL, err := s.signedURL(ctx, o, sas.BlobPermissions{Read: true}, time.Now().Add(timeout), o.ResponseHeaders())
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(503).Msg("failed to create SAS get URL")
 	}
+	return sasURL, nil
+}
+
+// signedURL returns SAS URL of the blob. Non empty response headers are
+// passed as rsct, rscd, rscc, rsce and rscl parameters. Write requests are
+// bound to encryption scope of the object if it's set.
+func (s *Service) signedURL(ctx context.Context, o *bsw.Object, perms sas.BlobPermissions, expiry time.Time, rh bsw.Headers) (string, error) {
 
-	if s.cfg.AccountKey == "" {
-		return errors.NewCritical("account key is not set")
+	var scope string
+	if e := o.Encryption(); e.Mode == bsw.SSEScope && (perms.Write || perms.Create || perms.Add) {
+		scope = e.KeyID
 	}
 
-	if s.cfg.ContainerName == "" {
-		return errors.NewCritical("container name is not set")
+	cn, err := s.containerName(o.Bucket())
+	if err != nil {
+		return "", err
 	}
 
-	// Create a credential using the storage account name and key
-	credential, err := azblob.NewSharedKeyCredential(s.cfg.AccountName, s.cfg.AccountKey)
+	qp, err := s.sign(ctx, sas.BlobSignatureValues{
+		Version:            sas.Version,
+		ExpiryTime:         expiry.UTC(),
+		Permissions:        perms.String(),
+		ContainerName:      cn,
+		BlobName:           s.blobName(o),
+		ContentType:        rh.ContentType,
+		ContentDisposition: rh.ContentDisposition,
+		CacheControl:       rh.CacheControl,
+		ContentEncoding:    rh.ContentEncoding,
+		ContentLanguage:    rh.ContentLanguage,
+		EncryptionScope:    scope,
+	})
 	if err != nil {
-		return errors.Catch(err).Critical().Msg("failed to create credential")
+		return "", err
+	}
+	return s.blobClient.ServiceClient().NewContainerClient(cn).NewBlobClient(s.blobName(o)).URL() + "?" + qp.Encode(), nil
+}
+
+// UploadHeaders returns headers the client must send with PUT request to
+// SAS URL. Azure does not sign headers into SAS, so the blob type, content
+// headers and metadata are sent by the client.
+func (s *Service) UploadHeaders(o *bsw.Object) map[string]string {
+
+	res := encryptionHeaders(o.Encryption(), true)
+	res["x-ms-blob-type"] = "BlockBlob"
+	h := o.Headers()
+	for k, v := range map[string]string{
+		"x-ms-blob-content-type":        h.ContentType,
+		"x-ms-blob-content-disposition": h.ContentDisposition,
+		"x-ms-blob-cache-control":       h.CacheControl,
+		"x-ms-blob-content-encoding":    h.ContentEncoding,
+		"x-ms-blob-content-language":    h.ContentLanguage,
+	} {
+		if v != "" {
+			res[k] = v
+		}
+	}
+
+	for k, v := range o.Metadata() {
+		if v != nil {
+			res["x-ms-meta-"+k] = *v
+		}
+	}
+	return res
+}
+
+// blobHeaders converts headers to blob HTTP headers.
+func blobHeaders(h bsw.Headers) *blob.HTTPHeaders {
+	if h.IsZero() {
+		return nil
+	}
+	return &blob.HTTPHeaders{
+		BlobContentType:        optString(h.ContentType),
+		BlobContentDisposition: optString(h.ContentDisposition),
+		BlobCacheControl:       optString(h.CacheControl),
+		BlobContentEncoding:    optString(h.ContentEncoding),
+		BlobContentLanguage:    optString(h.ContentLanguage),
+	}
+}
+
+// optString returns nil for empty string.
+func optString(s string) *string {
+	if s == "" {
+		return nil
 	}
+	return &s
+}
 
-	// Create a service client
-	s.blobClient, err = azblob.NewClientWithSharedKeyCredential(
-		fmt.Sprintf("https://%s.blob.core.windows.net/", s.cfg.AccountName),
-		credential,
-		nil)
+// Put uploads content of r to the block blob.
+func (s *Service) Put(ctx context.Context, o *bsw.Object, r io.Reader, size int64) error {
+
+	cpk, scope, err := encryptionOf(o)
 	if err != nil {
-		return errors.Catch(err).Critical().StatusCode(503).Msg("failed to create service client")
+		return err
 	}
 
-	s.containerClient = s.blobClient.ServiceClient().NewContainerClient(s.cfg.ContainerName)
+	bc, err := s.blockBlobOf(o)
+	if err != nil {
+		return err
+	}
 
+	_, err = bc.UploadStream(ctx, r, &blockblob.UploadStreamOptions{
+		HTTPHeaders:  blobHeaders(o.Headers()),
+		Metadata:     o.Metadata(),
+		CPKInfo:      cpk,
+		CPKScopeInfo: scope,
+	})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "size", size).
+			StatusCode(503).Msg("failed to upload blob")
+	}
 	return nil
 }
 
-func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
-
-	// mui := &s3.CreateMultipartUploadInput{
-	// 	Bucket: aws.String(o.Bucket()),
-	// 	Key:    aws.String(o.Key()),
-	// }
-
-	// req, resp := s.svc.CreateMultipartUploadRequest(mui)
-
-	// req.RetryCount = s.cfg.RetryCount
-
-	// if err := req.Send(); err != nil {
-	// 	ex := errors.Catch(err).StatusCode(500).SetPairs("bucket", o.Bucket(), "key", o.Key(), "parts", o.Parts()).Critical()
-	// 	if aerr, ok := err.(awserr.Error); ok {
-	// 		ex.Set("awsErrCode", aerr.Code())
-	// 	}
-	// 	return nil, "", ex.Msg("create multipart upload request failed")
-	// }
-
-	// // from here we do have
-
-	// var res []string
-	// for i := 0; i < o.Parts(); i++ {
-	// 	x := s.svc.NewRequest(&request.Operation{
-	// 		Name:       "PutObject",
-	// 		HTTPMethod: "PUT",
-	// 		HTTPPath:   "/" + o.Bucket() + "/" + o.Key() + "?partNumber=" + strconv.Itoa(i+1) + "&uploadId=" + *resp.UploadId,
-	// 	}, nil, nil)
-
-	// 	u, err := x.Presign(timeout)
-	// 	if err != nil {
-	// 		return nil, "", errors.Catch(err).
-	// 			SetPairs("bucket", o.Bucket(), "key", o.Key(), "part", i+1).
-	// 			Critical().
-	// 			StatusCode(500).Msg("presigned part URL generation failed")
-	// 	}
-	// 	res = append(res, u)
-	// }
-
-	// return res, *resp.UploadId, nil
-	return nil, "", nil
-}
-
-// CompleteMultipartUpload
-func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
-
-	// var cmu s3.CompletedMultipartUpload
-	// for i := range parts {
-	// 	cmu.Parts = append(cmu.Parts, &s3.CompletedPart{
-	// 		ETag:       parts[i].ETagPtr(),
-	// 		PartNumber: parts[i].PartNumberPtr(),
-	// 	})
-	// }
-
-	// _, err := s.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-	// 	Bucket:          aws.String(o.Bucket()),
-	// 	Key:             aws.String(o.Bucket()),
-	// 	UploadId:        aws.String(uploadID),
-	// 	MultipartUpload: &cmu,
-	// })
-
-	// if err != nil {
-	// 	return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
-	// 		StatusCode(500).Msg("multipart complete failed")
-	// }
+// Get returns blob content.
+func (s *Service) Get(ctx context.Context, o *bsw.Object) (io.ReadCloser, bsw.ObjectInfo, error) {
+	return s.download(ctx, o, blob.HTTPRange{})
+}
+
+// GetRange returns n bytes of the blob content starting at off.
+func (s *Service) GetRange(ctx context.Context, o *bsw.Object, off, n int64) (io.ReadCloser, bsw.ObjectInfo, error) {
+	rng := blob.HTTPRange{Offset: off}
+	if n > 0 {
+		rng.Count = n
+	}
+	return s.download(ctx, o, rng)
+}
+
+func (s *Service) download(ctx context.Context, o *bsw.Object, rng blob.HTTPRange) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	cpk, _, err := encryptionOf(o)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	bc, err := s.blobOf(o)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	resp, err := bc.DownloadStream(ctx, &blob.DownloadStreamOptions{CPKInfo: cpk, Range: rng})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, bsw.ObjectInfo{}, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		if bloberror.HasCode(err, bloberror.InvalidRange) {
+			return nil, bsw.ObjectInfo{}, bsw.ErrInvalidRange.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "offset", rng.Offset)
+		}
+		return nil, bsw.ObjectInfo{}, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(503).Msg("failed to download blob")
+	}
+
+	oi := bsw.ObjectInfo{
+		Bucket:      o.Bucket(),
+		Key:         o.Key(),
+		Size:        bsw.ContentRangeSize(deref(resp.ContentRange), deref(resp.ContentLength)),
+		ContentType: deref(resp.ContentType),
+		Metadata:    resp.Metadata,
+
+		ContentDisposition: deref(resp.ContentDisposition),
+		CacheControl:       deref(resp.CacheControl),
+		ContentEncoding:    deref(resp.ContentEncoding),
+		ContentLanguage:    deref(resp.ContentLanguage),
+	}
+	if resp.ETag != nil {
+		oi.ETag = strings.Trim(string(*resp.ETag), `"`)
+	}
+	if resp.LastModified != nil {
+		oi.LastModified = *resp.LastModified
+	}
+	return resp.Body, oi, nil
+}
+
+// Stat returns blob properties.
+func (s *Service) Stat(ctx context.Context, o *bsw.Object) (bsw.ObjectInfo, error) {
+
+	cpk, _, err := encryptionOf(o)
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+
+	bc, err := s.blobOf(o)
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+
+	resp, err := bc.GetProperties(ctx, &blob.GetPropertiesOptions{CPKInfo: cpk})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return bsw.ObjectInfo{}, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		return bsw.ObjectInfo{}, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(503).Msg("failed to get blob properties")
+	}
+
+	oi := bsw.ObjectInfo{
+		Bucket:       o.Bucket(),
+		Key:          o.Key(),
+		Size:         deref(resp.ContentLength),
+		ContentType:  deref(resp.ContentType),
+		StorageClass: deref(resp.AccessTier),
+		Metadata:     resp.Metadata,
+
+		ContentDisposition: deref(resp.ContentDisposition),
+		CacheControl:       deref(resp.CacheControl),
+		ContentEncoding:    deref(resp.ContentEncoding),
+		ContentLanguage:    deref(resp.ContentLanguage),
+	}
+	if resp.ETag != nil {
+		oi.ETag = strings.Trim(string(*resp.ETag), `"`)
+	}
+	if resp.LastModified != nil {
+		oi.LastModified = *resp.LastModified
+	}
+	if resp.CreationTime != nil {
+		oi.Created = *resp.CreationTime
+	}
+	return oi, nil
+}
+
+// List returns iterator over blobs of the bucket. The bucket path prefix
+// is removed from returned keys.
+//
+// Azure has no start-after parameter and its markers are opaque, so
+// StartAfter is applied to fetched pages: every blob with the prefix
+// preceding StartAfter is still listed. Listing with StartAfter beyond
+// all keys having the prefix sends no requests.
+func (s *Service) List(ctx context.Context, bucket string, opts bsw.ListOptions) *bsw.ListIterator {
+	bp := s.blobPrefix(bucket)
+
+	return bsw.NewListIterator(ctx, opts, func(ctx context.Context, token string) (bsw.ListPage, error) {
+
+		// every key having the prefix is less than StartAfter.
+		if opts.StartAfter > opts.Prefix && !strings.HasPrefix(opts.StartAfter, opts.Prefix) {
+			return bsw.ListPage{}, nil
+		}
+
+		cc, err := s.containerOf(bucket)
+		if err != nil {
+			return bsw.ListPage{}, err
+		}
+
+		var (
+			marker     *string
+			maxResults *int32
+			blobs      []*container.BlobItem
+			prefixes   []*container.BlobPrefix
+			next       *string
+		)
+		if token != "" {
+			marker = to.Ptr(token)
+		}
+		if opts.PageSize > 0 {
+			maxResults = to.Ptr(int32(opts.PageSize))
+		}
+
+		if opts.Delimiter == "" {
+			page, err := cc.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+				Prefix:     to.Ptr(bp + opts.Prefix),
+				Marker:     marker,
+				MaxResults: maxResults,
+				Include:    container.ListBlobsInclude{Metadata: true},
+			}).NextPage(ctx)
+			if err != nil {
+				return bsw.ListPage{}, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", opts.Prefix).
+					StatusCode(503).Msg("failed to list blobs")
+			}
+			if page.Segment != nil {
+				blobs = page.Segment.BlobItems
+			}
+			next = page.NextMarker
+		} else {
+			page, err := cc.NewListBlobsHierarchyPager(opts.Delimiter, &container.ListBlobsHierarchyOptions{
+				Prefix:     to.Ptr(bp + opts.Prefix),
+				Marker:     marker,
+				MaxResults: maxResults,
+				Include:    container.ListBlobsInclude{Metadata: true},
+			}).NextPage(ctx)
+			if err != nil {
+				return bsw.ListPage{}, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", opts.Prefix).
+					StatusCode(503).Msg("failed to list blobs")
+			}
+			if page.Segment != nil {
+				blobs, prefixes = page.Segment.BlobItems, page.Segment.BlobPrefixes
+			}
+			next = page.NextMarker
+		}
+
+		var res bsw.ListPage
+		res.NextToken = deref(next)
+
+		for len(blobs) > 0 || len(prefixes) > 0 {
+			var oi bsw.ObjectInfo
+			if len(prefixes) == 0 || (len(blobs) > 0 && deref(blobs[0].Name) < deref(prefixes[0].Name)) {
+				oi = blobInfo(bucket, strings.TrimPrefix(deref(blobs[0].Name), bp), blobs[0])
+				blobs = blobs[1:]
+			} else {
+				oi = bsw.ObjectInfo{Bucket: bucket, Key: strings.TrimPrefix(deref(prefixes[0].Name), bp), IsPrefix: true}
+				prefixes = prefixes[1:]
+			}
+
+			// Azure has no start-after parameter, the filtering is on our side.
+			if opts.StartAfter != "" && oi.Key <= opts.StartAfter {
+				continue
+			}
+			res.Objects = append(res.Objects, oi)
+		}
+		return res, nil
+	})
+}
+
+func blobInfo(bucket, key string, bi *container.BlobItem) bsw.ObjectInfo {
+	oi := bsw.ObjectInfo{
+		Bucket:   bucket,
+		Key:      key,
+		Metadata: bi.Metadata,
+	}
+	if p := bi.Properties; p != nil {
+		oi.Size = deref(p.ContentLength)
+		oi.ContentType = deref(p.ContentType)
+		oi.ContentDisposition = deref(p.ContentDisposition)
+		oi.CacheControl = deref(p.CacheControl)
+		oi.ContentEncoding = deref(p.ContentEncoding)
+		oi.ContentLanguage = deref(p.ContentLanguage)
+		if p.ETag != nil {
+			oi.ETag = strings.Trim(string(*p.ETag), `"`)
+		}
+		if p.LastModified != nil {
+			oi.LastModified = *p.LastModified
+		}
+		if p.AccessTier != nil {
+			oi.StorageClass = string(*p.AccessTier)
+		}
+	}
+	return oi
+}
+
+// Delete removes the blob.
+func (s *Service) Delete(ctx context.Context, o *bsw.Object) error {
+
+	bc, err := s.blobOf(o)
+	if err != nil {
+		return err
+	}
 
+	_, err = bc.Delete(ctx, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(503).Msg("failed to delete blob")
+	}
 	return nil
 }
 
-// PreSignPutObjectURL_ returns presigned URL for PUT object request.
-func (s *Service) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+// maxBatchSize is the limit of sub-requests in a single blob batch.
+const maxBatchSize = 256
+
+// DeleteMany removes blobs using blob batch requests, up to 256 blobs per request.
+func (s *Service) DeleteMany(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
+
+	var res []bsw.DeleteError
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+
+		failed, err := s.deleteBatch(ctx, bucket, keys[:n])
+		if err != nil {
+			return res, err
+		}
+		res = append(res, failed...)
+		keys = keys[n:]
+	}
+	return res, nil
+}
 
-	fmt.Println("PreSignPutObjectURL")
-	// Define the SAS token options
-	sasPermissions := sas.BlobPermissions{Add: true, Create: true, Write: true}
-	expiryTime := time.Now().Add(timeout)
+func (s *Service) deleteBatch(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
 
-	sasURL, err := s.containerClient.NewBlobClient(path.Join(o.Bucket(), o.Key())).GetSASURL(sasPermissions, expiryTime, nil)
+	cc, err := s.containerOf(bucket)
 	if err != nil {
-		fmt.Println(err)
-		return "", errors.Catch(err).Critical().StatusCode(503).Msg("failed to create SAS put URL")
+		return nil, err
 	}
-	fmt.Println(sasURL)
-	return sasURL, nil
+
+	bb, err := cc.NewBatchBuilder()
+	if err != nil {
+		return nil, errors.Catch(err).Critical().Set("bucket", bucket).StatusCode(500).Msg("failed to create blob batch")
+	}
+
+	for i := range keys {
+		if err := bb.Delete(s.blobName(bsw.NewObject(s, bucket, keys[i])), nil); err != nil {
+			return nil, errors.Catch(err).Critical().SetPairs("bucket", bucket, "key", keys[i]).
+				StatusCode(500).Msg("failed to add blob to batch")
+		}
+	}
+
+	resp, err := cc.SubmitBatch(ctx, bb, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Catch(err).Set("bucket", bucket).StatusCode(503).Msg("blob batch cancelled")
+		}
+
+		// the whole batch failed, every key in it is reported.
+		ex := errors.Catch(err).Critical().Set("bucket", bucket).StatusCode(503)
+		res := make([]bsw.DeleteError, len(keys))
+		for i := range keys {
+			res[i] = bsw.DeleteError{Key: keys[i], Err: ex}
+		}
+		return res, nil
+	}
+
+	var res []bsw.DeleteError
+	for i, item := range resp.Responses {
+		if item.Error == nil || bloberror.HasCode(item.Error, bloberror.BlobNotFound) {
+			continue
+		}
+
+		// ContentID is the index of sub-request in the batch.
+		idx := i
+		if item.ContentID != nil {
+			idx = *item.ContentID
+		}
+		if idx < 0 || idx >= len(keys) {
+			continue
+		}
+		res = append(res, bsw.DeleteError{
+			Key: keys[idx],
+			Err: errors.Catch(item.Error).SetPairs("bucket", bucket, "key", keys[idx]).StatusCode(503),
+		})
+	}
+	return res, nil
 }
 
-func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+// DeletePrefix lists blobs with the prefix and removes them by batches.
+func (s *Service) DeletePrefix(ctx context.Context, bucket, prefix string) ([]bsw.DeleteError, error) {
 
-	// Define the SAS token options
-	sasPermissions := sas.BlobPermissions{Read: true}
-	expiryTime := time.Now().Add(timeout)
+	if prefix == "" {
+		return nil, bsw.ErrWrongInvocation.Capture().Set("reason", "empty prefix")
+	}
 
-	sasURL, err := s.containerClient.NewBlobClient(path.Join(o.Bucket(), o.Key())).GetSASURL(sasPermissions, expiryTime, nil)
+	cc, err := s.containerOf(bucket)
 	if err != nil {
-		return "", errors.Catch(err).Critical().StatusCode(503).Msg("failed to create SAS get URL")
+		return nil, err
 	}
-	return sasURL, nil
+
+	var res []bsw.DeleteError
+	pager := cc.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(s.blobPrefix(bucket) + prefix),
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return res, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", prefix).
+				StatusCode(503).Msg("failed to list blobs")
+		}
+
+		if page.Segment == nil {
+			continue
+		}
+
+		keys := make([]string, 0, len(page.Segment.BlobItems))
+		for _, bi := range page.Segment.BlobItems {
+			keys = append(keys, strings.TrimPrefix(deref(bi.Name), s.blobPrefix(bucket)))
+		}
+
+		failed, err := s.DeleteMany(ctx, bucket, keys)
+		res = append(res, failed...)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// blobName returns blob name inside the container.
+func (s *Service) blobName(o *bsw.Object) string {
+	if s.cfg.BucketMode == BucketAsContainer {
+		return o.Key()
+	}
+	return path.Join(o.Bucket(), o.Key())
+}
+
+// blobPrefix returns prefix of all blob names belonging to the bucket.
+func (s *Service) blobPrefix(bucket string) string {
+	if s.cfg.BucketMode == BucketAsContainer {
+		return ""
+	}
+	return bucket + "/"
+}
+
+func deref[T any](p *T) T {
+	var res T
+	if p != nil {
+		res = *p
+	}
+	return res
 }
 
 func (s *Service) Name() string {
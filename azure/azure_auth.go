@@ -0,0 +1,246 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// Well known account of Azurite and legacy storage emulator.
+const (
+	devStoreAccountName = "devstoreaccount1"
+	devStoreAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	devStoreServiceURL  = "http://127.0.0.1:10000/devstoreaccount1"
+)
+
+const (
+	// delegationKeyLifetime is the minimal lifetime of requested user
+	// delegation key. The key is reused for all SAS expiring before it.
+	delegationKeyLifetime = 24 * time.Hour
+
+	// maxDelegationKeyLifetime is the limit set by Azure.
+	maxDelegationKeyLifetime = 7 * 24 * time.Hour
+
+	// clockSkew is subtracted from start time of delegation key.
+	clockSkew = 5 * time.Minute
+)
+
+// AzureADConfig holds Azure AD (Entra ID) application credentials. Client
+// secret credential is used if ClientSecret is set, managed identity with
+// ClientID if only it is set, otherwise DefaultAzureCredential chain
+// (environment, workload identity, managed identity, Azure CLI).
+type AzureADConfig struct {
+	TenantID     string `json:"tenantId"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// connString holds settings parsed from storage account connection string.
+type connString struct {
+	accountName string
+	accountKey  string
+	sas         string
+	serviceURL  string
+}
+
+// parseConnectionString parses connection string as formatted in Azure
+// portal: "DefaultEndpointsProtocol=https;AccountName=...;AccountKey=...;EndpointSuffix=core.windows.net".
+// BlobEndpoint, SharedAccessSignature and UseDevelopmentStorage are supported too.
+func parseConnectionString(cs string) (connString, error) {
+
+	kv := map[string]string{}
+	for _, p := range strings.Split(cs, ";") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			return connString{}, errors.NewCritical("invalid connection string").Set("part", k)
+		}
+		kv[strings.ToLower(k)] = v
+	}
+
+	if strings.EqualFold(kv["usedevelopmentstorage"], "true") {
+		return connString{
+			accountName: devStoreAccountName,
+			accountKey:  devStoreAccountKey,
+			serviceURL:  devStoreServiceURL,
+		}, nil
+	}
+
+	res := connString{
+		accountName: kv["accountname"],
+		accountKey:  kv["accountkey"],
+		sas:         strings.TrimPrefix(kv["sharedaccesssignature"], "?"),
+		serviceURL:  kv["blobendpoint"],
+	}
+
+	if res.serviceURL == "" {
+		if res.accountName == "" {
+			return connString{}, errors.NewCritical("connection string has neither AccountName nor BlobEndpoint")
+		}
+		protocol, suffix := kv["defaultendpointsprotocol"], kv["endpointsuffix"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		res.serviceURL = fmt.Sprintf("%s://%s.blob.%s", protocol, res.accountName, suffix)
+	}
+
+	if res.accountKey == "" && res.sas == "" {
+		return connString{}, errors.NewCritical("connection string has neither AccountKey nor SharedAccessSignature")
+	}
+	return res, nil
+}
+
+// tokenCredential returns Azure AD credential configured by cfg.
+func tokenCredential(cfg *AzureADConfig) (azcore.TokenCredential, error) {
+	switch {
+	case cfg.ClientSecret != "":
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+	case cfg.ClientID != "":
+		return azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(cfg.ClientID),
+		})
+	}
+	return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{TenantID: cfg.TenantID})
+}
+
+// newClient creates service client authorized by shared key, SAS from
+// connection string or Azure AD token, in this order.
+func (s *Service) newClient() error {
+
+	var sasToken string
+
+	serviceURL := s.cfg.ServiceURL
+	if s.cfg.ConnectionString != "" {
+		cs, err := parseConnectionString(s.cfg.ConnectionString)
+		if err != nil {
+			return err
+		}
+		if s.cfg.AccountName == "" {
+			s.cfg.AccountName = cs.accountName
+		}
+		if s.cfg.AccountKey == "" {
+			s.cfg.AccountKey = cs.accountKey
+		}
+		if serviceURL == "" {
+			serviceURL = cs.serviceURL
+		}
+		sasToken = cs.sas
+	}
+
+	if serviceURL == "" {
+		if s.cfg.AccountName == "" {
+			return errors.NewCritical("account name is not set")
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", s.cfg.AccountName)
+	}
+	if !strings.HasSuffix(serviceURL, "/") {
+		serviceURL += "/"
+	}
+
+	var err error
+	switch {
+	case s.cfg.AccountKey != "":
+		if s.cfg.AccountName == "" {
+			return errors.NewCritical("account name is not set")
+		}
+		s.cred, err = azblob.NewSharedKeyCredential(s.cfg.AccountName, s.cfg.AccountKey)
+		if err != nil {
+			return errors.Catch(err).Critical().Msg("failed to create credential")
+		}
+		s.blobClient, err = azblob.NewClientWithSharedKeyCredential(serviceURL, s.cred, s.cfg.ClientOptions)
+	case sasToken != "":
+		s.blobClient, err = azblob.NewClientWithNoCredential(serviceURL+"?"+sasToken, s.cfg.ClientOptions)
+	default:
+		tc := s.cfg.TokenCredential
+		if tc == nil {
+			if tc, err = tokenCredential(&s.cfg.AzureAD); err != nil {
+				return errors.Catch(err).Critical().Msg("failed to create Azure AD credential")
+			}
+		}
+		s.blobClient, err = azblob.NewClient(serviceURL, tc, s.cfg.ClientOptions)
+	}
+	if err != nil {
+		return errors.Catch(err).Critical().StatusCode(503).Msg("failed to create service client")
+	}
+
+	if s.cred == nil && sasToken == "" {
+		s.delegation = &delegation{svc: s.blobClient.ServiceClient()}
+	}
+	return nil
+}
+
+// delegation caches user delegation key used to sign SAS when the service
+// is authorized by Azure AD.
+type delegation struct {
+	svc *service.Client
+
+	mu     sync.Mutex
+	cred   *service.UserDelegationCredential
+	expiry time.Time
+}
+
+// credential returns user delegation key valid at least till expiry.
+func (d *delegation) credential(ctx context.Context, expiry time.Time) (*service.UserDelegationCredential, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cred != nil && !expiry.After(d.expiry) {
+		return d.cred, nil
+	}
+
+	now := time.Now().UTC()
+	keyExpiry := now.Add(delegationKeyLifetime)
+	if expiry.After(keyExpiry) {
+		keyExpiry = expiry.UTC()
+	}
+	if keyExpiry.Sub(now) > maxDelegationKeyLifetime {
+		return nil, errors.ValidationFailed("SAS expiry exceeds user delegation key lifetime").
+			Set("expiry", expiry.Format(time.RFC3339))
+	}
+
+	cred, err := d.svc.GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(now.Add(-clockSkew).Format(sas.TimeFormat)),
+		Expiry: to.Ptr(keyExpiry.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return nil, errors.Catch(err).Critical().StatusCode(503).Msg("failed to get user delegation key")
+	}
+
+	d.cred, d.expiry = cred, keyExpiry
+	return cred, nil
+}
+
+// sign signs SAS values by account key or user delegation key.
+func (s *Service) sign(ctx context.Context, v sas.BlobSignatureValues) (sas.QueryParameters, error) {
+
+	if s.cred != nil {
+		return v.SignWithSharedKey(s.cred)
+	}
+
+	if s.delegation == nil {
+		return sas.QueryParameters{}, bsw.ErrNotSupported.Capture().SetPairs("backend", "azure", "op", "presign", "reason", "no account key or Azure AD credential")
+	}
+
+	cred, err := s.delegation.credential(ctx, v.ExpiryTime)
+	if err != nil {
+		return sas.QueryParameters{}, err
+	}
+	return v.SignWithUserDelegation(cred)
+}
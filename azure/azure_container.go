@@ -0,0 +1,100 @@
+package azure
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// BucketMode defines how bsw buckets are mapped to Azure containers.
+type BucketMode string
+
+const (
+	// BucketAsPrefix keeps objects of all buckets in Config.ContainerName,
+	// the bucket is the first segment of the blob name.
+	BucketAsPrefix BucketMode = "prefix"
+
+	// BucketAsContainer keeps objects of the bucket in the container named
+	// after the bucket, the key is the blob name.
+	BucketAsContainer BucketMode = "container"
+)
+
+// ValidateContainerName checks name against Azure container naming rules:
+// 3-63 characters, lowercase letters, digits and hyphens, starting with
+// a letter or a digit, without consecutive hyphens and a trailing hyphen.
+// The special $root container is accepted.
+func ValidateContainerName(name string) error {
+
+	if name == "$root" {
+		return nil
+	}
+
+	if len(name) < 3 || len(name) > 63 {
+		return errors.ValidationFailed("container name must be 3-63 characters long").Set("container", name)
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case c == '-':
+			if i == 0 || i == len(name)-1 || name[i-1] == '-' {
+				return errors.ValidationFailed("container name has misplaced hyphen").Set("container", name)
+			}
+		default:
+			return errors.ValidationFailed("container name has invalid character").Set("container", name)
+		}
+	}
+	return nil
+}
+
+// containerName returns name of the container holding objects of the bucket.
+func (s *Service) containerName(bucket string) (string, error) {
+
+	if s.cfg.BucketMode != BucketAsContainer {
+		return s.cfg.ContainerName, nil
+	}
+
+	name := bucket
+	if s.cfg.ContainerNameFunc != nil {
+		name = s.cfg.ContainerNameFunc(bucket)
+	}
+	if err := ValidateContainerName(name); err != nil {
+		return "", errors.Catch(err).Set("bucket", bucket)
+	}
+	return name, nil
+}
+
+// containerOf returns client of the container holding objects of the bucket.
+func (s *Service) containerOf(bucket string) (*container.Client, error) {
+
+	if s.cfg.BucketMode != BucketAsContainer {
+		return s.containerClient, nil
+	}
+
+	name, err := s.containerName(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return s.blobClient.ServiceClient().NewContainerClient(name), nil
+}
+
+// blobOf returns client of the object's blob.
+func (s *Service) blobOf(o *bsw.Object) (*blob.Client, error) {
+	cc, err := s.containerOf(o.Bucket())
+	if err != nil {
+		return nil, err
+	}
+	return cc.NewBlobClient(s.blobName(o)), nil
+}
+
+// blockBlobOf returns block blob client of the object's blob.
+func (s *Service) blockBlobOf(o *bsw.Object) (*blockblob.Client, error) {
+	cc, err := s.containerOf(o.Bucket())
+	if err != nil {
+		return nil, err
+	}
+	return cc.NewBlockBlobClient(s.blobName(o)), nil
+}
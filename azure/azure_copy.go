@@ -0,0 +1,110 @@
+package azure
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+var (
+	// copySourceTimeout is the lifetime of SAS URL given to the copy operation as a source.
+	copySourceTimeout = 24 * time.Hour
+
+	// copyPollInterval is the initial interval of copy status polling.
+	copyPollInterval = 500 * time.Millisecond
+
+	// copyPollMaxInterval is the maximum interval of copy status polling.
+	copyPollMaxInterval = 10 * time.Second
+)
+
+// Copy starts asynchronous copy of src blob to dst and polls copy status
+// till completion. The copy is aborted if ctx is done before.
+func (s *Service) Copy(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+
+	// asynchronous copy accepts neither customer provided keys nor encryption scope.
+	for _, o := range []*bsw.Object{src, dst} {
+		cpk, scope, err := encryptionOf(o)
+		if err != nil {
+			return err
+		}
+		if cpk != nil || (scope != nil && o == dst) {
+			return bsw.ErrNotSupported.Capture().SetPairs("backend", "azure", "op", "copy", "encryption", o.Encryption().Mode)
+		}
+	}
+
+	srcURL, err := s.signedURL(ctx, src, sas.BlobPermissions{Read: true}, time.Now().Add(copySourceTimeout), bsw.Headers{})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", src.Bucket(), "key", src.Key()).
+			StatusCode(503).Msg("failed to create SAS copy source URL")
+	}
+
+	var co blob.StartCopyFromURLOptions
+	if opts.ReplaceMetadata {
+		co.Metadata = dst.Metadata()
+	}
+
+	bc, err := s.blobOf(dst)
+	if err != nil {
+		return err
+	}
+	resp, err := bc.StartCopyFromURL(ctx, srcURL, &co)
+	if err != nil {
+		return errors.Catch(err).Critical().
+			SetPairs("srcBucket", src.Bucket(), "srcKey", src.Key(), "bucket", dst.Bucket(), "key", dst.Key()).
+			StatusCode(503).Msg("failed to start blob copy")
+	}
+
+	status, copyID := deref(resp.CopyStatus), deref(resp.CopyID)
+	for delay := copyPollInterval; status == blob.CopyStatusTypePending; {
+		select {
+		case <-ctx.Done():
+			_, _ = bc.AbortCopyFromURL(context.Background(), copyID, nil)
+			return errors.Catch(ctx.Err()).SetPairs("bucket", dst.Bucket(), "key", dst.Key(), "copyID", copyID).
+				StatusCode(503).Msg("blob copy aborted")
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > copyPollMaxInterval {
+			delay = copyPollMaxInterval
+		}
+
+		props, err := bc.GetProperties(ctx, nil)
+		if err != nil {
+			return errors.Catch(err).Critical().SetPairs("bucket", dst.Bucket(), "key", dst.Key(), "copyID", copyID).
+				StatusCode(503).Msg("failed to get blob copy status")
+		}
+		status = deref(props.CopyStatus)
+	}
+
+	if status != blob.CopyStatusTypeSuccess {
+		return errors.New("blob copy failed").Critical().
+			SetPairs("bucket", dst.Bucket(), "key", dst.Key(), "copyID", copyID, "copyStatus", status).
+			StatusCode(503)
+	}
+
+	// Copy Blob accepts metadata only, headers are replaced once the copy
+	// completed. Headers not set in dst are cleared as S3 does.
+	if opts.ReplaceMetadata {
+		h := blobHeaders(dst.Headers())
+		if h == nil {
+			h = &blob.HTTPHeaders{}
+		}
+		if _, err := bc.SetHTTPHeaders(ctx, *h, nil); err != nil {
+			return errors.Catch(err).Critical().SetPairs("bucket", dst.Bucket(), "key", dst.Key()).
+				StatusCode(503).Msg("failed to set blob headers")
+		}
+	}
+	return nil
+}
+
+// Move copies src blob to dst and deletes src.
+func (s *Service) Move(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+	if err := s.Copy(ctx, src, dst, opts); err != nil {
+		return err
+	}
+	return s.Delete(ctx, src)
+}
@@ -0,0 +1,383 @@
+package azure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// Multipart uploads are implemented with block blobs. Every part is a block
+// staged with ID built from upload ID and part number. Azure has no notion of
+// upload, so the upload ID holds the time it was started.
+
+// uploadIDLen is the length of upload ID: 8 hex digits of unix time and
+// 16 hex digits of random. Block IDs of a blob must have the same length.
+const uploadIDLen = 24
+
+// newUploadID returns new multipart upload ID.
+func newUploadID() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", time.Now().Unix()) + hex.EncodeToString(buf[:]), nil
+}
+
+// uploadInitiated returns the time the upload was started at.
+func uploadInitiated(uploadID string) time.Time {
+	if len(uploadID) != uploadIDLen {
+		return time.Time{}
+	}
+	sec, err := strconv.ParseInt(uploadID[:8], 16, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// blockID returns base64 encoded ID of the block holding the part.
+func blockID(uploadID string, partNumber int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%05d", uploadID, partNumber)))
+}
+
+// parseBlockID extracts upload ID and part number from block ID.
+// Returns false if the block was not staged by multipart upload.
+func parseBlockID(id string) (string, int64, bool) {
+	buf, err := base64.StdEncoding.DecodeString(id)
+	if err != nil || len(buf) != uploadIDLen+6 || buf[uploadIDLen] != '-' {
+		return "", 0, false
+	}
+
+	pn, err := strconv.ParseInt(string(buf[uploadIDLen+1:]), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return string(buf[:uploadIDLen]), pn, true
+}
+
+func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+	return s.PreSignMultipartObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignMultipartObjectURLContext generates upload ID and returns SAS URL of
+// Put Block request for every part. Blocks become the blob after
+// CompleteMultipartUpload call.
+func (s *Service) PreSignMultipartObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+
+	if o.Parts() < 1 || o.Parts() > blockblob.MaxBlocks {
+		return nil, "", bsw.ErrWrongInvocation.Capture().Set("parts", o.Parts())
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		return nil, "", errors.Catch(err).Critical().StatusCode(500).Msg("failed to generate upload id")
+	}
+
+	if _, _, err := encryptionOf(o); err != nil {
+		return nil, "", err
+	}
+
+	sasURL, err := s.signedURL(ctx, o, sas.BlobPermissions{Write: true}, time.Now().Add(timeout), bsw.Headers{})
+	if err != nil {
+		return nil, "", errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(503).Msg("failed to create SAS put block URL")
+	}
+
+	res := make([]string, o.Parts())
+	for i := range res {
+		res[i] = sasURL + "&comp=block&blockid=" + url.QueryEscape(blockID(uploadID, int64(i+1)))
+	}
+	return res, uploadID, nil
+}
+
+// CompleteMultipartUpload
+func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+	return s.CompleteMultipartUploadContext(context.Background(), o, uploadID, parts)
+}
+
+// CompleteMultipartUploadContext commits blocks of the parts in part number order
+// by Put Block List request. Every part must be staged before, object metadata
+// is assigned to the blob.
+func (s *Service) CompleteMultipartUploadContext(ctx context.Context, o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+
+	if len(parts) == 0 {
+		return errors.ValidationFailed("no parts to complete").SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+	}
+
+	pns := make([]int64, len(parts))
+	for i := range parts {
+		pns[i] = *parts[i].PartNumberPtr()
+	}
+	sort.Slice(pns, func(i, j int) bool { return pns[i] < pns[j] })
+
+	staged, err := s.ListParts(ctx, o, uploadID)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int64]bool, len(staged))
+	for i := range staged {
+		known[staged[i].PartNumber] = true
+	}
+
+	ids := make([]string, len(pns))
+	for i, pn := range pns {
+		if !known[pn] || (i > 0 && pns[i-1] == pn) {
+			return errors.ValidationFailed("part not uploaded").
+				SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID, "part", pn)
+		}
+		ids[i] = blockID(uploadID, pn)
+	}
+
+	cpk, scope, err := encryptionOf(o)
+	if err != nil {
+		return err
+	}
+
+	bc, err := s.blockBlobOf(o)
+	if err != nil {
+		return err
+	}
+
+	_, err = bc.CommitBlockList(ctx, ids, &blockblob.CommitBlockListOptions{
+		HTTPHeaders:  blobHeaders(o.Headers()),
+		Metadata:     o.Metadata(),
+		CPKInfo:      cpk,
+		CPKScopeInfo: scope,
+	})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(503).Msg("failed to commit block list")
+	}
+	return nil
+}
+
+// ListParts returns uncommitted blocks staged within the upload. ETag of
+// the part is the block ID.
+func (s *Service) ListParts(ctx context.Context, o *bsw.Object, uploadID string) ([]bsw.Part, error) {
+
+	bc, err := s.blockBlobOf(o)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bc.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+		}
+		return nil, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(503).Msg("failed to get block list")
+	}
+
+	var res []bsw.Part
+	for _, b := range resp.UncommittedBlocks {
+		uid, pn, ok := parseBlockID(deref(b.Name))
+		if !ok || uid != uploadID {
+			continue
+		}
+		res = append(res, bsw.Part{
+			PartNumber: pn,
+			ETag:       deref(b.Name),
+			Size:       deref(b.Size),
+		})
+	}
+
+	if len(res) == 0 {
+		return nil, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].PartNumber < res[j].PartNumber })
+	return res, nil
+}
+
+// ListMultipartUploads returns uploads having staged blocks. Block list of
+// every blob with the prefix is requested, so the call is expensive for
+// large buckets.
+func (s *Service) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]bsw.MultipartUpload, error) {
+
+	cc, err := s.containerOf(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	bp := s.blobPrefix(bucket)
+	pager := cc.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  to.Ptr(bp + prefix),
+		Include: container.ListBlobsInclude{UncommittedBlobs: true},
+	})
+
+	var res []bsw.MultipartUpload
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", prefix).
+				StatusCode(503).Msg("failed to list blobs")
+		}
+		if page.Segment == nil {
+			continue
+		}
+
+		for _, bi := range page.Segment.BlobItems {
+			name := deref(bi.Name)
+			resp, err := cc.NewBlockBlobClient(name).GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+			if err != nil {
+				if bloberror.HasCode(err, bloberror.BlobNotFound) {
+					continue
+				}
+				return nil, errors.Catch(err).Critical().SetPairs("bucket", bucket, "blob", name).
+					StatusCode(503).Msg("failed to get block list")
+			}
+
+			seen := make(map[string]bool)
+			for _, b := range resp.UncommittedBlocks {
+				uid, _, ok := parseBlockID(deref(b.Name))
+				if !ok || seen[uid] {
+					continue
+				}
+				seen[uid] = true
+				res = append(res, bsw.MultipartUpload{
+					Bucket:    bucket,
+					Key:       strings.TrimPrefix(name, bp),
+					UploadID:  uid,
+					Initiated: uploadInitiated(uid),
+				})
+			}
+		}
+	}
+	return res, nil
+}
+
+// AbortMultipartUpload discards uncommitted blocks of the blob. Azure can't
+// discard separate blocks: committing the block list drops uncommitted blocks
+// of every pending upload to the same blob, not only blocks of uploadID.
+//
+// If the blob does not exist, an empty block list is committed unless the
+// blob is created meanwhile and the empty blob is deleted unless it's
+// replaced meanwhile. If the blob exists, its committed block list is
+// committed again keeping content, headers, metadata, encryption and
+// explicitly set access tier. ETag and Last-Modified of the blob change then.
+// Blobs created by a single Put Blob request have no block list to commit
+// again and their uncommitted blocks can't be discarded without data loss:
+// ErrNotSupported is returned, Azure removes the blocks after 7 days.
+func (s *Service) AbortMultipartUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+
+	cpk, scope, err := encryptionOf(o)
+	if err != nil {
+		return err
+	}
+
+	bc, err := s.blockBlobOf(o)
+	if err != nil {
+		return err
+	}
+
+	bl, err := bc.GetBlockList(ctx, blockblob.BlockListTypeAll, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+		}
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(503).Msg("failed to get block list")
+	}
+
+	found := false
+	for _, b := range bl.UncommittedBlocks {
+		if uid, _, ok := parseBlockID(deref(b.Name)); ok && uid == uploadID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+	}
+
+	props, err := bc.GetProperties(ctx, &blob.GetPropertiesOptions{CPKInfo: cpk})
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(503).Msg("failed to get blob properties")
+	}
+
+	if err != nil {
+		// the blob has uncommitted blocks only.
+		resp, err := bc.CommitBlockList(ctx, nil, &blockblob.CommitBlockListOptions{
+			AccessConditions: &blob.AccessConditions{
+				ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)},
+			},
+		})
+		if err != nil {
+			return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+				StatusCode(503).Msg("failed to commit empty block list")
+		}
+		_, err = bc.Delete(ctx, &blob.DeleteOptions{
+			AccessConditions: &blob.AccessConditions{
+				ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: resp.ETag},
+			},
+		})
+		if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ConditionNotMet) {
+			return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+				StatusCode(503).Msg("failed to delete blob")
+		}
+		return nil
+	}
+
+	if len(bl.CommittedBlocks) == 0 {
+		return bsw.ErrNotSupported.Capture().SetPairs("backend", "azure", "op", "abort", "bucket", o.Bucket(), "key", o.Key(),
+			"uploadID", uploadID, "reason", "blob written by single request has no block list")
+	}
+
+	ids := make([]string, len(bl.CommittedBlocks))
+	for i, b := range bl.CommittedBlocks {
+		ids[i] = deref(b.Name)
+	}
+
+	// scope of the blob is kept even if the object does not specify it.
+	if scope == nil && props.EncryptionScope != nil {
+		scope = &blob.CPKScopeInfo{EncryptionScope: props.EncryptionScope}
+	}
+
+	var tier *blob.AccessTier
+	if props.AccessTier != nil && !deref(props.AccessTierInferred) {
+		tier = to.Ptr(blob.AccessTier(*props.AccessTier))
+	}
+
+	_, err = bc.CommitBlockList(ctx, ids, &blockblob.CommitBlockListOptions{
+		Metadata: props.Metadata,
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType:        props.ContentType,
+			BlobContentEncoding:    props.ContentEncoding,
+			BlobContentLanguage:    props.ContentLanguage,
+			BlobContentDisposition: props.ContentDisposition,
+			BlobCacheControl:       props.CacheControl,
+			BlobContentMD5:         props.ContentMD5,
+		},
+		Tier:         tier,
+		CPKInfo:      cpk,
+		CPKScopeInfo: scope,
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: props.ETag},
+		},
+	})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(503).Msg("failed to commit block list")
+	}
+	return nil
+}
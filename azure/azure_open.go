@@ -0,0 +1,56 @@
+package azure
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/axkit/bsw"
+)
+
+func init() {
+	bsw.Register("azure", Open)
+}
+
+// Open creates and initializes Service configured by URL
+//
+//	azure://account/container
+//	azure://account?bucketMode=container
+//
+// Buckets are mapped to containers if the container is not given. Account
+// key is taken from AZURE_STORAGE_KEY, connection string from
+// AZURE_STORAGE_CONNECTION_STRING if account is not given. Azure AD is used
+// if there is neither. Query parameters: serviceUrl, bucketMode, tenantId
+// and clientId.
+func Open(ctx context.Context, u *url.URL) (bsw.BlockStorageWrapper, error) {
+
+	q := u.Query()
+
+	cfg := Config{
+		AccountName:   u.Host,
+		ContainerName: strings.Trim(u.Path, "/"),
+		ServiceURL:    q.Get("serviceUrl"),
+		BucketMode:    BucketMode(q.Get("bucketMode")),
+		AzureAD: AzureADConfig{
+			TenantID: q.Get("tenantId"),
+			ClientID: q.Get("clientId"),
+		},
+	}
+
+	if cfg.AccountName == "" {
+		cfg.ConnectionString = os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	} else {
+		cfg.AccountKey = os.Getenv("AZURE_STORAGE_KEY")
+	}
+
+	if cfg.BucketMode == "" && cfg.ContainerName == "" {
+		cfg.BucketMode = BucketAsContainer
+	}
+
+	s := New(&cfg)
+	if err := s.Init(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
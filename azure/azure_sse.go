@@ -0,0 +1,61 @@
+package azure
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/axkit/bsw"
+)
+
+// encryptionOf returns customer provided key and encryption scope of the
+// object. Azure encrypts blobs by the account key by default, so managed
+// encryption needs no parameters. KMS keys are supported by Azure through
+// encryption scopes only.
+func encryptionOf(o *bsw.Object) (*blob.CPKInfo, *blob.CPKScopeInfo, error) {
+
+	e := o.Encryption()
+	if err := e.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	switch e.Mode {
+	case bsw.SSECustomer:
+		return &blob.CPKInfo{
+			EncryptionKey:       to.Ptr(e.CustomerKeyBase64()),
+			EncryptionKeySHA256: to.Ptr(e.CustomerKeySHA256()),
+			EncryptionAlgorithm: to.Ptr(blob.EncryptionAlgorithmTypeAES256),
+		}, nil, nil
+	case bsw.SSEScope:
+		return nil, &blob.CPKScopeInfo{EncryptionScope: to.Ptr(e.KeyID)}, nil
+	case bsw.SSEKMS:
+		return nil, nil, bsw.ErrNotSupported.Capture().SetPairs("backend", "azure", "encryption", e.Mode)
+	}
+	return nil, nil, nil
+}
+
+// encryptionHeaders returns encryption headers the client must send with
+// SAS requests. Upload requests need all of them, downloads customer key only.
+func encryptionHeaders(e bsw.Encryption, upload bool) map[string]string {
+
+	res := make(map[string]string)
+	switch e.Mode {
+	case bsw.SSECustomer:
+		res["x-ms-encryption-key"] = e.CustomerKeyBase64()
+		res["x-ms-encryption-key-sha256"] = e.CustomerKeySHA256()
+		res["x-ms-encryption-algorithm"] = string(blob.EncryptionAlgorithmTypeAES256)
+	case bsw.SSEScope:
+		if upload {
+			res["x-ms-encryption-scope"] = e.KeyID
+		}
+	}
+	return res
+}
+
+// DownloadHeaders returns headers the client must send with GET request to
+// SAS URL. Only blobs encrypted by customer provided key require them.
+func (s *Service) DownloadHeaders(o *bsw.Object) map[string]string {
+	res := encryptionHeaders(o.Encryption(), false)
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -2,12 +2,23 @@ package azure_test
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/axkit/bsw"
 	"github.com/axkit/bsw/azure"
+	"github.com/axkit/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // MockService is a mock implementation of the BlockStorageWrapper interface.
@@ -98,3 +109,400 @@ func TestPreSignGetObjectURL(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "https://mock.blob.core.windows.net/mockContainer/mockBlob", url)
 }
+
+func TestUploadURLContextMultipart(t *testing.T) {
+	mockService := NewMockService()
+	object := bsw.NewObject(mockService, "mockBucket", "mockBlob", bsw.WithMultiParts(2))
+
+	_, err := object.UploadURLContext(context.Background(), 15*time.Minute)
+	assert.True(t, errors.Is(err, bsw.ErrWrongInvocation))
+}
+
+func newService(t *testing.T) *azure.Service {
+	t.Helper()
+	s := azure.New(&azure.Config{
+		AccountName:   "devstoreaccount1",
+		AccountKey:    "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		ContainerName: "media",
+	})
+	require.NoError(t, s.Init(context.Background()))
+	return s
+}
+
+func TestService_PreSignMultipartObjectURL(t *testing.T) {
+	s := newService(t)
+	o := bsw.NewObject(s, "videos", "movie.mp4", bsw.WithMultiParts(3))
+
+	urls, uploadID, err := o.MultipartUploadURLsContext(context.Background(), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, urls, 3)
+	assert.Len(t, uploadID, 24)
+
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		pu, err := url.Parse(u)
+		require.NoError(t, err)
+		assert.Equal(t, "/media/videos/movie.mp4", pu.Path)
+		assert.Equal(t, "block", pu.Query().Get("comp"))
+		assert.NotEmpty(t, pu.Query().Get("sig"))
+		seen[pu.Query().Get("blockid")] = true
+	}
+	assert.Len(t, seen, 3)
+
+	_, _, err = bsw.NewObject(s, "videos", "movie.mp4", bsw.WithMultiParts(0)).MultipartUploadURLs(time.Hour)
+	assert.Error(t, err)
+}
+
+func TestService_PreSignHeaders(t *testing.T) {
+	s := newService(t)
+
+	o := bsw.NewObject(s, "docs", "report.pdf", bsw.WithResponseHeaders(bsw.Headers{
+		ContentType:        "application/pdf",
+		ContentDisposition: `attachment; filename="report.pdf"`,
+	}))
+	u, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+
+	pu, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "/media/docs/report.pdf", pu.Path)
+	assert.Equal(t, "application/pdf", pu.Query().Get("rsct"))
+	assert.Equal(t, `attachment; filename="report.pdf"`, pu.Query().Get("rscd"))
+	assert.Equal(t, "r", pu.Query().Get("sp"))
+	assert.NotEmpty(t, pu.Query().Get("sig"))
+
+	o = bsw.NewObject(s, "docs", "report.pdf", bsw.WithContentType("application/pdf")).SetMetadata("filename", "report.pdf")
+	assert.Equal(t, map[string]string{
+		"x-ms-blob-type":         "BlockBlob",
+		"x-ms-blob-content-type": "application/pdf",
+		"x-ms-meta-filename":     "report.pdf",
+	}, o.UploadHeaders())
+}
+
+func TestService_Encryption(t *testing.T) {
+	s := newService(t)
+
+	o := bsw.NewObject(s, "videos", "movie.mp4", bsw.WithEncryptionScope("media-scope"))
+	u, err := o.UploadURL(time.Hour)
+	require.NoError(t, err)
+	pu, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "media-scope", pu.Query().Get("ses"))
+	assert.Equal(t, "media-scope", o.UploadHeaders()["x-ms-encryption-scope"])
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	o = bsw.NewObject(s, "videos", "movie.mp4", bsw.WithSSECustomer(key))
+	h := o.DownloadHeaders()
+	assert.Equal(t, "AES256", h["x-ms-encryption-algorithm"])
+	assert.Equal(t, o.Encryption().CustomerKeySHA256(), h["x-ms-encryption-key-sha256"])
+
+	_, err = bsw.NewObject(s, "videos", "movie.mp4", bsw.WithSSEKMS("key", nil)).UploadURL(time.Hour)
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+}
+
+func TestService_ConnectionString(t *testing.T) {
+	ctx := context.Background()
+	o := func(s *azure.Service) *bsw.Object { return bsw.NewObject(s, "docs", "a.txt") }
+
+	s := azure.New(&azure.Config{ConnectionString: "UseDevelopmentStorage=true", ContainerName: "media"})
+	require.NoError(t, s.Init(ctx))
+	u, err := s.PreSignGetObjectURL(o(s), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "http://127.0.0.1:10000/devstoreaccount1/media/docs%2Fa.txt?"), u)
+
+	s = azure.New(&azure.Config{
+		ConnectionString: "DefaultEndpointsProtocol=https;AccountName=acme;AccountKey=" + base64.StdEncoding.EncodeToString([]byte("secret")) + ";EndpointSuffix=core.chinacloudapi.cn",
+		ContainerName:    "media",
+	})
+	require.NoError(t, s.Init(ctx))
+	u, err = s.PreSignGetObjectURL(o(s), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "https://acme.blob.core.chinacloudapi.cn/media/docs%2Fa.txt?"), u)
+
+	s = azure.New(&azure.Config{
+		AccountName:   "acme",
+		AccountKey:    base64.StdEncoding.EncodeToString([]byte("secret")),
+		ServiceURL:    "https://storage.internal:8443/acme",
+		ContainerName: "media",
+	})
+	require.NoError(t, s.Init(ctx))
+	u, err = s.PreSignPutObjectURL(o(s), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "https://storage.internal:8443/acme/media/docs%2Fa.txt?"), u)
+
+	s = azure.New(&azure.Config{
+		ConnectionString: "BlobEndpoint=https://acme.blob.core.windows.net;SharedAccessSignature=sv=2021-08-06&ss=b&sig=abc",
+		ContainerName:    "media",
+	})
+	require.NoError(t, s.Init(ctx))
+	_, err = s.PreSignGetObjectURL(o(s), time.Hour)
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+
+	s = azure.New(&azure.Config{ConnectionString: "AccountName=acme", ContainerName: "media"})
+	assert.Error(t, s.Init(ctx))
+}
+
+// staticToken is azcore.TokenCredential returning the same token.
+type staticToken string
+
+func (t staticToken) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(t), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestService_UserDelegationSAS(t *testing.T) {
+	var calls int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "userdelegationkey", r.URL.Query().Get("comp"))
+		assert.Equal(t, "Bearer aad-token", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><UserDelegationKey>
+<SignedOid>11111111-1111-1111-1111-111111111111</SignedOid><SignedTid>22222222-2222-2222-2222-222222222222</SignedTid>
+<SignedStart>%s</SignedStart><SignedExpiry>%s</SignedExpiry><SignedService>b</SignedService><SignedVersion>2021-08-06</SignedVersion>
+<Value>%s</Value></UserDelegationKey>`, time.Now().UTC().Format(time.RFC3339), time.Now().Add(24*time.Hour).UTC().Format(time.RFC3339),
+			base64.StdEncoding.EncodeToString([]byte("delegation-key")))
+	}))
+	defer srv.Close()
+
+	s := azure.New(&azure.Config{
+		AccountName:     "acme",
+		ServiceURL:      srv.URL + "/acme",
+		ContainerName:   "media",
+		TokenCredential: staticToken("aad-token"),
+		ClientOptions:   &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: srv.Client()}},
+	})
+	require.NoError(t, s.Init(context.Background()))
+
+	o := bsw.NewObject(s, "docs", "a.txt")
+	u, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	pu, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "11111111-1111-1111-1111-111111111111", pu.Query().Get("skoid"))
+	assert.Equal(t, "22222222-2222-2222-2222-222222222222", pu.Query().Get("sktid"))
+	assert.NotEmpty(t, pu.Query().Get("sig"))
+
+	_, err = s.PreSignPutObjectURL(o, 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls, "delegation key must be reused")
+
+	_, err = s.PreSignPutObjectURL(o, 8*24*time.Hour)
+	assert.Error(t, err)
+}
+
+func TestService_BucketAsContainer(t *testing.T) {
+	s := azure.New(&azure.Config{
+		ConnectionString: "UseDevelopmentStorage=true",
+		BucketMode:       azure.BucketAsContainer,
+	})
+	require.NoError(t, s.Init(context.Background()))
+
+	u, err := s.PreSignGetObjectURL(bsw.NewObject(s, "invoices", "2024/01.pdf"), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "http://127.0.0.1:10000/devstoreaccount1/invoices/2024%2F01.pdf?"), u)
+
+	_, err = s.PreSignGetObjectURL(bsw.NewObject(s, "Invoices_2024", "01.pdf"), time.Hour)
+	assert.Error(t, err)
+
+	s = azure.New(&azure.Config{
+		ConnectionString:  "UseDevelopmentStorage=true",
+		BucketMode:        azure.BucketAsContainer,
+		ContainerNameFunc: func(bucket string) string { return "tenant-" + strings.ToLower(bucket) },
+	})
+	require.NoError(t, s.Init(context.Background()))
+
+	u, err = s.PreSignPutObjectURL(bsw.NewObject(s, "ACME", "a.txt"), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "http://127.0.0.1:10000/devstoreaccount1/tenant-acme/a.txt?"), u)
+
+	s = azure.New(&azure.Config{ConnectionString: "UseDevelopmentStorage=true", BucketMode: "bucket"})
+	assert.Error(t, s.Init(context.Background()))
+}
+
+func TestValidateContainerName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"media":                 true,
+		"a1-b2-c3":              true,
+		"$root":                 true,
+		"ab":                    false,
+		"Media":                 false,
+		"-media":                false,
+		"media-":                false,
+		"me--dia":               false,
+		"me_dia":                false,
+		"media.old":             false,
+		strings.Repeat("a", 63): true,
+		strings.Repeat("a", 64): false,
+	} {
+		assert.Equal(t, ok, azure.ValidateContainerName(name) == nil, name)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_KEY", "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==")
+
+	w, err := bsw.Open(context.Background(), "azure://devstoreaccount1/media?serviceUrl=http://127.0.0.1:10000/devstoreaccount1")
+	require.NoError(t, err)
+	u, err := w.PreSignGetObjectURL(bsw.NewObject(w, "docs", "a.txt"), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "http://127.0.0.1:10000/devstoreaccount1/media/docs%2Fa.txt?"), u)
+
+	w, err = bsw.Open(context.Background(), "azure://devstoreaccount1?serviceUrl=http://127.0.0.1:10000/devstoreaccount1")
+	require.NoError(t, err)
+	u, err = w.PreSignGetObjectURL(bsw.NewObject(w, "docs", "a.txt"), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "http://127.0.0.1:10000/devstoreaccount1/docs/a.txt?"), u)
+}
+
+func TestService_CopyReplaceHeaders(t *testing.T) {
+	var props http.Header
+	var copyMeta string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+			copyMeta = r.Header.Get("x-ms-meta-owner")
+			w.Header().Set("x-ms-copy-id", "c1")
+			w.Header().Set("x-ms-copy-status", "success")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "properties":
+			props = r.Header.Clone()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	s := azure.New(&azure.Config{
+		AccountName:   "devstoreaccount1",
+		AccountKey:    "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		ServiceURL:    srv.URL + "/devstoreaccount1",
+		ContainerName: "media",
+	})
+	require.NoError(t, s.Init(context.Background()))
+
+	src := bsw.NewObject(s, "docs", "a.pdf")
+	dst := bsw.NewObject(s, "docs", "b.pdf", bsw.WithContentType("application/pdf"), bsw.WithCacheControl("max-age=600")).
+		SetMetadata("owner", "42")
+
+	require.NoError(t, s.Copy(context.Background(), src, dst, bsw.CopyOptions{ReplaceMetadata: true}))
+	assert.Equal(t, "42", copyMeta)
+	require.NotNil(t, props)
+	assert.Equal(t, "application/pdf", props.Get("x-ms-blob-content-type"))
+	assert.Equal(t, "max-age=600", props.Get("x-ms-blob-cache-control"))
+
+	props = nil
+	require.NoError(t, s.Copy(context.Background(), src, dst, bsw.CopyOptions{}))
+	assert.Nil(t, props, "headers are kept without ReplaceMetadata")
+}
+
+func TestService_ListStartAfter(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="media"><Blobs>`+
+			`<Blob><Name>docs/a/1.txt</Name><Properties><Content-Length>1</Content-Length></Properties></Blob>`+
+			`<Blob><Name>docs/a/2.txt</Name><Properties><Content-Length>1</Content-Length></Properties></Blob>`+
+			`</Blobs><NextMarker/></EnumerationResults>`)
+	}))
+	defer srv.Close()
+
+	s := azure.New(&azure.Config{
+		AccountName:   "devstoreaccount1",
+		AccountKey:    "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		ServiceURL:    srv.URL + "/devstoreaccount1",
+		ContainerName: "media",
+	})
+	require.NoError(t, s.Init(context.Background()))
+
+	keys := func(opts bsw.ListOptions) []string {
+		var res []string
+		it := s.List(context.Background(), "docs", opts)
+		for it.Next() {
+			res = append(res, it.Info().Key)
+		}
+		require.NoError(t, it.Err())
+		return res
+	}
+
+	assert.Equal(t, []string{"a/2.txt"}, keys(bsw.ListOptions{Prefix: "a/", StartAfter: "a/1.txt"}))
+	assert.Equal(t, 1, requests)
+
+	assert.Empty(t, keys(bsw.ListOptions{Prefix: "a/", StartAfter: "b"}))
+	assert.Equal(t, 1, requests, "no blob with the prefix follows StartAfter")
+}
+
+func TestService_AbortMultipartUpload(t *testing.T) {
+	var (
+		blockID   string
+		committed string
+		commit    http.Header
+		deleted   http.Header
+		missing   = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodGet && q.Get("comp") == "blocklist":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks>%s</CommittedBlocks>`+
+				`<UncommittedBlocks><Block><Name>%s</Name><Size>5</Size></Block></UncommittedBlocks></BlockList>`, committed, blockID)
+		case r.Method == http.MethodHead && missing:
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"0x1"`)
+			w.Header().Set("x-ms-access-tier", "Cool")
+			w.Header().Set("x-ms-encryption-scope", "tenant-scope")
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+			commit = r.Header.Clone()
+			w.Header().Set("ETag", `"0x2"`)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete:
+			deleted = r.Header.Clone()
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	s := azure.New(&azure.Config{
+		AccountName:   "devstoreaccount1",
+		AccountKey:    "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		ServiceURL:    srv.URL + "/devstoreaccount1",
+		ContainerName: "media",
+	})
+	require.NoError(t, s.Init(context.Background()))
+
+	o := bsw.NewObject(s, "docs", "a.txt", bsw.WithMultiParts(1))
+	urls, uploadID, err := s.PreSignMultipartObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	pu, err := url.Parse(urls[0])
+	require.NoError(t, err)
+	blockID = pu.Query().Get("blockid")
+
+	// empty blob is created unless it exists and deleted unless it's replaced.
+	require.NoError(t, s.AbortMultipartUpload(context.Background(), o, uploadID))
+	require.NotNil(t, commit)
+	assert.Equal(t, "*", commit.Get("If-None-Match"))
+	require.NotNil(t, deleted)
+	assert.Equal(t, `"0x2"`, deleted.Get("If-Match"))
+	commit, missing = nil, false
+
+	// blob written by single Put Blob request has no block list.
+	err = s.AbortMultipartUpload(context.Background(), o, uploadID)
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+	assert.Nil(t, commit)
+
+	committed = `<Block><Name>AAAA</Name><Size>5</Size></Block>`
+	require.NoError(t, s.AbortMultipartUpload(context.Background(), o, uploadID))
+	require.NotNil(t, commit)
+	assert.Equal(t, "Cool", commit.Get("x-ms-access-tier"))
+	assert.Equal(t, "tenant-scope", commit.Get("x-ms-encryption-scope"))
+	assert.Equal(t, `"0x1"`, commit.Get("If-Match"))
+}
@@ -1,6 +1,8 @@
 package bsw
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"github.com/axkit/errors"
@@ -8,12 +10,64 @@ import (
 
 var ErrWrongInvocation = errors.New("wrong invocation").Critical()
 
+// ErrNotFound is returned when requested object does not exist.
+var ErrNotFound = errors.New("object not found").StatusCode(404)
+
 type BlockStorageWrapper interface {
 	Name() string
 	PreSignPutObjectURL(o *Object, timeout time.Duration) (string, error)
 	PreSignMultipartObjectURL(o *Object, timeout time.Duration) (urls []string, uploadID string, err error)
 	CompleteMultipartUpload(o *Object, uploadID string, parts []CompletedPart) error
 	PreSignGetObjectURL(o *Object, timeout time.Duration) (string, error)
+
+	// Context aware versions of the methods above. Backends making network
+	// calls must respect ctx cancellation and deadline.
+	PreSignPutObjectURLContext(ctx context.Context, o *Object, timeout time.Duration) (string, error)
+	PreSignMultipartObjectURLContext(ctx context.Context, o *Object, timeout time.Duration) (urls []string, uploadID string, err error)
+	CompleteMultipartUploadContext(ctx context.Context, o *Object, uploadID string, parts []CompletedPart) error
+	PreSignGetObjectURLContext(ctx context.Context, o *Object, timeout time.Duration) (string, error)
+
+	// AbortMultipartUpload aborts multipart upload and frees storage used by uploaded parts.
+	AbortMultipartUpload(ctx context.Context, o *Object, uploadID string) error
+
+	// ListMultipartUploads returns multipart uploads in progress with keys starting with prefix.
+	ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]MultipartUpload, error)
+
+	// ListParts returns parts uploaded within multipart upload ordered by part number.
+	ListParts(ctx context.Context, o *Object, uploadID string) ([]Part, error)
+
+	// Put writes content of r to the object. If size is negative, r is
+	// read till io.EOF.
+	Put(ctx context.Context, o *Object, r io.Reader, size int64) error
+
+	// Get returns object content. Caller must close returned reader.
+	Get(ctx context.Context, o *Object) (io.ReadCloser, ObjectInfo, error)
+
+	// Stat returns object attributes without reading its content.
+	// ErrNotFound is returned if the object does not exist.
+	Stat(ctx context.Context, o *Object) (ObjectInfo, error)
+
+	// Copy copies src object to dst within the backend. Use package level
+	// Copy to copy objects between different backends.
+	Copy(ctx context.Context, src, dst *Object, opts CopyOptions) error
+
+	// Move moves src object to dst within the backend.
+	Move(ctx context.Context, src, dst *Object, opts CopyOptions) error
+
+	// List returns iterator over objects stored in the bucket.
+	List(ctx context.Context, bucket string, opts ListOptions) *ListIterator
+
+	// Delete removes the object. Deletion of not existing object is not an error.
+	Delete(ctx context.Context, o *Object) error
+
+	// DeleteMany removes objects identified by keys from the bucket. Keys which
+	// were not deleted are returned together with the reason. Returned error
+	// reports failure of the operation as a whole.
+	DeleteMany(ctx context.Context, bucket string, keys []string) ([]DeleteError, error)
+
+	// DeletePrefix removes all objects from the bucket with keys starting with prefix.
+	// Failures are reported the same way as by DeleteMany.
+	DeletePrefix(ctx context.Context, bucket, prefix string) ([]DeleteError, error)
 }
 
 type CompletedPart interface {
@@ -80,6 +134,18 @@ type Object struct {
 	metadata  map[string]*string
 	url       string
 	parts     int
+
+	headers     Headers
+	respHeaders Headers
+	encryption  Encryption
+}
+
+// Bind returns copy of the object served by w. Decorators of
+// BlockStorageWrapper use it to pass objects to the wrapped backend.
+func (o *Object) Bind(w BlockStorageWrapper) *Object {
+	res := *o
+	res.w = w
+	return &res
 }
 
 func (o *Object) Key() string {
@@ -113,8 +179,41 @@ func (o *Object) UploadURL(timeout time.Duration) (string, error) {
 	return o.w.PreSignPutObjectURL(o, timeout)
 }
 
+// UploadURLContext is like UploadURL but passes ctx to the backend.
+func (o *Object) UploadURLContext(ctx context.Context, timeout time.Duration) (string, error) {
+	if o.parts > 1 {
+		return "", ErrWrongInvocation.Capture().Set("parts", o.parts)
+	}
+	return o.w.PreSignPutObjectURLContext(ctx, o, timeout)
+}
+
 // MultipartUploadURLs returns presigned URLs for PUT object request by parts.
 // When all parts uploaded, call CompleteMultipartUpload to merge parts into a single file.
 func (o *Object) MultipartUploadURLs(timeout time.Duration) (urls []string, uploadID string, err error) {
 	return o.w.PreSignMultipartObjectURL(o, timeout)
 }
+
+// MultipartUploadURLsContext is like MultipartUploadURLs but passes ctx to the backend.
+func (o *Object) MultipartUploadURLsContext(ctx context.Context, timeout time.Duration) (urls []string, uploadID string, err error) {
+	return o.w.PreSignMultipartObjectURLContext(ctx, o, timeout)
+}
+
+// Put writes content of r to the object.
+func (o *Object) Put(ctx context.Context, r io.Reader, size int64) error {
+	return o.w.Put(ctx, o, r, size)
+}
+
+// Get returns object content. Caller must close returned reader.
+func (o *Object) Get(ctx context.Context) (io.ReadCloser, ObjectInfo, error) {
+	return o.w.Get(ctx, o)
+}
+
+// Stat returns object attributes.
+func (o *Object) Stat(ctx context.Context) (ObjectInfo, error) {
+	return o.w.Stat(ctx, o)
+}
+
+// Delete removes the object.
+func (o *Object) Delete(ctx context.Context) error {
+	return o.w.Delete(ctx, o)
+}
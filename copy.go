@@ -0,0 +1,75 @@
+package bsw
+
+import "context"
+
+// CopyOptions describes parameters of copying.
+type CopyOptions struct {
+	// ReplaceMetadata replaces metadata of the destination object with
+	// dst.Metadata(). Source object metadata is kept otherwise.
+	ReplaceMetadata bool
+}
+
+// Copy copies src object to dst. If both objects are served by the same
+// BlockStorageWrapper, server side copy is used. Otherwise the content is
+// streamed from src to dst through the application.
+func Copy(ctx context.Context, src, dst *Object, opts CopyOptions) error {
+	if src.w == dst.w {
+		return src.w.Copy(ctx, src, dst, opts)
+	}
+	return streamCopy(ctx, src, dst, opts)
+}
+
+// Move moves src object to dst. Object is renamed or copied by the backend if
+// both objects are served by the same BlockStorageWrapper. Otherwise the content
+// is streamed and src is deleted afterwards.
+func Move(ctx context.Context, src, dst *Object, opts CopyOptions) error {
+	if src.w == dst.w {
+		return src.w.Move(ctx, src, dst, opts)
+	}
+
+	if err := streamCopy(ctx, src, dst, opts); err != nil {
+		return err
+	}
+	return src.w.Delete(ctx, src)
+}
+
+// streamCopy puts content of src to dst. Headers of src are kept unless
+// they are set for dst or ReplaceMetadata is set, content type is kept
+// unless it's set for dst.
+func streamCopy(ctx context.Context, src, dst *Object, opts CopyOptions) error {
+
+	rc, oi, err := src.w.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	d := *dst
+	if !opts.ReplaceMetadata {
+		d.metadata = oi.Metadata
+		d.headers = mergeHeaders(d.headers, oi.Headers())
+	} else if d.headers.ContentType == "" {
+		d.headers.ContentType = oi.ContentType
+	}
+	return dst.w.Put(ctx, &d, rc, oi.Size)
+}
+
+// mergeHeaders returns h with empty headers taken from def.
+func mergeHeaders(h, def Headers) Headers {
+	if h.ContentType == "" {
+		h.ContentType = def.ContentType
+	}
+	if h.ContentDisposition == "" {
+		h.ContentDisposition = def.ContentDisposition
+	}
+	if h.CacheControl == "" {
+		h.CacheControl = def.CacheControl
+	}
+	if h.ContentEncoding == "" {
+		h.ContentEncoding = def.ContentEncoding
+	}
+	if h.ContentLanguage == "" {
+		h.ContentLanguage = def.ContentLanguage
+	}
+	return h
+}
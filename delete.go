@@ -0,0 +1,11 @@
+package bsw
+
+// DeleteError describes failed deletion of a single object.
+type DeleteError struct {
+	Key string
+	Err error
+}
+
+func (e DeleteError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
@@ -0,0 +1,140 @@
+package bsw
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/axkit/errors"
+)
+
+// EncryptionMode is the kind of server-side encryption of the object.
+type EncryptionMode string
+
+const (
+	// SSEManaged encrypts the object by the key managed by the storage:
+	// SSE-S3 on S3, the default on Azure, the at rest key on fs.
+	SSEManaged EncryptionMode = "managed"
+
+	// SSEKMS encrypts the object by the KMS key, S3 only.
+	SSEKMS EncryptionMode = "kms"
+
+	// SSECustomer encrypts the object by the key provided by the client
+	// with every request: SSE-C on S3, CPK on Azure.
+	SSECustomer EncryptionMode = "customer"
+
+	// SSEScope encrypts the object by the encryption scope, Azure only.
+	SSEScope EncryptionMode = "scope"
+)
+
+// customerKeySize is the size of AES-256 key required for SSECustomer.
+const customerKeySize = 32
+
+// Encryption describes server-side encryption of the object.
+type Encryption struct {
+	Mode EncryptionMode `json:"mode,omitempty"`
+
+	// KeyID is the KMS key ID or ARN for SSEKMS, empty for the account's
+	// default key, or the encryption scope name for SSEScope.
+	KeyID string `json:"keyId,omitempty"`
+
+	// Context is the KMS encryption context for SSEKMS.
+	Context map[string]string `json:"context,omitempty"`
+
+	// CustomerKey is AES-256 key for SSECustomer. It's never stored.
+	CustomerKey []byte `json:"-"`
+}
+
+// IsZero returns true if no encryption is requested.
+func (e Encryption) IsZero() bool {
+	return e.Mode == ""
+}
+
+// Validate checks the mode and its parameters.
+func (e Encryption) Validate() error {
+	switch e.Mode {
+	case "", SSEManaged, SSEKMS:
+	case SSECustomer:
+		if len(e.CustomerKey) != customerKeySize {
+			return errors.ValidationFailed("customer key must be 256 bits").Set("size", len(e.CustomerKey))
+		}
+	case SSEScope:
+		if e.KeyID == "" {
+			return errors.ValidationFailed("encryption scope is empty")
+		}
+	default:
+		return errors.ValidationFailed("unknown encryption mode").Set("mode", e.Mode)
+	}
+	return nil
+}
+
+// CustomerKeyBase64 returns base64 encoded customer key.
+func (e Encryption) CustomerKeyBase64() string {
+	return base64.StdEncoding.EncodeToString(e.CustomerKey)
+}
+
+// CustomerKeyMD5 returns base64 encoded MD5 of the customer key.
+func (e Encryption) CustomerKeyMD5() string {
+	sum := md5.Sum(e.CustomerKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CustomerKeySHA256 returns base64 encoded SHA-256 of the customer key.
+func (e Encryption) CustomerKeySHA256() string {
+	sum := sha256.Sum256(e.CustomerKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WithEncryption sets server-side encryption of the object.
+func WithEncryption(e Encryption) Option {
+	return func(o *Object) {
+		o.encryption = e
+	}
+}
+
+// WithSSEManaged encrypts the object by the key managed by the storage.
+func WithSSEManaged() Option {
+	return WithEncryption(Encryption{Mode: SSEManaged})
+}
+
+// WithSSEKMS encrypts the object by KMS key keyID with encryption context ctx.
+func WithSSEKMS(keyID string, ctx map[string]string) Option {
+	return WithEncryption(Encryption{Mode: SSEKMS, KeyID: keyID, Context: ctx})
+}
+
+// WithSSECustomer encrypts the object by 256 bit customer key. The same key
+// must be provided to read the object.
+func WithSSECustomer(key []byte) Option {
+	return WithEncryption(Encryption{Mode: SSECustomer, CustomerKey: key})
+}
+
+// WithEncryptionScope encrypts the object by the encryption scope.
+func WithEncryptionScope(scope string) Option {
+	return WithEncryption(Encryption{Mode: SSEScope, KeyID: scope})
+}
+
+// SetEncryption sets server-side encryption of the object.
+func (o *Object) SetEncryption(e Encryption) *Object {
+	o.encryption = e
+	return o
+}
+
+// Encryption returns server-side encryption of the object.
+func (o *Object) Encryption() Encryption {
+	return o.encryption
+}
+
+// DownloadHeaderer is implemented by backends requiring the client to send
+// headers with presigned GET request, e.g. customer encryption key.
+type DownloadHeaderer interface {
+	DownloadHeaders(o *Object) map[string]string
+}
+
+// DownloadHeaders returns headers the client must send with presigned GET
+// request of the object. Returns nil if the backend requires none.
+func (o *Object) DownloadHeaders() map[string]string {
+	if dh, ok := o.w.(DownloadHeaderer); ok {
+		return dh.DownloadHeaders(o)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package bsw_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/axkit/bsw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryption_Validate(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+
+	assert.NoError(t, bsw.Encryption{}.Validate())
+	assert.NoError(t, bsw.Encryption{Mode: bsw.SSEManaged}.Validate())
+	assert.NoError(t, bsw.Encryption{Mode: bsw.SSEKMS, KeyID: "alias/app"}.Validate())
+	assert.NoError(t, bsw.Encryption{Mode: bsw.SSECustomer, CustomerKey: key}.Validate())
+
+	assert.Error(t, bsw.Encryption{Mode: bsw.SSECustomer, CustomerKey: key[:16]}.Validate())
+	assert.Error(t, bsw.Encryption{Mode: bsw.SSEScope}.Validate())
+	assert.Error(t, bsw.Encryption{Mode: "rot13"}.Validate())
+
+	o := bsw.NewObject(plainBackend{}, "b", "k", bsw.WithSSECustomer(key))
+	e := o.Encryption()
+	assert.Equal(t, bsw.SSECustomer, e.Mode)
+	assert.Equal(t, "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=", e.CustomerKeyBase64())
+	assert.NotEmpty(t, e.CustomerKeyMD5())
+	assert.NotEmpty(t, e.CustomerKeySHA256())
+	assert.Nil(t, o.DownloadHeaders())
+}
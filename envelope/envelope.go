@@ -0,0 +1,362 @@
+// Package envelope implements client side encryption of objects stored by any
+// BlockStorageWrapper. Content is encrypted by random data key generated for
+// every object, the data key is wrapped by key encryption key (KEK) and stored
+// in object metadata. The backend never sees plaintext or unwrapped keys.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// Metadata entries holding wrapped data key and ID of the KEK. Names have
+// no separators, Azure allows only C# identifiers as metadata names.
+const (
+	MetaWrappedKey = "envelopekey"
+	MetaKEKID      = "envelopekek"
+)
+
+// ErrNotEncrypted is returned when the object read has no wrapped data key.
+var ErrNotEncrypted = errors.New("object is not encrypted by envelope").Critical().StatusCode(500)
+
+// Wrapper is BlockStorageWrapper encrypting content before passing it to the
+// wrapped backend and decrypting it after. Presigned URLs are not supported,
+// clients would upload plaintext or download ciphertext by them.
+type Wrapper struct {
+	w  bsw.BlockStorageWrapper
+	kk KeyProvider
+}
+
+var (
+	_ bsw.BlockStorageWrapper = (*Wrapper)(nil)
+	_ bsw.RangeGetter         = (*Wrapper)(nil)
+)
+
+// New returns Wrapper storing objects encrypted by data keys wrapped by kp in w.
+func New(w bsw.BlockStorageWrapper, kp KeyProvider) *Wrapper {
+	return &Wrapper{w: w, kk: kp}
+}
+
+// Unwrap returns the wrapped backend.
+func (w *Wrapper) Unwrap() bsw.BlockStorageWrapper {
+	return w.w
+}
+
+func (w *Wrapper) Name() string {
+	return w.w.Name()
+}
+
+func (w *Wrapper) notSupported(op string) error {
+	return bsw.ErrNotSupported.Capture().SetPairs("backend", w.w.Name(), "op", op, "reason", "client side encryption")
+}
+
+func (w *Wrapper) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return "", w.notSupported("presign put")
+}
+
+func (w *Wrapper) PreSignPutObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+	return "", w.notSupported("presign put")
+}
+
+func (w *Wrapper) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+	return nil, "", w.notSupported("presign multipart")
+}
+
+func (w *Wrapper) PreSignMultipartObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+	return nil, "", w.notSupported("presign multipart")
+}
+
+func (w *Wrapper) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+	return w.notSupported("complete multipart")
+}
+
+func (w *Wrapper) CompleteMultipartUploadContext(ctx context.Context, o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+	return w.notSupported("complete multipart")
+}
+
+func (w *Wrapper) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return "", w.notSupported("presign get")
+}
+
+func (w *Wrapper) PreSignGetObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+	return "", w.notSupported("presign get")
+}
+
+// AbortMultipartUpload aborts multipart upload of the wrapped backend.
+func (w *Wrapper) AbortMultipartUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+	return w.w.AbortMultipartUpload(ctx, o.Bind(w.w), uploadID)
+}
+
+// ListMultipartUploads lists multipart uploads of the wrapped backend.
+func (w *Wrapper) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]bsw.MultipartUpload, error) {
+	return w.w.ListMultipartUploads(ctx, bucket, prefix)
+}
+
+// ListParts lists parts of multipart upload of the wrapped backend.
+func (w *Wrapper) ListParts(ctx context.Context, o *bsw.Object, uploadID string) ([]bsw.Part, error) {
+	return w.w.ListParts(ctx, o.Bind(w.w), uploadID)
+}
+
+// Put encrypts content of r by new data key and writes it to the wrapped
+// backend together with the wrapped data key.
+func (w *Wrapper) Put(ctx context.Context, o *bsw.Object, r io.Reader, size int64) error {
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return errors.Catch(err).Critical().StatusCode(500).Msg("generating data key failed")
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	kekID, wrapped, err := w.kk.WrapKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 {
+		size = sealedSize(size)
+	}
+
+	d := o.Bind(w.w).ReplaceMetadata(withEnvelope(o.Metadata(), kekID, wrapped))
+	return w.w.Put(ctx, d, newSealReader(aead, r), size)
+}
+
+// Get returns decrypted content of the object.
+func (w *Wrapper) Get(ctx context.Context, o *bsw.Object) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	rc, oi, err := w.w.Get(ctx, o.Bind(w.w))
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	aead, oi, err := w.open(ctx, oi)
+	if err != nil {
+		rc.Close()
+		return nil, bsw.ObjectInfo{}, err
+	}
+	return newOpenReader(aead, rc, 0, lastChunk(oi.Size)), oi, nil
+}
+
+// GetRange returns n bytes of decrypted content starting at off. Only chunks
+// covering the range are read from the wrapped backend.
+func (w *Wrapper) GetRange(ctx context.Context, o *bsw.Object, off, n int64) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	first := off / chunkSize
+	cn := int64(-1)
+	if n > 0 {
+		cn = ((off+n-1)/chunkSize - first + 1) * sealedChunk
+	}
+
+	rc, oi, err := o.Bind(w.w).GetRange(ctx, first*sealedChunk, cn)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	aead, oi, err := w.open(ctx, oi)
+	if err == nil {
+		err = bsw.CheckRange(off, oi.Size)
+	}
+	if err != nil {
+		rc.Close()
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	r := newOpenReader(aead, rc, first, lastChunk(oi.Size))
+	if _, err := io.CopyN(io.Discard, r, off-first*chunkSize); err != nil {
+		r.Close()
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	if n < 0 {
+		return r, oi, nil
+	}
+	return &readCloser{Reader: io.LimitReader(r, n), Closer: r}, oi, nil
+}
+
+// Stat returns attributes of the object with size of decrypted content.
+func (w *Wrapper) Stat(ctx context.Context, o *bsw.Object) (bsw.ObjectInfo, error) {
+
+	oi, err := w.w.Stat(ctx, o.Bind(w.w))
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+
+	if _, _, err := envelopeOf(oi); err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+	return plainInfo(oi), nil
+}
+
+// Copy copies encrypted content within the wrapped backend, the data key
+// is kept.
+func (w *Wrapper) Copy(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+	d, err := w.copyTarget(ctx, src, dst, opts)
+	if err != nil {
+		return err
+	}
+	return w.w.Copy(ctx, src.Bind(w.w), d, opts)
+}
+
+// Move moves encrypted content within the wrapped backend.
+func (w *Wrapper) Move(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+	d, err := w.copyTarget(ctx, src, dst, opts)
+	if err != nil {
+		return err
+	}
+	return w.w.Move(ctx, src.Bind(w.w), d, opts)
+}
+
+// copyTarget returns dst bound to the wrapped backend. Replaced metadata
+// gets wrapped data key of src.
+func (w *Wrapper) copyTarget(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) (*bsw.Object, error) {
+
+	d := dst.Bind(w.w)
+	if !opts.ReplaceMetadata {
+		return d, nil
+	}
+
+	oi, err := w.w.Stat(ctx, src.Bind(w.w))
+	if err != nil {
+		return nil, err
+	}
+
+	kekID, wrapped, err := envelopeOf(oi)
+	if err != nil {
+		return nil, err
+	}
+	return d.ReplaceMetadata(withEnvelope(dst.Metadata(), kekID, wrapped)), nil
+}
+
+// List returns iterator over objects of the wrapped backend reporting size
+// of decrypted content.
+func (w *Wrapper) List(ctx context.Context, bucket string, opts bsw.ListOptions) *bsw.ListIterator {
+	return w.w.List(ctx, bucket, opts).Map(plainInfo)
+}
+
+func (w *Wrapper) Delete(ctx context.Context, o *bsw.Object) error {
+	return w.w.Delete(ctx, o.Bind(w.w))
+}
+
+func (w *Wrapper) DeleteMany(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
+	return w.w.DeleteMany(ctx, bucket, keys)
+}
+
+func (w *Wrapper) DeletePrefix(ctx context.Context, bucket, prefix string) ([]bsw.DeleteError, error) {
+	return w.w.DeletePrefix(ctx, bucket, prefix)
+}
+
+// open unwraps data key of the object described by oi. Returns attributes
+// of decrypted content.
+func (w *Wrapper) open(ctx context.Context, oi bsw.ObjectInfo) (cipher.AEAD, bsw.ObjectInfo, error) {
+
+	kekID, wrapped, err := envelopeOf(oi)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	if _, ok := plainSize(oi.Size); !ok {
+		return nil, bsw.ObjectInfo{}, ErrCorrupted.Capture().SetPairs("bucket", oi.Bucket, "key", oi.Key, "reason", "invalid size", "size", oi.Size)
+	}
+
+	key, err := w.kk.UnwrapKey(ctx, kekID, wrapped)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+	return aead, plainInfo(oi), nil
+}
+
+// envelopeOf returns ID of the KEK and wrapped data key stored in metadata.
+func envelopeOf(oi bsw.ObjectInfo) (string, []byte, error) {
+
+	var kekID, key string
+	for k, v := range oi.Metadata {
+		if v == nil {
+			continue
+		}
+		switch strings.ToLower(k) {
+		case MetaKEKID:
+			kekID = *v
+		case MetaWrappedKey:
+			key = *v
+		}
+	}
+
+	if key == "" {
+		return "", nil, ErrNotEncrypted.Capture().SetPairs("bucket", oi.Bucket, "key", oi.Key)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", nil, ErrCorrupted.Capture().SetPairs("bucket", oi.Bucket, "key", oi.Key, "reason", "malformed wrapped key")
+	}
+	return kekID, wrapped, nil
+}
+
+// withEnvelope returns copy of m with wrapped data key.
+func withEnvelope(m map[string]*string, kekID string, wrapped []byte) map[string]*string {
+	res := make(map[string]*string, len(m)+2)
+	for k, v := range m {
+		res[k] = v
+	}
+	key := base64.StdEncoding.EncodeToString(wrapped)
+	res[MetaWrappedKey] = &key
+	res[MetaKEKID] = &kekID
+	return res
+}
+
+// plainInfo returns oi with size of decrypted content. Wrapped data key is
+// removed from metadata and checksum of encrypted content is dropped.
+func plainInfo(oi bsw.ObjectInfo) bsw.ObjectInfo {
+
+	if size, ok := plainSize(oi.Size); ok {
+		oi.Size = size
+	}
+	oi.Checksum = ""
+
+	if len(oi.Metadata) > 0 {
+		m := make(map[string]*string, len(oi.Metadata))
+		for k, v := range oi.Metadata {
+			switch strings.ToLower(k) {
+			case MetaWrappedKey, MetaKEKID:
+			default:
+				m[k] = v
+			}
+		}
+		oi.Metadata = m
+	}
+	return oi
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Catch(err).Critical().StatusCode(500).Msg("invalid data key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Catch(err).Critical().StatusCode(500).Msg("creating gcm failed")
+	}
+	return aead, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
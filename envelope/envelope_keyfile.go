@@ -0,0 +1,135 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+
+	"github.com/axkit/errors"
+)
+
+// KeyProvider wraps data keys of objects by key encryption key (KEK).
+// Implementations backed by KMS services make network calls and must respect
+// ctx cancellation.
+type KeyProvider interface {
+	// WrapKey encrypts data key by the active KEK. Returns ID of the KEK.
+	WrapKey(ctx context.Context, key []byte) (kekID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts data key wrapped by the KEK identified by kekID.
+	UnwrapKey(ctx context.Context, kekID string, wrapped []byte) ([]byte, error)
+}
+
+var ErrUnknownKEK = errors.New("key encryption key is unknown").Critical().StatusCode(500)
+
+// LocalKey is a key encryption key kept by the application.
+type LocalKey struct {
+	// ID identifies the key. It's stored with every object, keep it short.
+	ID string `json:"id"`
+
+	// Key is base64 encoded AES key, 16, 24 or 32 bytes long.
+	Key string `json:"key"`
+}
+
+// KeyFile is the content of the key file. Retired keys must be kept in the
+// file while there are objects their data keys are wrapped by.
+type KeyFile struct {
+	// Active is ID of the key new data keys are wrapped by.
+	Active string     `json:"active"`
+	Keys   []LocalKey `json:"keys"`
+}
+
+// LocalKeyProvider wraps data keys by locally kept keys using AES-GCM.
+type LocalKeyProvider struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+var _ KeyProvider = (*LocalKeyProvider)(nil)
+
+// NewLocalKeyProvider returns provider of keys listed by kf.
+func NewLocalKeyProvider(kf *KeyFile) (*LocalKeyProvider, error) {
+
+	p := LocalKeyProvider{
+		active: kf.Active,
+		keys:   make(map[string]cipher.AEAD, len(kf.Keys)),
+	}
+
+	for _, k := range kf.Keys {
+		if _, ok := p.keys[k.ID]; ok {
+			return nil, errors.ValidationFailed("duplicate key id").Set("id", k.ID)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, errors.ValidationFailed("key is not base64 encoded").Set("id", k.ID)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Catch(err).Critical().Set("id", k.ID).StatusCode(500).Msg("invalid key encryption key")
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Catch(err).Critical().Set("id", k.ID).StatusCode(500).Msg("creating gcm failed")
+		}
+		p.keys[k.ID] = aead
+	}
+
+	if _, ok := p.keys[p.active]; !ok {
+		return nil, errors.ValidationFailed("active key not found").Set("id", p.active)
+	}
+	return &p, nil
+}
+
+// LoadKeyFile reads JSON encoded KeyFile from path and returns provider of
+// its keys. The file must be readable by the application only.
+func LoadKeyFile(path string) (*LocalKeyProvider, error) {
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Catch(err).Critical().Set("path", path).StatusCode(500).Msg("reading key file failed")
+	}
+
+	var kf KeyFile
+	if err := json.Unmarshal(buf, &kf); err != nil {
+		return nil, errors.Catch(err).Critical().Set("path", path).StatusCode(500).Msg("decoding key file failed")
+	}
+	return NewLocalKeyProvider(&kf)
+}
+
+// WrapKey seals key by the active key. Wrapped key is random nonce followed
+// by sealed key, ID of the KEK is authenticated as additional data.
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, key []byte) (string, []byte, error) {
+
+	aead := p.keys[p.active]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, errors.Catch(err).Critical().StatusCode(500).Msg("generating nonce failed")
+	}
+	return p.active, aead.Seal(nonce, nonce, key, []byte(p.active)), nil
+}
+
+// UnwrapKey opens key sealed by WrapKey.
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, kekID string, wrapped []byte) ([]byte, error) {
+
+	aead, ok := p.keys[kekID]
+	if !ok {
+		return nil, ErrUnknownKEK.Capture().Set("id", kekID)
+	}
+
+	if len(wrapped) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCorrupted.Capture().Set("reason", "wrapped key is too short")
+	}
+
+	ns := aead.NonceSize()
+	key, err := aead.Open(nil, wrapped[:ns], wrapped[ns:], []byte(kekID))
+	if err != nil {
+		return nil, ErrCorrupted.Capture().SetPairs("reason", "unwrapping key failed", "id", kekID)
+	}
+	return key, nil
+}
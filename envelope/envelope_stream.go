@@ -0,0 +1,178 @@
+package envelope
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+
+	"github.com/axkit/errors"
+)
+
+// Encrypted content is a sequence of chunks sealed by AES-GCM with the data
+// key of the object. Every object has its own data key, so nonce of the chunk
+// is its big endian index. Format version and the flag of the last chunk are
+// authenticated as additional data, so chunks can't be reordered or dropped.
+// Empty content is a single empty chunk. Chunks are sealed independently,
+// it allows reading ranges without decrypting the whole object.
+const (
+	formatVersion = 1
+	chunkSize     = 64 << 10
+	tagSize       = 16
+	sealedChunk   = chunkSize + tagSize
+)
+
+// ErrCorrupted is returned when encrypted content fails authentication.
+var ErrCorrupted = errors.New("encrypted object is corrupted").Critical().StatusCode(500)
+
+// sealedSize returns size of encrypted content of n bytes.
+func sealedSize(n int64) int64 {
+	chunks := (n + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return n + chunks*tagSize
+}
+
+// plainSize returns size of content encrypted into n bytes. Returns false
+// if there is no such content.
+func plainSize(n int64) (int64, bool) {
+	chunks := (n + sealedChunk - 1) / sealedChunk
+	res := n - chunks*tagSize
+	return res, res >= 0 && sealedSize(res) == n
+}
+
+// lastChunk returns index of the last chunk of content of n bytes.
+func lastChunk(n int64) int64 {
+	if n == 0 {
+		return 0
+	}
+	return (n - 1) / chunkSize
+}
+
+func chunkNonce(idx int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(idx))
+	return nonce
+}
+
+func chunkAD(last bool) []byte {
+	ad := []byte{formatVersion, 0}
+	if last {
+		ad[1] = 1
+	}
+	return ad
+}
+
+// sealReader encrypts content read from r.
+type sealReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	idx  int64
+	in   []byte
+	out  []byte
+	done bool
+}
+
+func newSealReader(aead cipher.AEAD, r io.Reader) *sealReader {
+	return &sealReader{
+		r:    bufio.NewReaderSize(r, chunkSize),
+		aead: aead,
+		in:   make([]byte, chunkSize),
+	}
+}
+
+func (sr *sealReader) Read(p []byte) (int, error) {
+	if len(sr.out) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.out)
+	sr.out = sr.out[n:]
+	return n, nil
+}
+
+// next seals the next chunk. The chunk is the last if content ends within
+// it or right after it.
+func (sr *sealReader) next() error {
+
+	n, err := io.ReadFull(sr.r, sr.in)
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+	if !last {
+		if _, err := sr.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	sr.out = sr.aead.Seal(sr.out[:0], chunkNonce(sr.idx), sr.in[:n], chunkAD(last))
+	sr.idx++
+	sr.done = last
+	return nil
+}
+
+// openReader decrypts chunks read from r starting at the chunk idx. The
+// chunk last is the last chunk of the object.
+type openReader struct {
+	r    io.ReadCloser
+	aead cipher.AEAD
+	idx  int64
+	last int64
+	in   []byte
+	out  []byte
+	done bool
+}
+
+func newOpenReader(aead cipher.AEAD, r io.ReadCloser, idx, last int64) *openReader {
+	return &openReader{r: r, aead: aead, idx: idx, last: last, in: make([]byte, sealedChunk)}
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	for len(or.out) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		if err := or.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, or.out)
+	or.out = or.out[n:]
+	return n, nil
+}
+
+func (or *openReader) next() error {
+
+	n, err := io.ReadFull(or.r, or.in)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return ErrCorrupted.Capture().SetPairs("reason", "truncated", "chunk", or.idx)
+		}
+		return err
+	}
+
+	last := or.idx == or.last
+	if !last && n != sealedChunk {
+		return ErrCorrupted.Capture().SetPairs("reason", "unexpected chunk size", "chunk", or.idx, "size", n)
+	}
+
+	or.out, err = or.aead.Open(or.in[:0], chunkNonce(or.idx), or.in[:n], chunkAD(last))
+	if err != nil {
+		return ErrCorrupted.Capture().SetPairs("reason", "authentication failed", "chunk", or.idx)
+	}
+	or.idx++
+	or.done = last
+	return nil
+}
+
+func (or *openReader) Close() error {
+	return or.r.Close()
+}
@@ -0,0 +1,189 @@
+package envelope_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/bsw/envelope"
+	"github.com/axkit/bsw/fs"
+	"github.com/axkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWrapper(t *testing.T) (*envelope.Wrapper, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	kf := envelope.KeyFile{
+		Active: "k2",
+		Keys: []envelope.LocalKey{
+			{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))},
+			{ID: "k2", Key: base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))},
+		},
+	}
+	buf, err := json.Marshal(kf)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keys.json"), buf, 0600))
+
+	kp, err := envelope.LoadKeyFile(filepath.Join(dir, "keys.json"))
+	require.NoError(t, err)
+
+	basePath := filepath.Join(dir, "data")
+	require.NoError(t, os.Mkdir(basePath, 0700))
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	return envelope.New(s, kp), basePath
+}
+
+func readAll(t *testing.T, rc io.ReadCloser) string {
+	t.Helper()
+	defer rc.Close()
+	buf, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+func TestWrapper_PutGet(t *testing.T) {
+	w, basePath := newWrapper(t)
+	ctx := context.Background()
+
+	content := strings.Repeat("0123456789abcdef", 10000)
+	o := bsw.NewObject(w, "docs", "big.txt").SetMetadata("owner", "alice")
+	require.NoError(t, o.Put(ctx, strings.NewReader(content), int64(len(content))))
+
+	raw, err := os.ReadFile(filepath.Join(basePath, "docs", "big.txt"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "0123456789abcdef")
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, content, readAll(t, rc))
+	assert.Equal(t, int64(len(content)), oi.Size)
+	assert.Equal(t, "alice", *oi.Metadata["owner"])
+	assert.NotContains(t, oi.Metadata, envelope.MetaWrappedKey)
+
+	oi, err = o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), oi.Size)
+
+	for _, r := range []struct{ off, n int64 }{
+		{0, 10}, {65530, 20}, {65536, 65536}, {150000, -1}, {159990, 100},
+	} {
+		rc, oi, err := o.GetRange(ctx, r.off, r.n)
+		require.NoError(t, err)
+		end := int64(len(content))
+		if r.n > 0 && r.off+r.n < end {
+			end = r.off + r.n
+		}
+		assert.Equal(t, content[r.off:end], readAll(t, rc), "range %d+%d", r.off, r.n)
+		assert.Equal(t, int64(len(content)), oi.Size)
+	}
+
+	_, _, err = o.GetRange(ctx, int64(len(content)), -1)
+	assert.True(t, errors.Is(err, bsw.ErrInvalidRange))
+
+	empty := bsw.NewObject(w, "docs", "empty.txt")
+	require.NoError(t, empty.Put(ctx, strings.NewReader(""), -1))
+	rc, oi, err = empty.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "", readAll(t, rc))
+	assert.Equal(t, int64(0), oi.Size)
+
+	it := w.List(ctx, "docs", bsw.ListOptions{})
+	sizes := make(map[string]int64)
+	for it.Next() {
+		sizes[it.Info().Key] = it.Info().Size
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, map[string]int64{"big.txt": int64(len(content)), "empty.txt": 0}, sizes)
+
+	_, err = o.UploadURL(time.Hour)
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+}
+
+func TestWrapper_Tampered(t *testing.T) {
+	w, basePath := newWrapper(t)
+	ctx := context.Background()
+
+	o := bsw.NewObject(w, "docs", "a.txt")
+	require.NoError(t, o.Put(ctx, strings.NewReader("secret"), 6))
+
+	// modification time is kept, so fs backend returns stored metadata.
+	fp := filepath.Join(basePath, "docs", "a.txt")
+	fi, err := os.Stat(fp)
+	require.NoError(t, err)
+	raw, err := os.ReadFile(fp)
+	require.NoError(t, err)
+	raw[0] ^= 0xff
+	require.NoError(t, os.WriteFile(fp, raw, 0644))
+	require.NoError(t, os.Chtimes(fp, fi.ModTime(), fi.ModTime()))
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	assert.True(t, errors.Is(err, envelope.ErrCorrupted))
+
+	plain := bsw.NewObject(w.Unwrap(), "docs", "plain.txt")
+	require.NoError(t, plain.Put(ctx, strings.NewReader("plain"), 5))
+	_, _, err = bsw.NewObject(w, "docs", "plain.txt").Get(ctx)
+	assert.True(t, errors.Is(err, envelope.ErrNotEncrypted))
+}
+
+func TestWrapper_CopyMove(t *testing.T) {
+	w, _ := newWrapper(t)
+	other, _ := newWrapper(t)
+	ctx := context.Background()
+
+	src := bsw.NewObject(w, "docs", "a.txt")
+	require.NoError(t, src.Put(ctx, strings.NewReader("content"), -1))
+
+	dst := bsw.NewObject(w, "docs", "b.txt").SetMetadata("k", "v")
+	require.NoError(t, bsw.Copy(ctx, src, dst, bsw.CopyOptions{ReplaceMetadata: true}))
+	require.NoError(t, bsw.Move(ctx, src, bsw.NewObject(other, "docs", "c.txt"), bsw.CopyOptions{}))
+
+	for _, o := range []*bsw.Object{dst, bsw.NewObject(other, "docs", "c.txt")} {
+		rc, _, err := o.Get(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "content", readAll(t, rc))
+	}
+}
+
+func TestLocalKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	kp, err := envelope.NewLocalKeyProvider(&envelope.KeyFile{
+		Active: "k1",
+		Keys:   []envelope.LocalKey{{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))}},
+	})
+	require.NoError(t, err)
+
+	id, wrapped, err := kp.WrapKey(ctx, []byte("data key"))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", id)
+
+	key, err := kp.UnwrapKey(ctx, id, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, "data key", string(key))
+
+	_, err = kp.UnwrapKey(ctx, "k0", wrapped)
+	assert.True(t, errors.Is(err, envelope.ErrUnknownKEK))
+
+	wrapped[len(wrapped)-1] ^= 1
+	_, err = kp.UnwrapKey(ctx, id, wrapped)
+	assert.True(t, errors.Is(err, envelope.ErrCorrupted))
+
+	_, err = envelope.NewLocalKeyProvider(&envelope.KeyFile{Active: "k2"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package fs
+
+import (
+	"github.com/axkit/errors"
+	"github.com/axkit/vatel"
+)
+
+// DeleteHandler removes the object. Signed token is passed by
+// query parameter "src".
+type DeleteHandler struct {
+	d SignedURLDecoder
+	s *FileSystemStorageServer
+}
+
+func (c *DeleteHandler) Handle(ctx vatel.Context) error {
+
+	src := string(ctx.RequestCtx().QueryArgs().Peek("src"))
+	if src == "" {
+		return errors.ValidationFailed("src is empty")
+	}
+
+	t, err := c.d.DecodeToken(src)
+	if err != nil {
+		return err
+	}
+
+	if err := t.Allow(OpDelete); err != nil {
+		return err
+	}
+
+	return c.s.DeleteObject(t.Object)
+}
@@ -3,34 +3,51 @@ package fs
 import (
 	"github.com/axkit/errors"
 	"github.com/axkit/vatel"
+	"github.com/valyala/fasthttp"
 )
 
+// DownloadHandler serves the object. Signed token is passed by query
+// parameter "src". Conditional and range requests are supported.
 type DownloadHandler struct {
-	d     SignedURLDecoder
-	s     *FileSystemStorageServer
-	input struct {
-		Src string `param:"src"`
-	}
-}
-
-func (c *DownloadHandler) Input() interface{} {
-	return &c.input
+	d SignedURLDecoder
+	s *FileSystemStorageServer
 }
 
 func (c *DownloadHandler) Handle(ctx vatel.Context) error {
 
-	if c.input.Src == "" {
+	src := string(ctx.RequestCtx().QueryArgs().Peek("src"))
+	if src == "" {
 		return errors.ValidationFailed("src is empty")
 	}
 
-	o, err := c.d.DecodeSignedURL(c.input.Src)
+	t, err := c.d.DecodeToken(src)
 	if err != nil {
 		return err
 	}
 
-	if !o.StillValid() {
-		return errors.ValidationFailed("signed url is expired")
+	if err := t.Allow(OpGet); err != nil {
+		return err
+	}
+
+	if err := t.useCustomerKey(ctx); err != nil {
+		return err
 	}
 
-	return c.s.ReadObjectTo(o, ctx.BodyWriter())
+	return c.s.ServeObject(ctx, t)
+}
+
+// HandleHead serves HEAD request of the download. vatel does not route HEAD
+// requests, register it with the router directly:
+//
+//	mux.HEAD("/api/v1/bos/download", srv.HandleHead)
+func (s *FileSystemStorageServer) HandleHead(fctx *fasthttp.RequestCtx) {
+
+	h := DownloadHandler{d: s.sud, s: s}
+	if err := h.Handle(vatel.NewContext(fctx)); err != nil {
+		code := 500
+		if ce, ok := err.(*errors.CatchedError); ok && ce.Last().StatusCode > 0 {
+			code = ce.Last().StatusCode
+		}
+		fctx.SetStatusCode(code)
+	}
 }
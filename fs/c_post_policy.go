@@ -0,0 +1,151 @@
+package fs
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+	"github.com/axkit/vatel"
+)
+
+// PostPolicyHandler stores the object submitted by browser form issued by
+// Service.PreSignPostPolicy. The form carries signed token in field "policy",
+// the key in field "key", optional content type in field "Content-Type" and
+// the content in file field "file". Fields must precede the file, fields
+// following it are ignored. The form is read part by part, so size limits
+// of the policy are applied while the file is received. On success client is redirected to
+// success action URL with bucket, key and etag query parameters or gets
+// success action status, 204 by default.
+type PostPolicyHandler struct {
+	d SignedURLDecoder
+	s *FileSystemStorageServer
+}
+
+func (c *PostPolicyHandler) Handle(ctx vatel.Context) error {
+
+	rctx := ctx.RequestCtx()
+	body, _, err := requestBody(rctx)
+	if err != nil {
+		return err
+	}
+
+	// policy fields precede the file, the file is checked while received.
+	values, f, fileContentType, err := readForm(rctx, body, maxPostFieldsSize)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	policy := values["policy"]
+	if policy == "" {
+		return errors.ValidationFailed("policy is empty")
+	}
+
+	t, err := c.d.DecodeToken(policy)
+	if err != nil {
+		return err
+	}
+
+	if err := t.Allow(OpPost); err != nil {
+		return err
+	}
+
+	key := values["key"]
+	if key == "" {
+		key = t.Object.Key()
+	}
+
+	contentType := values["Content-Type"]
+	if contentType == "" {
+		contentType = fileContentType
+	}
+	if ct := t.Object.Headers().ContentType; ct != "" {
+		contentType = ct
+	}
+
+	if err := t.CheckPost(key, contentType, -1); err != nil {
+		return err
+	}
+
+	// the object is written by the server, backend wrapper is not needed.
+	o := bsw.NewObject(nil, t.Object.Bucket(), key, bsw.WithMetadata(t.Object.Metadata()),
+		bsw.WithHeaders(t.Object.Headers()), bsw.WithEncryption(t.Object.Encryption()))
+
+	mr := &minSizeReader{r: f, min: t.Policy.MinSize}
+	err = c.s.WriteObject(rctx, o, mr, -1, contentType, &PutLimits{MaxSize: t.Policy.MaxSize})
+	if err := mr.tooSmall(); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.Policy.SuccessActionRedirect != "" {
+		return c.redirect(ctx, t.Policy.SuccessActionRedirect, o)
+	}
+
+	status := t.Policy.SuccessActionStatus
+	if status == 0 {
+		status = http.StatusNoContent
+	}
+	ctx.SetStatusCode(status)
+	return nil
+}
+
+// maxPostFieldsSize limits total size of form fields preceding the file.
+const maxPostFieldsSize = 64 << 10
+
+// minSizeReader fails reading at the end of content if less than min bytes read.
+type minSizeReader struct {
+	r   io.Reader
+	n   int64
+	min int64
+	eof bool
+}
+
+func (mr *minSizeReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	mr.n += int64(n)
+	if err == io.EOF {
+		mr.eof = true
+		if err := mr.tooSmall(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// tooSmall returns ErrUploadLimit if content ended before min bytes read.
+func (mr *minSizeReader) tooSmall() error {
+	if !mr.eof || mr.n >= mr.min {
+		return nil
+	}
+	return ErrUploadLimit.Capture().SetPairs("reason", "too small", "minSize", mr.min, "size", mr.n)
+}
+
+// redirect sends client to target with bucket, key and etag of the stored object.
+func (c *PostPolicyHandler) redirect(ctx vatel.Context, target string, o *bsw.Object) error {
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return errors.Catch(err).Set("url", target).StatusCode(500).Msg("parsing success action redirect failed")
+	}
+
+	q := u.Query()
+	q.Set("bucket", o.Bucket())
+	q.Set("key", o.Key())
+	if fp, err := objectPath(c.s.cfg.basePath, o); err == nil {
+		if fi, err := os.Stat(fp); err == nil {
+			q.Set("etag", strconv.Quote(objectInfo(c.s.cfg.basePath, o, fi).ETag))
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	ctx.SetHeader([]byte("Location"), []byte(u.String()))
+	ctx.SetStatusCode(http.StatusSeeOther)
+	return nil
+}
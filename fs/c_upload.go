@@ -3,58 +3,200 @@ package fs
 import (
 	"bytes"
 	"io"
+	"mime/multipart"
 
 	"github.com/axkit/errors"
 	"github.com/axkit/vatel"
+	"github.com/valyala/fasthttp"
 )
 
+// UploadHandler stores the object. Content is submitted as form file "file"
+// by POST request or as raw body by PUT request, signed token is passed by
+// query parameter "dest". Content is streamed to a temporary file which is
+// renamed into place when the upload succeeds. The request body is not
+// buffered in memory if the server is configured by ConfigureServer.
 type UploadHandler struct {
-	d     SignedURLDecoder
-	s     *FileSystemStorageServer
-	input struct {
-		Dest string `param:"dest"`
-	}
-}
-
-func (c *UploadHandler) Input() interface{} {
-	return &c.input
+	d SignedURLDecoder
+	s *FileSystemStorageServer
 }
 
 func (c *UploadHandler) Handle(ctx vatel.Context) error {
 
-	if c.input.Dest == "" {
+	dest := string(ctx.RequestCtx().QueryArgs().Peek("dest"))
+	if dest == "" {
 		return errors.ValidationFailed("dest is empty")
 	}
 
-	o, err := c.d.DecodeSignedURL(c.input.Dest)
+	t, err := c.d.DecodeToken(dest)
 	if err != nil {
 		return err
 	}
 
-	buf, err := extractFile(ctx)
+	if err := t.Allow(OpPut); err != nil {
+		return err
+	}
+
+	if err := t.useCustomerKey(ctx); err != nil {
+		return err
+	}
+
+	r, contentType, size, err := extractFile(ctx)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 
-	return c.s.WriteObject(o, buf)
+	if ct := t.Object.Headers().ContentType; ct != "" {
+		contentType = ct
+	}
+
+	if err := t.Limits.CheckContentType(contentType); err != nil {
+		return err
+	}
+
+	return c.s.WriteObject(ctx.RequestCtx(), t.Object, r, size, contentType, &t.Limits)
+}
+
+// ErrBodyNotStreamed is returned if multipart form was parsed by the server
+// before the handler was called. See ConfigureServer.
+var ErrBodyNotStreamed = errors.New("request body is parsed by server, DisablePreParseMultipartForm must be set").Critical().StatusCode(500)
+
+// ConfigureServer enables request body streaming and disables multipart form
+// pre-parsing, so uploads are written straight to basePath and size limits
+// are applied while content is being received. Without it fasthttp reads
+// the whole body into memory before handlers are called.
+func ConfigureServer(srv *fasthttp.Server) *fasthttp.Server {
+	srv.StreamRequestBody = true
+	srv.DisablePreParseMultipartForm = true
+	return srv
 }
 
-func extractFile(ctx vatel.Context) (*bytes.Buffer, error) {
-	fh, err := ctx.FormFile("file")
+// extractFile returns reader of uploaded content, its content type and size.
+// Size is -1 if unknown. Content is the request body for PUT request or
+// form file "file" otherwise. Form is read part by part, fields preceding
+// "file" are skipped.
+func extractFile(ctx vatel.Context) (io.ReadCloser, string, int64, error) {
+
+	rctx := ctx.RequestCtx()
+	body, size, err := requestBody(rctx)
 	if err != nil {
-		return nil, err // errors.Wrap(err, core.ErrBadRequest).Set("reason", "file not submitted")
+		return nil, "", 0, err
 	}
 
-	f, err := fh.Open()
-	if err != nil {
-		return nil, err // errors.Wrap(err, core.ErrBadRequest).Set("reason", "opening form file failed")
+	if rctx.IsPut() {
+		return io.NopCloser(body), string(rctx.Request.Header.ContentType()), size, nil
 	}
-	defer f.Close()
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, f)
+	_, f, ct, err := readForm(rctx, body, 0)
 	if err != nil {
-		return nil, err // errors.Wrap(err, core.ErrBadRequest).Set("reason", "reading form file failed")
+		return nil, "", 0, err
+	}
+	return f, ct, -1, nil
+}
+
+// readForm reads multipart form from body part by part till file field
+// "file". Returns values of preceding fields, up to maxValues bytes in total,
+// the file reader and its content type. Fields are skipped if maxValues is
+// zero, fields following the file are not read. The file reader does not
+// drain the rest of the file on Close, rejected upload must not be received
+// to the end.
+func readForm(rctx *fasthttp.RequestCtx, body io.Reader, maxValues int64) (map[string]string, io.ReadCloser, string, error) {
+
+	boundary := string(rctx.Request.Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, nil, "", errors.ValidationFailed("multipart form expected")
+	}
+
+	var (
+		values = make(map[string]string)
+		left   = maxValues
+		mr     = multipart.NewReader(body, boundary)
+	)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, nil, "", errors.ValidationFailed("file is not submitted")
+		}
+		if err != nil {
+			return nil, nil, "", errors.ValidationFailed("reading multipart form failed").Set("reason", err.Error())
+		}
+
+		name := p.FormName()
+		if name == "file" {
+			return values, io.NopCloser(p), p.Header.Get("Content-Type"), nil
+		}
+
+		if _, ok := values[name]; ok || name == "" || maxValues <= 0 {
+			p.Close()
+			continue
+		}
+
+		buf, err := io.ReadAll(io.LimitReader(p, left+1))
+		p.Close()
+		if err != nil {
+			return nil, nil, "", errors.ValidationFailed("reading multipart form failed").Set("reason", err.Error())
+		}
+		if left -= int64(len(buf)); left < 0 {
+			return nil, nil, "", errors.ValidationFailed("form fields are too large").StatusCode(413)
+		}
+		values[name] = string(buf)
+	}
+}
+
+// requestBody returns request body and its size, -1 if unknown. The body is
+// streamed if the server has StreamRequestBody enabled, otherwise it's read
+// into memory already.
+func requestBody(rctx *fasthttp.RequestCtx) (io.Reader, int64, error) {
+
+	if bs := rctx.RequestBodyStream(); bs != nil {
+		size := int64(rctx.Request.Header.ContentLength())
+		if size < 0 {
+			size = -1
+		}
+		return bs, size, nil
+	}
+
+	body := rctx.PostBody()
+	if len(body) == 0 && rctx.Request.Header.ContentLength() > 0 {
+		// multipart form is consumed by fasthttp pre-parsing.
+		return nil, 0, ErrBodyNotStreamed.Capture()
+	}
+	return bytes.NewReader(body), int64(len(body)), nil
+}
+
+// limitedReader fails reading as soon as more than max bytes read.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if lr.n += int64(n); lr.n > lr.max {
+		return n, lr.exceeded()
+	}
+	return n, err
+}
+
+// exceeded returns ErrUploadLimit if more than max bytes read. It's safe
+// to call on nil.
+func (lr *limitedReader) exceeded() error {
+	if lr == nil || lr.n <= lr.max {
+		return nil
+	}
+	return ErrUploadLimit.Capture().SetPairs("reason", "too large", "maxSize", lr.max).StatusCode(413)
+}
+
+// limitReader applies size limit max to r. If r has known size, it's checked
+// before reading. Zero max means no limit, nil is returned then.
+func limitReader(r io.Reader, size, max int64) (*limitedReader, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	l := PutLimits{MaxSize: max}
+	if err := l.CheckSize(size); err != nil {
+		return nil, err
 	}
-	return &buf, nil
+	return &limitedReader{r: r, max: max}, nil
 }
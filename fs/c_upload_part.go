@@ -0,0 +1,57 @@
+package fs
+
+import (
+	"github.com/axkit/errors"
+	"github.com/axkit/vatel"
+)
+
+// UploadPartHandler stores a part of multipart upload. The part is
+// submitted the same way as to UploadHandler, customer key headers are
+// required if the upload is started with customer key.
+type UploadPartHandler struct {
+	d      SignedURLDecoder
+	s      *FileSystemStorageServer
+	result struct {
+		ETag string `json:"etag"`
+	}
+}
+
+func (c *UploadPartHandler) Result() interface{} {
+	return &c.result
+}
+
+func (c *UploadPartHandler) Handle(ctx vatel.Context) error {
+
+	dest := string(ctx.RequestCtx().QueryArgs().Peek("dest"))
+	if dest == "" {
+		return errors.ValidationFailed("dest is empty")
+	}
+
+	t, err := c.d.DecodeToken(dest)
+	if err != nil {
+		return err
+	}
+
+	if err := t.Allow(OpPart); err != nil {
+		return err
+	}
+
+	if err := t.useCustomerKey(ctx); err != nil {
+		return err
+	}
+
+	r, _, size, err := extractFile(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	etag, err := c.s.WritePart(ctx.RequestCtx(), t, r, size)
+	if err != nil {
+		return err
+	}
+
+	c.result.ETag = etag
+	ctx.SetHeader([]byte("ETag"), []byte(`"`+etag+`"`))
+	return nil
+}
@@ -1,13 +1,9 @@
 package fs
 
 import (
-	"crypto/aes"
+	"context"
 	"crypto/cipher"
-	"encoding/base64"
-	"io"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/axkit/bsw"
@@ -34,22 +30,39 @@ func (p *AwsCompletedPart) PartNumberPtr() *int64 {
 }
 
 type Service struct {
-	cfg *Config
+	cfg    *Config
+	keys   *keyring
+	atRest cipher.AEAD
 }
 
 var _ bsw.BlockStorageWrapper = (*Service)(nil)
 
 type Config struct {
 	URLEncryptionKey string `json:"urlEncryptionKey"`
-	RetryCount       int    `json:"retryCount"`
-	BasePath         string `json:"basePath"`
+
+	// URLKeys and ActiveURLKeyID configure keyring for key rotation. Tokens are
+	// signed by the active key and verified by any key of the keyring. Retired
+	// keys are removed from the list. Overwritten by FS_URL_KEYS and
+	// FS_URL_ACTIVE_KEY environment variables.
+	URLKeys        []URLKey `json:"urlKeys"`
+	ActiveURLKeyID string   `json:"activeUrlKeyId"`
+
+	// AtRestKey, if set, is AES key, 16, 24 or 32 bytes long, all objects
+	// are encrypted by unless customer key is given. FileSystemStorageServer
+	// must be configured with the same key. Overwritten by FS_AT_REST_KEY
+	// environment variable.
+	AtRestKey string `json:"atRestKey"`
+
+	RetryCount int    `json:"retryCount"`
+	BasePath   string `json:"basePath"`
 }
 
 func NewFileStorageWrapper(cfg *Config) (*Service, error) {
 	s := Service{cfg: cfg}
 
+	var ek envKeys
 	g := gonfig.New()
-	if errs := g.BindStruct(&s); len(errs) > 0 {
+	if errs := g.BindStruct(&ek); len(errs) > 0 {
 		return nil, errs[0]
 	}
 
@@ -58,6 +71,33 @@ func NewFileStorageWrapper(cfg *Config) (*Service, error) {
 		return nil, err
 	}
 
+	kcfg := *cfg
+	if v := ek.Keys.Val(); v != "" {
+		keys, err := parseURLKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		kcfg.URLKeys = keys
+	}
+	if v := ek.ActiveKey.Val(); v != "" {
+		kcfg.ActiveURLKeyID = v
+	}
+
+	var err error
+	if s.keys, err = newKeyring(&kcfg); err != nil {
+		return nil, err
+	}
+
+	key := cfg.AtRestKey
+	if v := ek.AtRestKey.Val(); v != "" {
+		key = v
+	}
+	if key != "" {
+		if s.atRest, err = newGCM([]byte(key)); err != nil {
+			return nil, errors.Catch(err).Critical().StatusCode(500).Msg("invalid at rest key")
+		}
+	}
+
 	if _, err := os.Stat(s.cfg.BasePath); err != nil {
 		return nil, errors.Catch(err).Set("path", s.cfg.BasePath).StatusCode(500).Critical().Msg("path not exist")
 	}
@@ -67,160 +107,111 @@ func NewFileStorageWrapper(cfg *Config) (*Service, error) {
 
 // PreSignPutObjectURL returns presigned URL for PUT object request.
 func (s *Service) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignPutObjectURLContext(context.Background(), o, timeout)
+}
 
-	uri := o.Bucket() + ":" + o.Key() + ":" + strconv.FormatInt(time.Now().Unix()+int64(timeout.Seconds()), 10)
-	res, err := s.encrypt(uri)
-	if err != nil {
-		return "", err
-	}
-	return res, nil
+func (s *Service) PreSignPutObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignPutObjectURLWithLimits(ctx, o, timeout, PutLimits{})
 }
 
-var randBytes = []byte{35, 46, 57, 24, 85, 35, 24, 74, 87, 35, 88, 98, 66, 32, 14, 05}
+// PreSignPutObjectURLWithLimits returns put token restricting uploaded content by limits.
+func (s *Service) PreSignPutObjectURLWithLimits(ctx context.Context, o *bsw.Object, timeout time.Duration, limits PutLimits) (string, error) {
 
-func (s *Service) encrypt(text string) (string, error) {
-	block, err := aes.NewCipher([]byte(s.cfg.URLEncryptionKey))
-	if err != nil {
+	if _, err := writeSealer(o, s.atRest); err != nil {
 		return "", err
 	}
-	plainText := []byte(text)
-	cfb := cipher.NewCFBEncrypter(block, randBytes)
-	cipherText := make([]byte, len(plainText))
-	cfb.XORKeyStream(cipherText, plainText)
-	return base64.URLEncoding.EncodeToString([]byte(cipherText)), nil
-}
-
-func (s *Service) DecodeSignedURL(encodedStr string) (*bsw.Object, error) {
 
-	uri, err := s.decrypt(encodedStr)
-	if err != nil {
-		return nil, err
+	p := tokenPayload{
+		Op:         OpPut,
+		Bucket:     o.Bucket(),
+		Key:        o.Key(),
+		Exp:        time.Now().Unix() + int64(timeout.Seconds()),
+		Metadata:   o.Metadata(),
+		Encryption: newTokenEncryption(o),
 	}
-
-	parts := strings.Split(uri, ":")
-	if len(parts) != 3 {
-		return nil, errors.New("invalid signed URL")
+	if h := o.Headers(); !h.IsZero() {
+		p.Headers = &h
 	}
-
-	at, err := strconv.ParseInt(parts[2], 10, 64)
-	if err != nil {
-		return nil, err
+	if limits.MaxSize > 0 || len(limits.ContentTypes) > 0 || limits.SHA256 != "" {
+		p.Limits = &limits
 	}
-
-	res := bsw.NewObject(s, parts[0], parts[1]).SetValidTill(at)
-	return res, nil
+	return s.encodeToken(p)
 }
 
-func (s *Service) decrypt(text string) (string, error) {
-	block, err := aes.NewCipher([]byte(s.cfg.URLEncryptionKey))
-	if err != nil {
-		return "", err
-	}
-
-	cipherText, err := base64.URLEncoding.DecodeString(text)
-	if err != nil {
-		return "", err
-	}
+func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignGetObjectURLContext(context.Background(), o, timeout)
+}
 
-	cfb := cipher.NewCFBDecrypter(block, randBytes)
-	plainText := make([]byte, len(cipherText))
-	cfb.XORKeyStream(plainText, cipherText)
-	return string(plainText), nil
+func (s *Service) PreSignGetObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignGetObjectURLWithOverrides(ctx, o, timeout, o.ResponseHeaders())
 }
 
-func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+// PreSignGetObjectURLWithOverrides returns get token setting response headers of the download.
+func (s *Service) PreSignGetObjectURLWithOverrides(ctx context.Context, o *bsw.Object, timeout time.Duration, ro ResponseOverrides) (string, error) {
 
-	uri := o.Bucket() + ":" + o.Key() + ":" + strconv.FormatInt(time.Now().Unix()+int64(timeout.Seconds()), 10)
-	res, err := s.encrypt(uri)
-	if err != nil {
-		return "", err
+	p := tokenPayload{
+		Op:     OpGet,
+		Bucket: o.Bucket(),
+		Key:    o.Key(),
+		Exp:    time.Now().Unix() + int64(timeout.Seconds()),
 	}
-	return res, nil
-}
-
-func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
-
-	// mui := &s3.CreateMultipartUploadInput{
-	// 	Bucket: aws.String(o.Bucket()),
-	// 	Key:    aws.String(o.Key()),
-	// }
-
-	// req, resp := s.svc.CreateMultipartUploadRequest(mui)
-
-	// req.RetryCount = s.cfg.RetryCount
-
-	// if err := req.Send(); err != nil {
-	// 	ex := errors.Catch(err).StatusCode(500).SetPairs("bucket", o.Bucket(), "key", o.Key(), "parts", o.Parts()).Critical()
-	// 	if aerr, ok := err.(awserr.Error); ok {
-	// 		ex.Set("awsErrCode", aerr.Code())
-	// 	}
-	// 	return nil, "", ex.Msg("create multipart upload request failed")
-	// }
-
-	// // from here we do have
-
-	// var res []string
-	// for i := 0; i < o.Parts(); i++ {
-	// 	x := s.svc.NewRequest(&request.Operation{
-	// 		Name:       "PutObject",
-	// 		HTTPMethod: "PUT",
-	// 		HTTPPath:   "/" + o.Bucket() + "/" + o.Key() + "?partNumber=" + strconv.Itoa(i+1) + "&uploadId=" + *resp.UploadId,
-	// 	}, nil, nil)
-
-	// 	u, err := x.Presign(timeout)
-	// 	if err != nil {
-	// 		return nil, "", errors.Catch(err).
-	// 			SetPairs("bucket", o.Bucket(), "key", o.Key(), "part", i+1).
-	// 			Critical().
-	// 			StatusCode(500).Msg("presigned part URL generation failed")
-	// 	}
-	// 	res = append(res, u)
-	// }
-
-	// return res, *resp.UploadId, nil
-	return nil, "", nil
+	if !ro.IsZero() {
+		p.Response = &ro
+	}
+	return s.encodeToken(p)
 }
 
-// CompleteMultipartUpload
-func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+// PreSignDeleteObjectURL returns token for deleting the object by FileSystemStorageServer.
+func (s *Service) PreSignDeleteObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
 
-	return nil
+	return s.encodeToken(tokenPayload{
+		Op:     OpDelete,
+		Bucket: o.Bucket(),
+		Key:    o.Key(),
+		Exp:    time.Now().Unix() + int64(timeout.Seconds()),
+	})
 }
 
-func mergeFiles(srcFiles []string, destFile string) error {
-	// Open destination file for appending
-	dFile, err := os.OpenFile(destFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
-	defer dFile.Close()
+// PostPolicyPath is the path of FileSystemStorageServer endpoint accepting
+// browser form uploads.
+const PostPolicyPath = "/api/v1/bos/post-policy"
 
-	// Iterate through each source file and merge, but don't delete yet
-	for _, srcFile := range srcFiles {
-		// Open the source file for reading
-		sFile, err := os.Open(srcFile)
-		if err != nil {
-			return err
-		}
+// PreSignPostPolicy returns the form for browser upload to FileSystemStorageServer.
+// Conditions are carried by the signed token in the field "policy". The URL
+// is relative to the server's address.
+func (s *Service) PreSignPostPolicy(o *bsw.Object, opts bsw.PostPolicyOptions) (*bsw.PostPolicy, error) {
 
-		// Copy the contents to the destination file
-		if _, err := io.Copy(dFile, sFile); err != nil {
-			sFile.Close()
-			return err
-		}
+	// browser forms can't send customer key headers.
+	if o.Encryption().Mode == bsw.SSECustomer {
+		return nil, bsw.ErrNotSupported.Capture().SetPairs("backend", "fs", "op", "post policy", "encryption", bsw.SSECustomer)
+	}
+	if _, err := writeSealer(o, s.atRest); err != nil {
+		return nil, err
+	}
 
-		// Close the source file
-		sFile.Close()
+	p := tokenPayload{
+		Op:         OpPost,
+		Bucket:     o.Bucket(),
+		Key:        o.Key(),
+		Exp:        time.Now().Unix() + int64(opts.Expires.Seconds()),
+		Metadata:   o.Metadata(),
+		Policy:     &opts,
+		Encryption: newTokenEncryption(o),
+	}
+	if h := o.Headers(); !h.IsZero() {
+		p.Headers = &h
 	}
 
-	// If all files merged successfully, delete them
-	for _, srcFile := range srcFiles {
-		if err := os.Remove(srcFile); err != nil {
-			return err
-		}
+	token, err := s.encodeToken(p)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	fields := map[string]string{"policy": token, "key": o.Key()}
+	if opts.ContentType != "" {
+		fields["Content-Type"] = opts.ContentType
+	}
+	return &bsw.PostPolicy{URL: PostPolicyPath, Fields: fields}, nil
 }
 
 func (s *Service) Name() string {
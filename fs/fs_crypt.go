@@ -0,0 +1,390 @@
+package fs
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+	"github.com/axkit/vatel"
+)
+
+// Headers carrying customer key, the same S3 uses for SSE-C.
+const (
+	hdrCustomerAlgorithm = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	hdrCustomerKey       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	hdrCustomerKeyMD5    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+// Encrypted file is the header followed by chunks of content sealed by
+// AES-GCM. The header is magic, format version and random nonce prefix.
+// Nonce of the chunk is the prefix followed by big endian chunk index. The
+// header and the flag of the last chunk are authenticated as additional data,
+// so chunks can't be reordered, dropped or moved to another file. Chunks are
+// sealed independently, it allows reading ranges without decrypting the whole
+// file.
+const (
+	sealMagic      = "BSWE"
+	sealVersion    = 1
+	sealHeaderLen  = len(sealMagic) + 1 + 8
+	sealChunkSize  = 64 << 10
+	sealTagSize    = 16
+	sealSealedSize = sealChunkSize + sealTagSize
+)
+
+var (
+	// ErrEncryptionKey is returned when the key required to read the object
+	// is missing or does not match the key the object is encrypted by.
+	ErrEncryptionKey = errors.New("encryption key does not match").StatusCode(400)
+
+	// ErrCorruptedFile is returned when encrypted file fails authentication.
+	ErrCorruptedFile = errors.New("encrypted file is corrupted").Critical().StatusCode(500)
+)
+
+// newGCM returns AES-GCM for 16, 24 or 32 bytes long key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealer holds the key the object is encrypted by.
+type sealer struct {
+	aead cipher.AEAD
+	mode bsw.EncryptionMode
+
+	// keySHA256 is base64 encoded SHA-256 of customer key.
+	keySHA256 string
+}
+
+// writeSealer returns sealer for writing the object. Customer key of the
+// object is used if it's set, managed key otherwise. Managed key is used by
+// default if it's configured. Returns nil if the object is stored as is.
+func writeSealer(o *bsw.Object, managed cipher.AEAD) (*sealer, error) {
+
+	e := o.Encryption()
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch e.Mode {
+	case bsw.SSECustomer:
+		aead, err := newGCM(e.CustomerKey)
+		if err != nil {
+			return nil, errors.Catch(err).StatusCode(500).Msg("creating cipher failed")
+		}
+		return &sealer{aead: aead, mode: bsw.SSECustomer, keySHA256: e.CustomerKeySHA256()}, nil
+	case bsw.SSEKMS, bsw.SSEScope:
+		return nil, bsw.ErrNotSupported.Capture().SetPairs("backend", "fs", "encryption", e.Mode)
+	case bsw.SSEManaged:
+		if managed == nil {
+			return nil, bsw.ErrNotSupported.Capture().SetPairs("backend", "fs", "encryption", e.Mode, "reason", "at rest key is not configured")
+		}
+	}
+
+	if managed == nil {
+		return nil, nil
+	}
+	return &sealer{aead: managed, mode: bsw.SSEManaged}, nil
+}
+
+// readSealer returns sealer for reading the object stored with the record.
+// Returns nil if the object is not encrypted.
+func readSealer(o *bsw.Object, rec *metaRecord, managed cipher.AEAD) (*sealer, error) {
+
+	switch rec.Encryption {
+	case "":
+		return nil, nil
+	case bsw.SSEManaged:
+		if managed == nil {
+			return nil, errors.New("at rest key is not configured").Critical().
+				SetPairs("bucket", o.Bucket(), "key", o.Key()).StatusCode(500)
+		}
+		return &sealer{aead: managed, mode: bsw.SSEManaged}, nil
+	case bsw.SSECustomer:
+		e := o.Encryption()
+		if e.Mode != bsw.SSECustomer {
+			return nil, ErrEncryptionKey.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "reason", "customer key required")
+		}
+		if subtle.ConstantTimeCompare([]byte(e.CustomerKeySHA256()), []byte(rec.KeySHA256)) != 1 {
+			return nil, ErrEncryptionKey.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		return writeSealer(o, nil)
+	}
+	return nil, errors.New("unknown encryption of the object").Critical().
+		SetPairs("bucket", o.Bucket(), "key", o.Key(), "encryption", rec.Encryption).StatusCode(500)
+}
+
+// customerKeyHeaders returns headers carrying customer key of e, nil if
+// e is not customer key encryption.
+func customerKeyHeaders(e bsw.Encryption) map[string]string {
+	if e.Mode != bsw.SSECustomer {
+		return nil
+	}
+	return map[string]string{
+		hdrCustomerAlgorithm: "AES256",
+		hdrCustomerKey:       e.CustomerKeyBase64(),
+		hdrCustomerKeyMD5:    e.CustomerKeyMD5(),
+	}
+}
+
+// UploadHeaders returns headers the client must send with upload request,
+// only objects encrypted by customer key require them.
+func (s *Service) UploadHeaders(o *bsw.Object) map[string]string {
+	return customerKeyHeaders(o.Encryption())
+}
+
+// DownloadHeaders returns headers the client must send with download request,
+// only objects encrypted by customer key require them.
+func (s *Service) DownloadHeaders(o *bsw.Object) map[string]string {
+	return customerKeyHeaders(o.Encryption())
+}
+
+// useCustomerKey sets customer key sent by request headers to the token's
+// object. Tokens issued for customer key require the same key.
+func (t *Token) useCustomerKey(ctx vatel.Context) error {
+
+	v := ctx.Header(hdrCustomerKey)
+	if len(v) == 0 {
+		if t.CustomerKeySHA256 != "" {
+			return ErrEncryptionKey.Capture().Set("reason", "customer key required")
+		}
+		return nil
+	}
+
+	if alg := string(ctx.Header(hdrCustomerAlgorithm)); alg != "" && alg != "AES256" {
+		return errors.ValidationFailed("unsupported customer key algorithm").Set("algorithm", alg)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(v))
+	if err != nil {
+		return errors.ValidationFailed("malformed customer key")
+	}
+
+	e := bsw.Encryption{Mode: bsw.SSECustomer, CustomerKey: key}
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	if md5 := string(ctx.Header(hdrCustomerKeyMD5)); md5 != "" && md5 != e.CustomerKeyMD5() {
+		return errors.ValidationFailed("customer key md5 mismatch")
+	}
+
+	if t.CustomerKeySHA256 != "" && e.CustomerKeySHA256() != t.CustomerKeySHA256 {
+		return ErrEncryptionKey.Capture().SetPairs("bucket", t.Object.Bucket(), "key", t.Object.Key())
+	}
+
+	t.Object.SetEncryption(e)
+	return nil
+}
+
+// sealedSize returns size of encrypted file holding n bytes of content.
+func sealedSize(n int64) int64 {
+	chunks := (n + sealChunkSize - 1) / sealChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(sealHeaderLen) + n + chunks*sealTagSize
+}
+
+// plainSize returns size of content held by encrypted file of size n.
+// Returns false if there is no content size resulting in file of size n.
+func plainSize(n int64) (int64, bool) {
+	body := n - int64(sealHeaderLen)
+	if body < sealTagSize {
+		return 0, false
+	}
+	res := body/sealSealedSize*sealChunkSize + body%sealSealedSize
+	if body%sealSealedSize != 0 {
+		res -= sealTagSize
+	}
+	return res, res >= 0 && sealedSize(res) == n
+}
+
+// chunkNonce returns nonce of the chunk idx.
+func chunkNonce(hdr []byte, idx int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, hdr[len(sealMagic)+1:sealHeaderLen])
+	binary.BigEndian.PutUint32(nonce[8:], uint32(idx))
+	return nonce
+}
+
+// chunkAD returns additional data of the chunk.
+func chunkAD(hdr []byte, last bool) []byte {
+	ad := make([]byte, sealHeaderLen+1)
+	copy(ad, hdr)
+	if last {
+		ad[sealHeaderLen] = 1
+	}
+	return ad
+}
+
+// sealReader encrypts content read from r.
+type sealReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	hdr  []byte
+	idx  int64
+	in   []byte
+	out  []byte
+	done bool
+}
+
+// newSealReader returns reader of encrypted file holding content of r.
+func (sl *sealer) newSealReader(r io.Reader) (io.Reader, error) {
+
+	hdr := make([]byte, sealHeaderLen)
+	copy(hdr, sealMagic)
+	hdr[len(sealMagic)] = sealVersion
+	if _, err := rand.Read(hdr[len(sealMagic)+1:]); err != nil {
+		return nil, errors.Catch(err).Critical().StatusCode(500).Msg("generating nonce failed")
+	}
+
+	return &sealReader{
+		r:    bufio.NewReaderSize(r, sealChunkSize),
+		aead: sl.aead,
+		hdr:  hdr,
+		in:   make([]byte, sealChunkSize),
+		out:  hdr,
+	}, nil
+}
+
+func (sr *sealReader) Read(p []byte) (int, error) {
+	if len(sr.out) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.out)
+	sr.out = sr.out[n:]
+	return n, nil
+}
+
+// next seals the next chunk. The chunk is the last if content ends within
+// it or right after it.
+func (sr *sealReader) next() error {
+
+	n, err := io.ReadFull(sr.r, sr.in)
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+	if !last {
+		if _, err := sr.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	sr.out = sr.aead.Seal(sr.out[:0:0], chunkNonce(sr.hdr, sr.idx), sr.in[:n], chunkAD(sr.hdr, last))
+	sr.idx++
+	sr.done = last
+	return nil
+}
+
+// openReaderAt decrypts content of encrypted file. The last decrypted chunk
+// is cached, so sequential reads decrypt every chunk once.
+type openReaderAt struct {
+	ra   io.ReaderAt
+	aead cipher.AEAD
+	hdr  []byte
+	size int64
+	last int64
+
+	mu    sync.Mutex
+	idx   int64
+	chunk []byte
+}
+
+// newOpenReaderAt returns reader of content of encrypted file ra of size n.
+func (sl *sealer) newOpenReaderAt(ra io.ReaderAt, n int64) (*openReaderAt, error) {
+
+	size, ok := plainSize(n)
+	if !ok {
+		return nil, ErrCorruptedFile.Capture().SetPairs("reason", "invalid size", "size", n)
+	}
+
+	hdr := make([]byte, sealHeaderLen)
+	if _, err := ra.ReadAt(hdr, 0); err != nil {
+		return nil, errors.Catch(err).StatusCode(500).Msg("reading file header failed")
+	}
+	if string(hdr[:len(sealMagic)]) != sealMagic || hdr[len(sealMagic)] != sealVersion {
+		return nil, ErrCorruptedFile.Capture().Set("reason", "invalid header")
+	}
+
+	last := (size + sealChunkSize - 1) / sealChunkSize
+	if last > 0 {
+		last--
+	}
+	return &openReaderAt{ra: ra, aead: sl.aead, hdr: hdr, size: size, last: last, idx: -1}, nil
+}
+
+// Size returns size of the content.
+func (or *openReaderAt) Size() int64 {
+	return or.size
+}
+
+func (or *openReaderAt) ReadAt(p []byte, off int64) (int, error) {
+
+	if off < 0 {
+		return 0, errors.ValidationFailed("negative offset")
+	}
+
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	n := 0
+	for len(p) > 0 && off < or.size {
+		idx := off / sealChunkSize
+		if err := or.load(idx); err != nil {
+			return n, err
+		}
+		c := copy(p, or.chunk[off-idx*sealChunkSize:])
+		n += c
+		p = p[c:]
+		off += int64(c)
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// load decrypts the chunk idx unless it's decrypted already.
+func (or *openReaderAt) load(idx int64) error {
+
+	if or.idx == idx {
+		return nil
+	}
+
+	n := or.size - idx*sealChunkSize
+	if n > sealChunkSize {
+		n = sealChunkSize
+	}
+
+	buf := make([]byte, n+sealTagSize)
+	if _, err := or.ra.ReadAt(buf, int64(sealHeaderLen)+idx*sealSealedSize); err != nil && err != io.EOF {
+		return errors.Catch(err).StatusCode(500).Msg("reading file failed")
+	}
+
+	plain, err := or.aead.Open(buf[:0], chunkNonce(or.hdr, idx), buf, chunkAD(or.hdr, idx == or.last))
+	if err != nil {
+		or.idx = -1
+		return ErrCorruptedFile.Capture().SetPairs("reason", "authentication failed", "chunk", idx)
+	}
+	or.idx, or.chunk = idx, plain
+	return nil
+}
@@ -0,0 +1,186 @@
+package fs
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkit/vatel"
+)
+
+// ServeObject writes the object to the response following HTTP semantics:
+// validators (ETag, Last-Modified), conditional requests (If-None-Match,
+// If-Modified-Since) answered by 304, single range requests answered by 206
+// with If-Range respected and HEAD requests. User metadata is returned by
+// x-amz-meta-* headers. Response headers are overridden by the token.
+func (s *FileSystemStorageServer) ServeObject(ctx vatel.Context, t *Token) error {
+
+	f, oi, h, err := openObject(s.cfg.basePath, t.Object, s.cfg.atRest)
+	if err != nil {
+		return err
+	}
+
+	var (
+		rctx  = ctx.RequestCtx()
+		etag  = `"` + oi.ETag + `"`
+		mtime = oi.LastModified.UTC().Truncate(time.Second)
+		size  = f.size
+		ro    = &t.Response
+	)
+
+	ctx.SetHeader([]byte("ETag"), []byte(etag))
+	ctx.SetHeader([]byte("Last-Modified"), []byte(mtime.Format(http.TimeFormat)))
+	ctx.SetHeader([]byte("Accept-Ranges"), []byte("bytes"))
+	for k, v := range oi.Metadata {
+		if v != nil {
+			ctx.SetHeader([]byte("x-amz-meta-"+strings.ToLower(k)), []byte(*v))
+		}
+	}
+	setHeaderIf(ctx, "Content-Disposition", override(ro.ContentDisposition, h.ContentDisposition))
+	setHeaderIf(ctx, "Cache-Control", override(ro.CacheControl, h.CacheControl))
+	setHeaderIf(ctx, "Content-Encoding", override(ro.ContentEncoding, h.ContentEncoding))
+	setHeaderIf(ctx, "Content-Language", override(ro.ContentLanguage, h.ContentLanguage))
+
+	if notModified(string(ctx.Header("If-None-Match")), string(ctx.Header("If-Modified-Since")), etag, mtime) {
+		f.Close()
+		ctx.SetStatusCode(http.StatusNotModified)
+		return nil
+	}
+
+	ct := override(ro.ContentType, oi.ContentType)
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	ctx.SetContentType([]byte(ct))
+
+	from, n := int64(0), size
+	if rng := string(ctx.Header("Range")); rng != "" && ifRange(string(ctx.Header("If-Range")), etag, mtime) {
+		start, end, ok := parseRange(rng, size)
+		switch {
+		case ok && start < 0:
+			f.Close()
+			ctx.SetHeader([]byte("Content-Range"), []byte("bytes */"+strconv.FormatInt(size, 10)))
+			ctx.SetStatusCode(http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		case ok:
+			from, n = start, end-start+1
+			ctx.SetHeader([]byte("Content-Range"), []byte("bytes "+strconv.FormatInt(start, 10)+"-"+
+				strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(size, 10)))
+			ctx.SetStatusCode(http.StatusPartialContent)
+		}
+	}
+
+	// the body is not sent for HEAD request, but Content-Length is.
+	rctx.SetBodyStream(f.section(from, n), int(n))
+	return nil
+}
+
+// override returns val if it's not empty or def otherwise.
+func override(val, def string) string {
+	if val != "" {
+		return val
+	}
+	return def
+}
+
+func setHeaderIf(ctx vatel.Context, name, val string) {
+	if val != "" {
+		ctx.SetHeader([]byte(name), []byte(val))
+	}
+}
+
+// notModified evaluates If-None-Match and, if it's absent, If-Modified-Since.
+func notModified(inm, ims, etag string, mtime time.Time) bool {
+	if inm != "" {
+		return etagListMatch(inm, etag, true)
+	}
+	if ims == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	return err == nil && !mtime.After(t)
+}
+
+// ifRange reports whether Range header applies according to If-Range.
+// Only strong comparison is allowed for If-Range.
+func ifRange(ir, etag string, mtime time.Time) bool {
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etagListMatch(ir, etag, false)
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && mtime.Equal(t)
+}
+
+// etagListMatch reports whether comma separated list of entity tags contains etag.
+func etagListMatch(list, etag string, weak bool) bool {
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "*" {
+			return true
+		}
+		if weak {
+			e = strings.TrimPrefix(e, "W/")
+		}
+		if e == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses single byte range of Range header. Returns ok false if
+// the header must be ignored: it's malformed or has several ranges, the whole
+// content is served then. Returns negative start if the range is not satisfiable.
+func parseRange(rng string, size int64) (start, end int64, ok bool) {
+
+	const prefix = "bytes="
+	if !strings.HasPrefix(rng, prefix) || strings.Contains(rng, ",") {
+		return 0, 0, false
+	}
+
+	spec := strings.TrimSpace(rng[len(prefix):])
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, 0, false
+	}
+
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// suffix range: last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 || size == 0 {
+			return -1, 0, true
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return -1, 0, true
+	}
+	return start, end, true
+}
@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"strings"
+
+	"github.com/axkit/errors"
+	"github.com/axkit/gonfig"
+)
+
+// URLKey is a key of the keyring signed URL tokens are encrypted with.
+type URLKey struct {
+	// ID identifies the key. It's stored in tokens, keep it short.
+	ID string `json:"id"`
+
+	// Key is AES key, 16, 24 or 32 bytes long.
+	Key string `json:"key"`
+}
+
+// maxKeyIDLen is the maximum length of key ID.
+const maxKeyIDLen = 255
+
+var ErrUnknownTokenKey = errors.New("signed url token key is unknown or retired").StatusCode(403)
+
+// keyring holds keys tokens are verified by. Tokens are signed by the active key.
+type keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// envKeys are keyring parameters read from environment variables.
+// FS_URL_KEYS holds comma separated list of "id:key" pairs,
+// FS_URL_ACTIVE_KEY holds ID of the active key. FS_AT_REST_KEY holds the
+// key objects are encrypted by.
+type envKeys struct {
+	Keys      gonfig.String `cfg:"fs_url_keys"`
+	ActiveKey gonfig.String `cfg:"fs_url_active_key"`
+	AtRestKey gonfig.String `cfg:"fs_at_rest_key"`
+}
+
+// parseURLKeys parses comma separated list of "id:key" pairs.
+func parseURLKeys(s string) ([]URLKey, error) {
+
+	var res []URLKey
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		i := strings.IndexByte(pair, ':')
+		if i < 0 {
+			return nil, errors.ValidationFailed("invalid url key, expected id:key").Set("id", pair)
+		}
+		res = append(res, URLKey{ID: pair[:i], Key: pair[i+1:]})
+	}
+	return res, nil
+}
+
+// newKeyring builds keyring from cfg. URLEncryptionKey, if set, is added
+// to the keyring with empty ID and used for signing if ActiveURLKeyID is
+// not set.
+func newKeyring(cfg *Config) (*keyring, error) {
+
+	kr := keyring{
+		active: cfg.ActiveURLKeyID,
+		keys:   make(map[string]cipher.AEAD, len(cfg.URLKeys)+1),
+	}
+
+	keys := cfg.URLKeys
+	if cfg.URLEncryptionKey != "" {
+		keys = append([]URLKey{{Key: cfg.URLEncryptionKey}}, keys...)
+	}
+
+	for _, k := range keys {
+		if len(k.ID) > maxKeyIDLen {
+			return nil, errors.ValidationFailed("url key id is too long").Set("id", k.ID)
+		}
+
+		if _, ok := kr.keys[k.ID]; ok {
+			return nil, errors.ValidationFailed("duplicate url key id").Set("id", k.ID)
+		}
+
+		block, err := aes.NewCipher([]byte(k.Key))
+		if err != nil {
+			return nil, errors.Catch(err).Critical().Set("id", k.ID).StatusCode(500).Msg("invalid url encryption key")
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Catch(err).Critical().Set("id", k.ID).StatusCode(500).Msg("creating gcm failed")
+		}
+		kr.keys[k.ID] = aead
+	}
+
+	if _, ok := kr.keys[kr.active]; !ok {
+		return nil, errors.ValidationFailed("active url key not found").Set("id", kr.active)
+	}
+	return &kr, nil
+}
+
+// signer returns ID and cipher of the active key.
+func (kr *keyring) signer() (string, cipher.AEAD) {
+	return kr.active, kr.keys[kr.active]
+}
+
+// verifier returns cipher of the key identified by id.
+func (kr *keyring) verifier(id string) (cipher.AEAD, error) {
+	aead, ok := kr.keys[id]
+	if !ok {
+		return nil, ErrUnknownTokenKey.Capture().Set("id", id)
+	}
+	return aead, nil
+}
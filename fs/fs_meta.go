@@ -0,0 +1,232 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// metadataDir is the directory under base path holding metadata records of
+// objects. Record of the object is stored in file named by SHA-256 of the key
+// under bucket's directory, fanned out by first two hex digits.
+const metadataDir = ".metadata"
+
+// metaRecord is the metadata of the object stored next to its file.
+type metaRecord struct {
+	Key      string             `json:"key"`
+	Headers  bsw.Headers        `json:"headers"`
+	Size     int64              `json:"size"`
+	SHA256   string             `json:"sha256,omitempty"`
+	Created  time.Time          `json:"created"`
+	ModTime  time.Time          `json:"modTime"`
+	Metadata map[string]*string `json:"metadata,omitempty"`
+
+	// Encryption is the kind of the key the file is encrypted by, KeySHA256
+	// is base64 encoded SHA-256 of customer key. Size is the size of the
+	// content then, not of the file.
+	Encryption bsw.EncryptionMode `json:"encryption,omitempty"`
+	KeySHA256  string             `json:"keySha256,omitempty"`
+}
+
+// fileSize returns expected size of the object's file.
+func (rec *metaRecord) fileSize() int64 {
+	if rec.Encryption != "" {
+		return sealedSize(rec.Size)
+	}
+	return rec.Size
+}
+
+// metaPath returns path to the metadata record of the object.
+func metaPath(basePath string, o *bsw.Object) (string, error) {
+	if _, err := objectPath(basePath, o); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(o.Key()))
+	h := hex.EncodeToString(sum[:])
+	return filepath.Join(basePath, metadataDir, o.Bucket(), h[:2], h+".json"), nil
+}
+
+// readMeta returns metadata record of the object. Returns false if there is
+// no valid record.
+func readMeta(basePath string, o *bsw.Object) (metaRecord, bool) {
+
+	var rec metaRecord
+
+	mp, err := metaPath(basePath, o)
+	if err != nil {
+		return rec, false
+	}
+
+	buf, err := os.ReadFile(mp)
+	if err != nil {
+		return rec, false
+	}
+
+	if json.Unmarshal(buf, &rec) != nil || rec.Key != o.Key() {
+		return rec, false
+	}
+	return rec, true
+}
+
+func writeMeta(basePath string, o *bsw.Object, rec *metaRecord) error {
+
+	mp, err := metaPath(basePath, o)
+	if err != nil {
+		return err
+	}
+
+	rec.Key = o.Key()
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("encoding metadata record failed")
+	}
+	return writeFile(context.Background(), mp, bytes.NewReader(buf), int64(len(buf)))
+}
+
+// removeMeta removes metadata record of the object. Missing record is not an error.
+func removeMeta(basePath string, o *bsw.Object) error {
+
+	mp, err := metaPath(basePath, o)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(mp); err != nil && !os.IsNotExist(err) {
+		return errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("removing metadata record failed")
+	}
+	removeEmptyDirs(filepath.Dir(mp), filepath.Join(basePath, metadataDir, o.Bucket()))
+	return nil
+}
+
+// putFile writes content of r to the object's file fp and stores metadata
+// record of the object with headers h. The content is encrypted if sl is not
+// nil. check, if not nil, receives SHA-256 of the content before the file is
+// renamed into place.
+func putFile(ctx context.Context, basePath string, o *bsw.Object, fp string, r io.Reader, size int64, h bsw.Headers, sl *sealer, check func(sum []byte) error) error {
+
+	var (
+		sum   = sha256.New()
+		n     byteCounter
+		src   = io.TeeReader(r, io.MultiWriter(sum, &n))
+		fsize = size
+		err   error
+	)
+
+	if sl != nil {
+		if src, err = sl.newSealReader(src); err != nil {
+			return err
+		}
+		if size >= 0 {
+			fsize = sealedSize(size)
+		}
+	}
+
+	err = writeFileChecked(ctx, fp, src, fsize, func() error {
+		if check == nil {
+			return nil
+		}
+		return check(sum.Sum(nil))
+	})
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(fp)
+	if err != nil {
+		return errors.Catch(err).Set("path", fp).StatusCode(500).Msg("reading file info failed")
+	}
+
+	rec := metaRecord{
+		Headers:  h,
+		Size:     int64(n),
+		SHA256:   hex.EncodeToString(sum.Sum(nil)),
+		Created:  time.Now().UTC(),
+		ModTime:  fi.ModTime(),
+		Metadata: o.Metadata(),
+	}
+	rec.Headers.ContentType = contentTypeOf(o, h.ContentType)
+	if sl != nil {
+		rec.Encryption, rec.KeySHA256 = sl.mode, sl.keySHA256
+	}
+	return writeMeta(basePath, o, &rec)
+}
+
+// byteCounter counts bytes written.
+type byteCounter int64
+
+func (bc *byteCounter) Write(p []byte) (int, error) {
+	*bc += byteCounter(len(p))
+	return len(p), nil
+}
+
+// contentTypeOf returns contentType if it's not empty or content type
+// guessed by the key's extension.
+func contentTypeOf(o *bsw.Object, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	return mime.TypeByExtension(filepath.Ext(o.Key()))
+}
+
+// objectInfo returns attributes of the object's file completed by its
+// metadata record. The record is ignored if it's stale, i.e. the file was
+// replaced bypassing the service and its size or modification time differ.
+func objectInfo(basePath string, o *bsw.Object, fi os.FileInfo) bsw.ObjectInfo {
+	oi, _ := objectMeta(basePath, o, fi)
+	return oi
+}
+
+// objectMeta is like objectInfo but returns stored headers as well.
+func objectMeta(basePath string, o *bsw.Object, fi os.FileInfo) (bsw.ObjectInfo, bsw.Headers) {
+	rec, ok := objectRecord(basePath, o, fi)
+	return recordInfo(o, fi, &rec, ok)
+}
+
+// objectRecord returns metadata record of the object's file. Returns false
+// if there is no record or it's stale. Encryption of stale record is kept,
+// the file modified elsewhere must fail authentication instead of being
+// read as is.
+func objectRecord(basePath string, o *bsw.Object, fi os.FileInfo) (metaRecord, bool) {
+	rec, ok := readMeta(basePath, o)
+	if !ok {
+		return metaRecord{}, false
+	}
+	if rec.fileSize() != fi.Size() || !rec.ModTime.Equal(fi.ModTime()) {
+		return metaRecord{Encryption: rec.Encryption, KeySHA256: rec.KeySHA256}, false
+	}
+	return rec, true
+}
+
+// recordInfo returns attributes of the object's file completed by the record if ok.
+func recordInfo(o *bsw.Object, fi os.FileInfo, rec *metaRecord, ok bool) (bsw.ObjectInfo, bsw.Headers) {
+
+	oi := fileInfo(o, fi)
+	if !ok {
+		return oi, bsw.Headers{ContentType: oi.ContentType}
+	}
+
+	oi.Size = rec.Size
+	if rec.Headers.ContentType != "" {
+		oi.ContentType = rec.Headers.ContentType
+	}
+	oi.ContentDisposition = rec.Headers.ContentDisposition
+	oi.CacheControl = rec.Headers.CacheControl
+	oi.ContentEncoding = rec.Headers.ContentEncoding
+	oi.ContentLanguage = rec.Headers.ContentLanguage
+	oi.Metadata = rec.Metadata
+	oi.Checksum = rec.SHA256
+	oi.Created = rec.Created
+	return oi, rec.Headers
+}
@@ -0,0 +1,452 @@
+package fs
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// multipartDir is the directory under base path holding multipart uploads
+// in progress. Every upload has own directory named by upload ID with
+// upload record and part files named by part number.
+const multipartDir = ".multipart"
+
+// uploadFile is the name of the file holding upload record.
+const uploadFile = "upload.json"
+
+type uploadRecord struct {
+	Bucket    string             `json:"bucket"`
+	Key       string             `json:"key"`
+	Initiated time.Time          `json:"initiated"`
+	Metadata  map[string]*string `json:"metadata,omitempty"`
+	Headers   bsw.Headers        `json:"headers"`
+
+	// Sealed is true if part files are encrypted by at rest key.
+	Sealed bool `json:"sealed,omitempty"`
+
+	// CustomerKeySHA256 is base64 encoded SHA-256 of customer key if the
+	// upload is started with one. Part files are encrypted by the key, parts
+	// are uploaded and the upload is completed with the same key only.
+	CustomerKeySHA256 string `json:"customerKeySHA256,omitempty"`
+}
+
+// maxParts is the maximum number of parts in multipart upload.
+const maxParts = 10000
+
+// uploadDir returns path to the directory of the upload.
+func uploadDir(basePath, uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", errors.ValidationFailed("invalid upload id").Set("uploadID", uploadID)
+	}
+	return filepath.Join(basePath, multipartDir, uploadID), nil
+}
+
+func partFile(dir string, partNumber int64) string {
+	return filepath.Join(dir, strconv.FormatInt(partNumber, 10))
+}
+
+// partETagFile returns path to the file holding ETag of the part file.
+func partETagFile(fp string) string {
+	return fp + ".etag"
+}
+
+// readUpload returns directory and record of the upload belonging to the object.
+func readUpload(basePath string, o *bsw.Object, uploadID string) (string, uploadRecord, error) {
+
+	var ur uploadRecord
+
+	dir, err := uploadDir(basePath, uploadID)
+	if err != nil {
+		return "", ur, err
+	}
+
+	buf, err := os.ReadFile(filepath.Join(dir, uploadFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ur, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+		}
+		return "", ur, errors.Catch(err).SetPairs("uploadID", uploadID).StatusCode(500).Msg("reading upload record failed")
+	}
+
+	if err := json.Unmarshal(buf, &ur); err != nil {
+		return "", ur, errors.Catch(err).SetPairs("uploadID", uploadID).StatusCode(500).Critical().Msg("invalid upload record")
+	}
+
+	if ur.Bucket != o.Bucket() || ur.Key != o.Key() {
+		return "", ur, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+	}
+	return dir, ur, nil
+}
+
+func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+	return s.PreSignMultipartObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignMultipartObjectURLContext creates upload directory and returns signed
+// token for every part. Parts are uploaded by FileSystemStorageServer. Parts
+// of the object encrypted by customer key must be uploaded with the key
+// headers, see UploadHeaders.
+func (s *Service) PreSignMultipartObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+
+	if o.Parts() < 1 || o.Parts() > maxParts {
+		return nil, "", bsw.ErrWrongInvocation.Capture().Set("parts", o.Parts())
+	}
+
+	if _, err := objectPath(s.cfg.BasePath, o); err != nil {
+		return nil, "", err
+	}
+
+	sl, err := writeSealer(o, s.atRest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, "", errors.Catch(err).Critical().StatusCode(500).Msg("generating upload id failed")
+	}
+	uploadID := hex.EncodeToString(buf[:])
+
+	dir, err := uploadDir(s.cfg.BasePath, uploadID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ur := uploadRecord{
+		Bucket:    o.Bucket(),
+		Key:       o.Key(),
+		Initiated: time.Now(),
+		Metadata:  o.Metadata(),
+		Headers:   o.Headers(),
+	}
+	if sl != nil && sl.mode == bsw.SSECustomer {
+		ur.CustomerKeySHA256 = sl.keySHA256
+	} else {
+		ur.Sealed = s.atRest != nil
+	}
+
+	rec, err := json.Marshal(ur)
+	if err != nil {
+		return nil, "", errors.Catch(err).Critical().StatusCode(500).Msg("encoding upload record failed")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", errors.Catch(err).Set("path", dir).StatusCode(500).Critical().Msg("creating upload directory failed")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, uploadFile), rec, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", errors.Catch(err).Set("path", dir).StatusCode(500).Critical().Msg("writing upload record failed")
+	}
+
+	exp := time.Now().Unix() + int64(timeout.Seconds())
+
+	res := make([]string, o.Parts())
+	for i := range res {
+		t, err := s.encodeToken(tokenPayload{
+			Op:         OpPart,
+			Bucket:     o.Bucket(),
+			Key:        o.Key(),
+			Exp:        exp,
+			UploadID:   uploadID,
+			PartNumber: int64(i + 1),
+			Encryption: newTokenEncryption(o),
+		})
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		res[i] = t
+	}
+	return res, uploadID, nil
+}
+
+// CompleteMultipartUpload
+func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+	return s.CompleteMultipartUploadContext(context.Background(), o, uploadID, parts)
+}
+
+// CompleteMultipartUploadContext checks parts against uploaded ones, merges them
+// in part number order into the object's file and removes the upload directory.
+func (s *Service) CompleteMultipartUploadContext(ctx context.Context, o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+
+	fp, err := objectPath(s.cfg.BasePath, o)
+	if err != nil {
+		return err
+	}
+
+	if len(parts) == 0 {
+		return errors.ValidationFailed("no parts to complete").SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+	}
+
+	uploaded, err := s.ListParts(ctx, o, uploadID)
+	if err != nil {
+		return err
+	}
+
+	etags := make(map[int64]string, len(uploaded))
+	for i := range uploaded {
+		etags[uploaded[i].PartNumber] = uploaded[i].ETag
+	}
+
+	dir, _ := uploadDir(s.cfg.BasePath, uploadID)
+	srcFiles := make([]string, len(parts))
+	for i := range parts {
+		pn := *parts[i].PartNumberPtr()
+		if i > 0 && pn <= *parts[i-1].PartNumberPtr() {
+			return errors.ValidationFailed("parts must be in ascending order").
+				SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID, "part", pn)
+		}
+
+		etag, ok := etags[pn]
+		if !ok {
+			return errors.ValidationFailed("part not uploaded").
+				SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID, "part", pn)
+		}
+		if e := strings.Trim(*parts[i].ETagPtr(), `"`); e != etag {
+			return errors.ValidationFailed("part etag mismatch").
+				SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID, "part", pn, "etag", e)
+		}
+		srcFiles[i] = partFile(dir, pn)
+	}
+
+	_, ur, err := readUpload(s.cfg.BasePath, o, uploadID)
+	if err != nil {
+		return err
+	}
+
+	sl, err := writeSealer(o, s.atRest)
+	if err != nil {
+		return err
+	}
+
+	if ur.CustomerKeySHA256 == "" && o.Encryption().Mode == bsw.SSECustomer {
+		return ErrEncryptionKey.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID,
+			"reason", "upload is not started with customer key")
+	}
+
+	psl, err := uploadSealer(&ur, o, s.atRest)
+	if err != nil {
+		return err
+	}
+
+	pr := &partsReader{files: srcFiles, sl: psl}
+	defer pr.Close()
+
+	dst := bsw.NewObject(s, o.Bucket(), o.Key(), bsw.WithMetadata(ur.Metadata))
+	if err := putFile(ctx, s.cfg.BasePath, dst, fp, pr, -1, ur.Headers, sl, nil); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Catch(err).Set("path", dir).StatusCode(500).Msg("removing upload directory failed")
+	}
+	return nil
+}
+
+// uploadSealer returns sealer of part files of the upload or nil if parts
+// are stored as is. Parts of the upload started with customer key are
+// encrypted by customer key of o, it must be the key the upload is started with.
+func uploadSealer(ur *uploadRecord, o *bsw.Object, managed cipher.AEAD) (*sealer, error) {
+	if ur.CustomerKeySHA256 != "" {
+		e := o.Encryption()
+		if e.Mode != bsw.SSECustomer {
+			return nil, ErrEncryptionKey.Capture().SetPairs("bucket", ur.Bucket, "key", ur.Key, "reason", "customer key required")
+		}
+		if subtle.ConstantTimeCompare([]byte(e.CustomerKeySHA256()), []byte(ur.CustomerKeySHA256)) != 1 {
+			return nil, ErrEncryptionKey.Capture().SetPairs("bucket", ur.Bucket, "key", ur.Key)
+		}
+		return writeSealer(o, nil)
+	}
+	if !ur.Sealed {
+		return nil, nil
+	}
+	if managed == nil {
+		return nil, errors.New("at rest key is not configured").Critical().
+			SetPairs("bucket", ur.Bucket, "key", ur.Key).StatusCode(500)
+	}
+	return &sealer{aead: managed, mode: bsw.SSEManaged}, nil
+}
+
+// partsReader reads content of part files one after another. Parts are
+// decrypted if sl is not nil.
+type partsReader struct {
+	files []string
+	sl    *sealer
+	cur   io.ReadCloser
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.cur == nil {
+			if len(pr.files) == 0 {
+				return 0, io.EOF
+			}
+			r, err := openPart(pr.files[0], pr.sl)
+			if err != nil {
+				return 0, err
+			}
+			pr.cur, pr.files = r, pr.files[1:]
+		}
+
+		n, err := pr.cur.Read(p)
+		if err == io.EOF {
+			pr.cur.Close()
+			pr.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.cur == nil {
+		return nil
+	}
+	return pr.cur.Close()
+}
+
+// openPart opens part file for reading, decrypting it if sl is not nil.
+func openPart(fp string, sl *sealer) (io.ReadCloser, error) {
+
+	f, err := os.Open(fp)
+	if err != nil || sl == nil {
+		return f, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ra, err := sl.newOpenReaderAt(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return (&objectReader{ReaderAt: ra, Closer: f, size: ra.Size()}).section(0, ra.Size()), nil
+}
+
+// AbortMultipartUpload removes the upload directory with all parts.
+func (s *Service) AbortMultipartUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+
+	dir, _, err := readUpload(s.cfg.BasePath, o, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(500).Msg("removing upload directory failed")
+	}
+	return nil
+}
+
+// ListMultipartUploads returns uploads in progress reading upload records.
+func (s *Service) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]bsw.MultipartUpload, error) {
+
+	des, err := os.ReadDir(filepath.Join(s.cfg.BasePath, multipartDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Catch(err).StatusCode(500).Msg("reading multipart directory failed")
+	}
+
+	var res []bsw.MultipartUpload
+	for _, de := range des {
+		if !de.IsDir() {
+			continue
+		}
+
+		buf, err := os.ReadFile(filepath.Join(s.cfg.BasePath, multipartDir, de.Name(), uploadFile))
+		if err != nil {
+			continue
+		}
+
+		var ur uploadRecord
+		if json.Unmarshal(buf, &ur) != nil || ur.Bucket != bucket || !strings.HasPrefix(ur.Key, prefix) {
+			continue
+		}
+
+		res = append(res, bsw.MultipartUpload{
+			Bucket:    ur.Bucket,
+			Key:       ur.Key,
+			UploadID:  de.Name(),
+			Initiated: ur.Initiated,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Key != res[j].Key {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].Initiated.Before(res[j].Initiated)
+	})
+	return res, nil
+}
+
+// ListParts returns part files of the upload.
+func (s *Service) ListParts(ctx context.Context, o *bsw.Object, uploadID string) ([]bsw.Part, error) {
+
+	dir, ur, err := readUpload(s.cfg.BasePath, o, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Catch(err).SetPairs("uploadID", uploadID).StatusCode(500).Msg("reading upload directory failed")
+	}
+
+	var res []bsw.Part
+	for _, de := range des {
+		pn, err := strconv.ParseInt(de.Name(), 10, 64)
+		if err != nil || de.IsDir() {
+			continue
+		}
+
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		// part without ETag file is being written.
+		etag, err := os.ReadFile(partETagFile(filepath.Join(dir, de.Name())))
+		if err != nil {
+			continue
+		}
+
+		size := fi.Size()
+		if ur.Sealed || ur.CustomerKeySHA256 != "" {
+			size, _ = plainSize(size)
+		}
+
+		res = append(res, bsw.Part{
+			PartNumber:   pn,
+			ETag:         string(etag),
+			Size:         size,
+			LastModified: fi.ModTime(),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].PartNumber < res[j].PartNumber })
+	return res, nil
+}
@@ -0,0 +1,600 @@
+package fs
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// objectPath returns path to the object's file under basePath.
+// Bucket and key are not allowed to escape basePath.
+func objectPath(basePath string, o *bsw.Object) (string, error) {
+	if o.Bucket() == "" || o.Key() == "" {
+		return "", errors.ValidationFailed("bucket and key are required").SetPairs("bucket", o.Bucket(), "key", o.Key())
+	}
+
+	if err := validateBucket(o.Bucket()); err != nil {
+		return "", err
+	}
+	if err := validateKey(o.Key()); err != nil {
+		return "", err
+	}
+
+	root := filepath.Clean(basePath)
+	fp := filepath.Join(root, o.Bucket(), o.Key())
+	if !strings.HasPrefix(fp, filepath.Join(root, o.Bucket())+string(filepath.Separator)) {
+		return "", errors.ValidationFailed("invalid object path").SetPairs("bucket", o.Bucket(), "key", o.Key())
+	}
+	return fp, nil
+}
+
+// validateBucket checks that bucket is a single directory name under basePath.
+func validateBucket(bucket string) error {
+	// directories starting with dot are reserved for internal use.
+	if bucket == "" || bucket[0] == '.' || strings.ContainsAny(bucket, `/\`) {
+		return errors.ValidationFailed("invalid bucket name").Set("bucket", bucket)
+	}
+	return nil
+}
+
+// validateKey checks that key is stored under the same name as given. Keys
+// with dot segments or repeated slashes are rejected, otherwise prefix checks
+// done on the key would not hold for the resulting path.
+func validateKey(key string) error {
+	if path.Clean(key) != key {
+		return errors.ValidationFailed("invalid object key").Set("key", key)
+	}
+	for _, seg := range strings.FieldsFunc(key, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == "." || seg == ".." {
+			return errors.ValidationFailed("invalid object key").Set("key", key)
+		}
+	}
+	return nil
+}
+
+// Put writes content of r to the object's file. Content is written to a temporary
+// file first and renamed into place on success. Content is encrypted by
+// customer key of the object or by at rest key if either is set.
+func (s *Service) Put(ctx context.Context, o *bsw.Object, r io.Reader, size int64) error {
+
+	fp, err := objectPath(s.cfg.BasePath, o)
+	if err != nil {
+		return err
+	}
+
+	sl, err := writeSealer(o, s.atRest)
+	if err != nil {
+		return err
+	}
+
+	return putFile(ctx, s.cfg.BasePath, o, fp, r, size, o.Headers(), sl, nil)
+}
+
+func writeFile(ctx context.Context, fp string, r io.Reader, size int64) error {
+	return writeFileChecked(ctx, fp, r, size, nil)
+}
+
+// writeFileChecked is like writeFile but calls check, if not nil, after the
+// content written. The file is not renamed into place if check fails.
+func writeFileChecked(ctx context.Context, fp string, r io.Reader, size int64, check func() error) error {
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return errors.Catch(err).Set("path", fp).StatusCode(500).Critical().Msg("creating directory failed")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fp), ".tmp-*")
+	if err != nil {
+		return errors.Catch(err).Set("path", fp).StatusCode(500).Critical().Msg("creating temporary file failed")
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, &ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		f.Close()
+		return errors.Catch(err).Set("path", fp).StatusCode(500).Msg("writing file failed")
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Catch(err).Set("path", fp).StatusCode(500).Critical().Msg("writing file failed")
+	}
+
+	if size >= 0 && n != size {
+		return errors.ValidationFailed("content size mismatch").SetPairs("expected", size, "actual", n)
+	}
+
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(f.Name(), fp); err != nil {
+		return errors.Catch(err).Set("path", fp).StatusCode(500).Critical().Msg("renaming file failed")
+	}
+	return nil
+}
+
+// Get opens the object's file for reading. Encrypted content is decrypted
+// while read.
+func (s *Service) Get(ctx context.Context, o *bsw.Object) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	or, oi, _, err := openObject(s.cfg.BasePath, o, s.atRest)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+	return or.section(0, or.size), oi, nil
+}
+
+// GetRange returns n bytes of the object content starting at off.
+func (s *Service) GetRange(ctx context.Context, o *bsw.Object, off, n int64) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	or, oi, _, err := openObject(s.cfg.BasePath, o, s.atRest)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	if err := bsw.CheckRange(off, or.size); err != nil {
+		or.Close()
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	if n < 0 || off+n > or.size {
+		n = or.size - off
+	}
+	return or.section(off, n), oi, nil
+}
+
+// objectReader reads content of the object's file, decrypted if the file
+// is encrypted.
+type objectReader struct {
+	io.ReaderAt
+	io.Closer
+	size int64
+}
+
+// section returns reader of n bytes of content starting at from. Closing
+// the reader closes the file.
+func (or *objectReader) section(from, n int64) io.ReadCloser {
+	return &sectionReadCloser{SectionReader: io.NewSectionReader(or, from, n), Closer: or}
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// openObject opens the object's file for reading. Attributes and headers of
+// the object are taken from its metadata record if it's not stale.
+func openObject(basePath string, o *bsw.Object, managed cipher.AEAD) (*objectReader, bsw.ObjectInfo, bsw.Headers, error) {
+
+	fp, err := objectPath(basePath, o)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, bsw.Headers{}, err
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, bsw.ObjectInfo{}, bsw.Headers{}, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		return nil, bsw.ObjectInfo{}, bsw.Headers{}, errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("opening file failed")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, bsw.ObjectInfo{}, bsw.Headers{}, errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("reading file info failed")
+	}
+
+	rec, ok := objectRecord(basePath, o, fi)
+	oi, h := recordInfo(o, fi, &rec, ok)
+
+	sl, err := readSealer(o, &rec, managed)
+	if err != nil {
+		f.Close()
+		return nil, bsw.ObjectInfo{}, bsw.Headers{}, err
+	}
+
+	if sl == nil {
+		return &objectReader{ReaderAt: f, Closer: f, size: fi.Size()}, oi, h, nil
+	}
+
+	ra, err := sl.newOpenReaderAt(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, bsw.ObjectInfo{}, bsw.Headers{}, err
+	}
+	return &objectReader{ReaderAt: ra, Closer: f, size: ra.Size()}, oi, h, nil
+}
+
+// Stat returns attributes of the object's file.
+func (s *Service) Stat(ctx context.Context, o *bsw.Object) (bsw.ObjectInfo, error) {
+
+	fp, err := objectPath(s.cfg.BasePath, o)
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+
+	fi, err := os.Stat(fp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bsw.ObjectInfo{}, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		return bsw.ObjectInfo{}, errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("reading file info failed")
+	}
+	if fi.IsDir() {
+		return bsw.ObjectInfo{}, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+	}
+
+	return objectInfo(s.cfg.BasePath, o, fi), nil
+}
+
+func fileInfo(o *bsw.Object, fi os.FileInfo) bsw.ObjectInfo {
+	return bsw.ObjectInfo{
+		Bucket:       o.Bucket(),
+		Key:          o.Key(),
+		Size:         fi.Size(),
+		ETag:         fileETag(fi),
+		ContentType:  mime.TypeByExtension(filepath.Ext(o.Key())),
+		LastModified: fi.ModTime(),
+	}
+}
+
+// fileETag returns ETag built from file modification time and size.
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// ctxReader stops reading when ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// Delete removes the object's file and its parent directories left empty.
+func (s *Service) Delete(ctx context.Context, o *bsw.Object) error {
+
+	fp, err := objectPath(s.cfg.BasePath, o)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		return errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("removing file failed")
+	}
+
+	removeEmptyDirs(filepath.Dir(fp), filepath.Join(s.cfg.BasePath, o.Bucket()))
+	return removeMeta(s.cfg.BasePath, o)
+}
+
+// removeEmptyDirs removes dir and its parents while they are empty, stopping at stop.
+func removeEmptyDirs(dir, stop string) {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// DeleteMany removes files one by one.
+func (s *Service) DeleteMany(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
+
+	var res []bsw.DeleteError
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if err := s.Delete(ctx, bsw.NewObject(s, bucket, key)); err != nil {
+			res = append(res, bsw.DeleteError{Key: key, Err: err})
+		}
+	}
+	return res, nil
+}
+
+// DeletePrefix walks the bucket directory and removes files with keys starting with prefix.
+func (s *Service) DeletePrefix(ctx context.Context, bucket, prefix string) ([]bsw.DeleteError, error) {
+
+	if prefix == "" {
+		return nil, bsw.ErrWrongInvocation.Capture().Set("reason", "empty prefix")
+	}
+
+	var keys []string
+	err := s.walk(ctx, bucket, prefix, "", "", func(oi bsw.ObjectInfo) bool {
+		keys = append(keys, oi.Key)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.DeleteMany(ctx, bucket, keys)
+}
+
+// defaultPageSize is used by List if ListOptions.PageSize is not set.
+const defaultPageSize = 1000
+
+// List returns iterator over files of the bucket directory. Page token is
+// the last returned key, the directory is walked on every page request
+// starting from the token till the page is filled.
+func (s *Service) List(ctx context.Context, bucket string, opts bsw.ListOptions) *bsw.ListIterator {
+	return bsw.NewListIterator(ctx, opts, func(ctx context.Context, token string) (bsw.ListPage, error) {
+
+		after := opts.StartAfter
+		if token != "" {
+			after = token
+		}
+
+		size := opts.PageSize
+		if size <= 0 {
+			size = defaultPageSize
+		}
+
+		// one more object is collected to know if there is the next page.
+		var res bsw.ListPage
+		err := s.walk(ctx, bucket, opts.Prefix, after, opts.Delimiter, func(oi bsw.ObjectInfo) bool {
+			res.Objects = append(res.Objects, oi)
+			return len(res.Objects) <= size
+		})
+		if err != nil {
+			return bsw.ListPage{}, err
+		}
+
+		if len(res.Objects) > size {
+			res.Objects = res.Objects[:size]
+			res.NextToken = res.Objects[size-1].Key
+		}
+		return res, nil
+	})
+}
+
+// dirEntry is the entry of directory with key of the object it holds. Key
+// of directory ends with slash.
+type dirEntry struct {
+	os.DirEntry
+	key string
+}
+
+// walk calls fn for objects of the bucket with keys starting with prefix and
+// greater than after in key order till fn returns false. If delim is set,
+// keys having delim after the prefix are reported once by their common
+// prefix. Directories which can't hold such keys are not read, so the walk
+// resumed from after does not read directories preceding it.
+func (s *Service) walk(ctx context.Context, bucket, prefix, after, delim string, fn func(oi bsw.ObjectInfo) bool) error {
+
+	if err := validateBucket(bucket); err != nil {
+		return err
+	}
+
+	root := filepath.Join(s.cfg.BasePath, bucket)
+
+	// there is no need to walk directories which can't contain the prefix.
+	start, base := root, ""
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		start = filepath.Join(root, filepath.FromSlash(prefix[:i]))
+		if !strings.HasPrefix(start, root+string(filepath.Separator)) {
+			return errors.ValidationFailed("invalid prefix").SetPairs("bucket", bucket, "prefix", prefix)
+		}
+		base = filepath.ToSlash(start[len(root)+1:]) + "/"
+	}
+
+	// commonPrefix returns the key up to delimiter following the prefix,
+	// empty string if there is no delimiter.
+	commonPrefix := func(key string) string {
+		if delim == "" || !strings.HasPrefix(key, prefix) {
+			return ""
+		}
+		i := strings.Index(key[len(prefix):], delim)
+		if i < 0 {
+			return ""
+		}
+		return key[:len(prefix)+i+len(delim)]
+	}
+
+	var (
+		last string // the last reported common prefix
+		stop bool
+	)
+
+	var visit func(dir, base string) error
+	visit = func(dir, base string) error {
+
+		des, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) && dir == start {
+				return nil
+			}
+			return err
+		}
+
+		// entries are sorted by key, not by name: "a.txt" precedes "a/b.txt".
+		es := make([]dirEntry, len(des))
+		for i, de := range des {
+			es[i] = dirEntry{DirEntry: de, key: base + de.Name()}
+			if de.IsDir() {
+				es[i].key += "/"
+			}
+		}
+		sort.Slice(es, func(i, j int) bool { return es[i].key < es[j].key })
+
+		for _, e := range es {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			cp := commonPrefix(e.key)
+
+			if e.IsDir() {
+				switch {
+				case !strings.HasPrefix(e.key, prefix) && !strings.HasPrefix(prefix, e.key):
+				case e.key <= after && !strings.HasPrefix(after, e.key):
+				case cp != "" && (cp <= after || cp == last):
+				default:
+					if err := visit(filepath.Join(dir, e.Name()), e.key); err != nil || stop {
+						return err
+					}
+				}
+				continue
+			}
+
+			if strings.HasPrefix(e.Name(), ".tmp-") || !strings.HasPrefix(e.key, prefix) || e.key <= after {
+				continue
+			}
+
+			if cp != "" {
+				if cp <= after || cp == last {
+					continue
+				}
+				last = cp
+				stop = !fn(bsw.ObjectInfo{Bucket: bucket, Key: cp, IsPrefix: true})
+			} else {
+				fi, err := e.Info()
+				if err != nil {
+					return err
+				}
+				stop = !fn(objectInfo(s.cfg.BasePath, bsw.NewObject(s, bucket, e.key), fi))
+			}
+			if stop {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	if err := visit(start, base); err != nil {
+		return errors.Catch(err).SetPairs("bucket", bucket, "prefix", prefix).StatusCode(500).Msg("walking directory failed")
+	}
+	return nil
+}
+
+// Copy copies content of src object to dst. The content is decrypted by
+// the key of src and encrypted by the key of dst.
+func (s *Service) Copy(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+
+	dp, err := objectPath(s.cfg.BasePath, dst)
+	if err != nil {
+		return err
+	}
+
+	sl, err := writeSealer(dst, s.atRest)
+	if err != nil {
+		return err
+	}
+
+	or, _, _, err := openObject(s.cfg.BasePath, src, s.atRest)
+	if err != nil {
+		return err
+	}
+	r := or.section(0, or.size)
+	defer r.Close()
+
+	rec, _ := readMeta(s.cfg.BasePath, src)
+	h := dst.Headers()
+	if !opts.ReplaceMetadata {
+		dst, h = bsw.NewObject(s, dst.Bucket(), dst.Key(), bsw.WithMetadata(rec.Metadata)), rec.Headers
+	}
+	return putFile(ctx, s.cfg.BasePath, dst, dp, r, or.size, h, sl, nil)
+}
+
+// Move renames file of src object to dst. The file is moved as is, keeping
+// encryption of src. Moving the object to itself replaces its metadata only
+// if ReplaceMetadata is set.
+func (s *Service) Move(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+
+	sp, err := objectPath(s.cfg.BasePath, src)
+	if err != nil {
+		return err
+	}
+
+	dp, err := objectPath(s.cfg.BasePath, dst)
+	if err != nil {
+		return err
+	}
+
+	if sp == dp {
+		if _, err := os.Stat(sp); err != nil {
+			if os.IsNotExist(err) {
+				return bsw.ErrNotFound.Capture().SetPairs("bucket", src.Bucket(), "key", src.Key())
+			}
+			return errors.Catch(err).Set("path", sp).StatusCode(500).Msg("reading file info failed")
+		}
+		return s.moveMeta(src, dst, dp, opts)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dp), 0755); err != nil {
+		return errors.Catch(err).Set("path", dp).StatusCode(500).Critical().Msg("creating directory failed")
+	}
+
+	if err := os.Rename(sp, dp); err != nil {
+		if os.IsNotExist(err) {
+			return bsw.ErrNotFound.Capture().SetPairs("bucket", src.Bucket(), "key", src.Key())
+		}
+		return errors.Catch(err).SetPairs("bucket", src.Bucket(), "key", src.Key(), "dstBucket", dst.Bucket(), "dstKey", dst.Key()).
+			StatusCode(500).Msg("renaming file failed")
+	}
+
+	removeEmptyDirs(filepath.Dir(sp), filepath.Join(s.cfg.BasePath, src.Bucket()))
+	return s.moveMeta(src, dst, dp, opts)
+}
+
+// moveMeta moves metadata record of src to dst which file is at dp. The
+// record of src is kept if src and dst are the same object.
+func (s *Service) moveMeta(src, dst *bsw.Object, dp string, opts bsw.CopyOptions) error {
+
+	same := src.Bucket() == dst.Bucket() && src.Key() == dst.Key()
+
+	rec, ok := readMeta(s.cfg.BasePath, src)
+	if !ok && !opts.ReplaceMetadata {
+		if same {
+			return nil
+		}
+		return removeMeta(s.cfg.BasePath, dst)
+	}
+
+	if !ok {
+		fi, err := os.Stat(dp)
+		if err != nil {
+			return errors.Catch(err).Set("path", dp).StatusCode(500).Msg("reading file info failed")
+		}
+		rec = metaRecord{
+			Size:    fi.Size(),
+			Created: time.Now().UTC(),
+			ModTime: fi.ModTime(),
+		}
+	}
+
+	if opts.ReplaceMetadata {
+		rec.Metadata = dst.Metadata()
+		rec.Headers = dst.Headers()
+		rec.Headers.ContentType = contentTypeOf(dst, rec.Headers.ContentType)
+	}
+
+	if err := writeMeta(s.cfg.BasePath, dst, &rec); err != nil {
+		return err
+	}
+	if same {
+		return nil
+	}
+	return removeMeta(s.cfg.BasePath, src)
+}
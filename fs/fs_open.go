@@ -0,0 +1,35 @@
+package fs
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+func init() {
+	bsw.Register("file", Open)
+}
+
+// Open creates Service configured by URL
+//
+//	file:///var/data
+//
+// URL and at rest keys are taken from FS_URL_KEYS, FS_URL_ACTIVE_KEY and
+// FS_AT_REST_KEY environment variables.
+func Open(ctx context.Context, u *url.URL) (bsw.BlockStorageWrapper, error) {
+
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, errors.ValidationFailed("file URL must not have host").Set("host", u.Host)
+	}
+	if u.Path == "" {
+		return nil, errors.ValidationFailed("file URL has no path")
+	}
+
+	s, err := NewFileStorageWrapper(&Config{BasePath: u.Path})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
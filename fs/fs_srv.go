@@ -1,23 +1,30 @@
 package fs
 
 import (
-	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/hex"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
 	"github.com/axkit/vatel"
 )
 
 type SignedURLDecoder interface {
-	DecodeSignedURL(encodedStr string) (*bsw.Object, error)
+	DecodeToken(encodedStr string) (*Token, error)
 }
 
 type FileSystemStorageServer struct {
 	sud SignedURLDecoder
 	cfg struct {
-		basePath string
+		basePath      string
+		maxUploadSize int64
+		atRest        cipher.AEAD
 	}
 }
 
@@ -29,65 +36,211 @@ func NewFileSystemStorage(sud SignedURLDecoder, basePath string) *FileSystemStor
 	return &s
 }
 
+// SetMaxUploadSize limits size of uploaded objects and parts. Upload is aborted
+// as soon as it goes over the limit. Put token limit is applied if it's lower.
+// Zero means no limit.
+func (s *FileSystemStorageServer) SetMaxUploadSize(n int64) *FileSystemStorageServer {
+	s.cfg.maxUploadSize = n
+	return s
+}
+
+// SetAtRestKey sets the key objects are encrypted by unless customer key
+// is given. It must be the same key the Service is configured with.
+func (s *FileSystemStorageServer) SetAtRestKey(key string) error {
+	aead, err := newGCM([]byte(key))
+	if err != nil {
+		return errors.Catch(err).Critical().StatusCode(500).Msg("invalid at rest key")
+	}
+	s.cfg.atRest = aead
+	return nil
+}
+
+// uploadLimit returns the lowest of server and token limits.
+func (s *FileSystemStorageServer) uploadLimit(l *PutLimits) int64 {
+	res := s.cfg.maxUploadSize
+	if l != nil && l.MaxSize > 0 && (res <= 0 || l.MaxSize < res) {
+		res = l.MaxSize
+	}
+	return res
+}
+
 func (s *FileSystemStorageServer) Endpoints() []vatel.Endpoint {
 	return []vatel.Endpoint{
 		{
 			Method: "POST",
 			Path:   "/api/v1/bos/upload",
 			Controller: func() vatel.Handler {
-				return &UploadHandler{d: s.sud}
+				return &UploadHandler{d: s.sud, s: s}
 			},
 		},
+		{
+			Method:     "PUT",
+			Path:       "/api/v1/bos/upload",
+			Controller: func() vatel.Handler { return &UploadHandler{d: s.sud, s: s} },
+		},
+		{
+			Method:     "POST",
+			Path:       "/api/v1/bos/upload-part",
+			Controller: func() vatel.Handler { return &UploadPartHandler{d: s.sud, s: s} },
+		},
+		{
+			Method:     "PUT",
+			Path:       "/api/v1/bos/upload-part",
+			Controller: func() vatel.Handler { return &UploadPartHandler{d: s.sud, s: s} },
+		},
+		{
+			Method:     "POST",
+			Path:       PostPolicyPath,
+			Controller: func() vatel.Handler { return &PostPolicyHandler{d: s.sud, s: s} },
+		},
 		{
 			Method:     "GET",
 			Path:       "/api/v1/bos/download",
-			Controller: func() vatel.Handler { return &DownloadHandler{d: s.sud} },
+			Controller: func() vatel.Handler { return &DownloadHandler{d: s.sud, s: s} },
+		},
+		{
+			Method:     "DELETE",
+			Path:       "/api/v1/bos/delete",
+			Controller: func() vatel.Handler { return &DeleteHandler{d: s.sud, s: s} },
 		},
 	}
 }
 
-func (s *FileSystemStorageServer) WriteObject(o *bsw.Object, buf *bytes.Buffer) error {
+// WriteObject streams content of r to a temporary file and renames it into
+// the object's file if the content satisfies limits. Size is -1 if unknown.
+// Metadata record of the object is stored with the object's headers,
+// contentType is used if the object has no content type set. Content is
+// encrypted the same way Service.Put does.
+func (s *FileSystemStorageServer) WriteObject(ctx context.Context, o *bsw.Object, r io.Reader, size int64, contentType string, l *PutLimits) error {
 
-	dir := filepath.Join(s.cfg.basePath, o.Bucket())
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	fp, err := objectPath(s.cfg.basePath, o)
+	if err != nil {
 		return err
 	}
 
-	fp := filepath.Join(s.cfg.basePath, o.Bucket(), o.Key())
-
-	dFile, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	lr, err := limitReader(r, size, s.uploadLimit(l))
 	if err != nil {
 		return err
 	}
-	defer dFile.Close()
+	if lr != nil {
+		r = lr
+	}
 
-	_, err = buf.WriteTo(dFile)
+	sl, err := writeSealer(o, s.cfg.atRest)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	h := o.Headers()
+	if h.ContentType == "" {
+		h.ContentType = contentType
+	}
+
+	var check func(sum []byte) error
+	if l != nil {
+		check = l.CheckSum
+	}
+
+	err = putFile(ctx, s.cfg.basePath, o, fp, r, size, h, sl, check)
+	if err := lr.exceeded(); err != nil {
+		return err
+	}
+	return err
 }
 
 func (s *FileSystemStorageServer) ReadObjectTo(o *bsw.Object, w io.Writer) error {
 
-	dir := filepath.Join(s.cfg.basePath, o.Bucket())
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	or, _, _, err := openObject(s.cfg.basePath, o, s.cfg.atRest)
+	if err != nil {
 		return err
 	}
 
-	fp := filepath.Join(s.cfg.basePath, o.Bucket(), o.Key())
+	r := or.section(0, or.size)
+	defer r.Close()
 
-	dFile, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	_, err = io.Copy(w, r)
 	if err != nil {
 		return err
 	}
-	defer dFile.Close()
 
-	_, err = io.Copy(w, dFile)
+	return nil
+}
+
+// DeleteObject removes the object's file. Removing not existing file is not an error.
+func (s *FileSystemStorageServer) DeleteObject(o *bsw.Object) error {
+
+	fp, err := objectPath(s.cfg.basePath, o)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	removeEmptyDirs(filepath.Dir(fp), filepath.Join(s.cfg.basePath, o.Bucket()))
+	return removeMeta(s.cfg.basePath, o)
+}
+
+// WritePart writes content of the part to the directory of multipart upload.
+// The part is encrypted by customer key of the token's object if the upload
+// was started with customer key, by at rest key if the upload was started by
+// the Service configured with one. Returns ETag of the part.
+func (s *FileSystemStorageServer) WritePart(ctx context.Context, t *Token, r io.Reader, size int64) (string, error) {
+
+	if t.PartNumber < 1 || t.PartNumber > maxParts {
+		return "", errors.ValidationFailed("invalid part number").Set("part", t.PartNumber)
+	}
+
+	dir, ur, err := readUpload(s.cfg.basePath, t.Object, t.UploadID)
+	if err != nil {
+		return "", err
+	}
+
+	sl, err := uploadSealer(&ur, t.Object, s.cfg.atRest)
+	if err != nil {
+		return "", err
+	}
+
+	lr, err := limitReader(r, size, s.uploadLimit(nil))
+	if err != nil {
+		return "", err
+	}
+	if lr != nil {
+		r = lr
+	}
+
+	// ETag is MD5 of the plain content, it's stored after the part file is
+	// renamed into place. Part without ETag file is not listed, so stale ETag
+	// of the previous content is removed first.
+	h := md5.New()
+	r = io.TeeReader(r, h)
+
+	if sl != nil {
+		if r, err = sl.newSealReader(r); err != nil {
+			return "", err
+		}
+		if size >= 0 {
+			size = sealedSize(size)
+		}
+	}
+
+	fp := partFile(dir, t.PartNumber)
+	if err := os.Remove(partETagFile(fp)); err != nil && !os.IsNotExist(err) {
+		return "", errors.Catch(err).Set("path", fp).StatusCode(500).Critical().Msg("removing part etag failed")
+	}
+
+	err = writeFile(ctx, fp, r, size)
+	if err := lr.exceeded(); err != nil {
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	etag := hex.EncodeToString(h.Sum(nil))
+	if err := writeFile(ctx, partETagFile(fp), strings.NewReader(etag), int64(len(etag))); err != nil {
+		return "", err
+	}
+	return etag, nil
 }
@@ -1,17 +1,1409 @@
 package fs_test
 
-// func TestMain(m *testing.M) {
-// 	cfg := fs.Config{}
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
 
-// 	s := fs.NewFileSystemStorage(&cfg)
-// 	if err := s.Init(context.Background()); err != nil {
-// 		fmt.Println("fs init error:", err)
-// 		os.Exit(1)
-// 	}
+	"github.com/axkit/bsw"
+	"github.com/axkit/bsw/fs"
+	"github.com/axkit/errors"
+	"github.com/axkit/vatel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
 
-// 	os.Exit(m.Run())
-// }
+func newService(t *testing.T) *fs.Service {
+	t.Helper()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         t.TempDir(),
+	})
+	require.NoError(t, err)
+	return s
+}
 
-// func TestService_UploadFile(t *testing.T) {
+func TestService_PutGet(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "docs", "reports/2024/report.txt")
 
-// }
+	require.NoError(t, o.Put(ctx, strings.NewReader("hello world"), 11))
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	buf, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(buf))
+	assert.Equal(t, int64(11), oi.Size)
+	assert.Equal(t, "text/plain; charset=utf-8", oi.ContentType)
+	assert.NotEmpty(t, oi.ETag)
+}
+
+func TestService_PutSizeMismatch(t *testing.T) {
+	s := newService(t)
+	o := bsw.NewObject(s, "docs", "a.bin")
+
+	err := o.Put(context.Background(), bytes.NewReader([]byte{1, 2, 3}), 4)
+	assert.Error(t, err)
+
+	_, _, err = o.Get(context.Background())
+	assert.Error(t, err)
+}
+
+func TestService_PutPathTraversal(t *testing.T) {
+	s := newService(t)
+	o := bsw.NewObject(s, "docs", "../../etc/passwd")
+
+	err := o.Put(context.Background(), strings.NewReader("x"), -1)
+	assert.Error(t, err)
+}
+
+func TestService_PutCancelled(t *testing.T) {
+	s := newService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := bsw.NewObject(s, "docs", "a.txt").Put(ctx, strings.NewReader("x"), -1)
+	assert.Error(t, err)
+}
+
+func TestService_Delete(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a/1.txt", "a/2.txt", "a/b/3.txt", "c.txt"} {
+		require.NoError(t, bsw.NewObject(s, "tmp", key).Put(ctx, strings.NewReader(key), -1))
+	}
+
+	require.NoError(t, bsw.NewObject(s, "tmp", "c.txt").Delete(ctx))
+	require.NoError(t, bsw.NewObject(s, "tmp", "c.txt").Delete(ctx), "deleting missing object")
+
+	failed, err := s.DeleteMany(ctx, "tmp", []string{"a/1.txt", "../x"})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "../x", failed[0].Key)
+
+	failed, err = s.DeletePrefix(ctx, "tmp", "a/")
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+
+	_, _, err = bsw.NewObject(s, "tmp", "a/b/3.txt").Get(ctx)
+	assert.Error(t, err)
+
+	_, err = s.DeletePrefix(ctx, "tmp", "")
+	assert.Error(t, err)
+}
+
+func TestService_Stat(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "img", "logo.png")
+
+	_, err := o.Stat(ctx)
+	assert.True(t, errors.Is(err, bsw.ErrNotFound))
+
+	require.NoError(t, o.Put(ctx, strings.NewReader("png"), 3))
+
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), oi.Size)
+	assert.Equal(t, "image/png", oi.ContentType)
+	assert.False(t, oi.LastModified.IsZero())
+}
+
+func TestService_List(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a/1.txt", "a/2.txt", "a/b/3.txt", "a-b.txt", "c.txt"} {
+		require.NoError(t, bsw.NewObject(s, "tmp", key).Put(ctx, strings.NewReader(key), -1))
+	}
+
+	keys := func(it *bsw.ListIterator) []string {
+		var res []string
+		for it.Next() {
+			res = append(res, it.Info().Key)
+		}
+		require.NoError(t, it.Err())
+		return res
+	}
+
+	assert.Equal(t, []string{"a-b.txt", "a/1.txt", "a/2.txt", "a/b/3.txt", "c.txt"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{PageSize: 2})))
+
+	assert.Equal(t, []string{"a-b.txt", "a/", "c.txt"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{Delimiter: "/"})))
+
+	// pages resume after common prefix.
+	assert.Equal(t, []string{"a-b.txt", "a/", "c.txt"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{Delimiter: "/", PageSize: 1})))
+
+	assert.Equal(t, []string{"a/2.txt", "a/b/"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{Prefix: "a/", Delimiter: "/", StartAfter: "a/1.txt", PageSize: 1})))
+
+	assert.Equal(t, []string{"a/1.txt", "a/2.txt", "a/b/"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{Prefix: "a/", Delimiter: "/"})))
+
+	assert.Equal(t, []string{"a/2.txt", "a/b/3.txt", "c.txt"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{StartAfter: "a/1.txt"})))
+
+	assert.Equal(t, []string{"a/b/3.txt"},
+		keys(s.List(ctx, "tmp", bsw.ListOptions{Prefix: "a/b"})))
+
+	assert.Empty(t, keys(s.List(ctx, "missing", bsw.ListOptions{})))
+}
+
+func TestService_ListOutsideBucket(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "data")
+	require.NoError(t, os.Mkdir(base, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "outside.txt"), []byte("secret"), 0644))
+
+	s, err := fs.NewFileStorageWrapper(&fs.Config{URLEncryptionKey: "0123456789abcdef0123456789abcdef", BasePath: base})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, bsw.NewObject(s, "tmp2", "x/secret.txt").Put(ctx, strings.NewReader("secret"), -1))
+
+	for _, bucket := range []string{"..", ".", "", "a/..", `a\b`} {
+		it := s.List(ctx, bucket, bsw.ListOptions{})
+		assert.False(t, it.Next(), bucket)
+		assert.Error(t, it.Err(), bucket)
+
+		_, err := s.DeletePrefix(ctx, bucket, "x")
+		assert.Error(t, err, bucket)
+	}
+
+	// prefix resolving to a sibling bucket with the same name prefix.
+	it := s.List(ctx, "tmp", bsw.ListOptions{Prefix: "../tmp2/x/"})
+	assert.False(t, it.Next())
+	assert.Error(t, it.Err())
+
+	_, err = os.Stat(filepath.Join(dir, "outside.txt"))
+	assert.NoError(t, err)
+}
+
+func TestService_ListResume(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"1", "2", "3", "4", "5"} {
+		require.NoError(t, bsw.NewObject(s, "tmp", key).Put(ctx, strings.NewReader(key), -1))
+	}
+
+	it := s.List(ctx, "tmp", bsw.ListOptions{PageSize: 2})
+	for i := 0; i < 3; i++ {
+		require.True(t, it.Next())
+	}
+	assert.Equal(t, "3", it.Info().Key)
+
+	it = s.List(ctx, "tmp", bsw.ListOptions{PageSize: 2, ContinuationToken: it.ContinuationToken()})
+	var res []string
+	for it.Next() {
+		res = append(res, it.Info().Key)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"4", "5"}, res)
+	assert.Empty(t, it.ContinuationToken())
+}
+
+func TestCopyMove(t *testing.T) {
+	s, other := newService(t), newService(t)
+	ctx := context.Background()
+
+	src := bsw.NewObject(s, "staging", "upload/a.txt")
+	require.NoError(t, src.Put(ctx, strings.NewReader("content"), -1))
+
+	require.NoError(t, bsw.Copy(ctx, src, bsw.NewObject(s, "permanent", "a.txt"), bsw.CopyOptions{}))
+	require.NoError(t, bsw.Copy(ctx, src, bsw.NewObject(other, "permanent", "a.txt"), bsw.CopyOptions{}))
+	require.NoError(t, bsw.Move(ctx, src, bsw.NewObject(s, "permanent", "b.txt"), bsw.CopyOptions{}))
+
+	_, err := src.Stat(ctx)
+	assert.True(t, errors.Is(err, bsw.ErrNotFound))
+
+	for _, o := range []*bsw.Object{
+		bsw.NewObject(s, "permanent", "a.txt"),
+		bsw.NewObject(s, "permanent", "b.txt"),
+		bsw.NewObject(other, "permanent", "a.txt"),
+	} {
+		rc, _, err := o.Get(ctx)
+		require.NoError(t, err)
+		buf, _ := io.ReadAll(rc)
+		rc.Close()
+		assert.Equal(t, "content", string(buf))
+	}
+
+	src = bsw.NewObject(other, "permanent", "a.txt")
+	require.NoError(t, bsw.Move(ctx, src, bsw.NewObject(s, "permanent", "c.txt"), bsw.CopyOptions{}))
+	_, err = src.Stat(ctx)
+	assert.True(t, errors.Is(err, bsw.ErrNotFound))
+
+	// moving the object to itself keeps its metadata record.
+	o := bsw.NewObject(s, "permanent", "d.bin", bsw.WithContentType("application/pdf")).SetMetadata("owner", "1")
+	require.NoError(t, o.Put(ctx, strings.NewReader("content"), -1))
+	require.NoError(t, bsw.Move(ctx, o, bsw.NewObject(s, "permanent", "d.bin"), bsw.CopyOptions{}))
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "application/pdf", oi.ContentType)
+	assert.Equal(t, "1", *oi.Metadata["owner"])
+
+	dst := bsw.NewObject(s, "permanent", "d.bin").SetMetadata("owner", "2")
+	require.NoError(t, bsw.Move(ctx, o, dst, bsw.CopyOptions{ReplaceMetadata: true}))
+	oi, err = o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "2", *oi.Metadata["owner"])
+
+	err = bsw.Move(ctx, src, bsw.NewObject(other, "permanent", "a.txt"), bsw.CopyOptions{})
+	assert.True(t, errors.Is(err, bsw.ErrNotFound))
+
+	// headers are kept by cross-backend copy unless set for dst.
+	h := bsw.Headers{
+		ContentType:        "application/pdf",
+		ContentDisposition: `attachment; filename="e.pdf"`,
+		CacheControl:       "max-age=600",
+		ContentEncoding:    "identity",
+		ContentLanguage:    "en",
+	}
+	src = bsw.NewObject(s, "staging", "e.bin", bsw.WithHeaders(h))
+	require.NoError(t, src.Put(ctx, strings.NewReader("content"), -1))
+
+	require.NoError(t, bsw.Copy(ctx, src, bsw.NewObject(other, "permanent", "e.bin", bsw.WithCacheControl("no-cache")), bsw.CopyOptions{}))
+	oi, err = bsw.NewObject(other, "permanent", "e.bin").Stat(ctx)
+	require.NoError(t, err)
+	want := h
+	want.CacheControl = "no-cache"
+	assert.Equal(t, want, oi.Headers())
+
+	require.NoError(t, bsw.Copy(ctx, src, bsw.NewObject(other, "permanent", "f.bin", bsw.WithCacheControl("no-cache")),
+		bsw.CopyOptions{ReplaceMetadata: true}))
+	oi, err = bsw.NewObject(other, "permanent", "f.bin").Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, bsw.Headers{ContentType: "application/pdf", CacheControl: "no-cache"}, oi.Headers())
+}
+
+func TestService_Multipart(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "video", "movie.mp4", bsw.WithMultiParts(3))
+
+	tokens, uploadID, err := o.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+	require.Len(t, tokens, 3)
+
+	mus, err := s.ListMultipartUploads(ctx, "video", "")
+	require.NoError(t, err)
+	require.Len(t, mus, 1)
+	assert.Equal(t, uploadID, mus[0].UploadID)
+
+	// parts are uploaded in random order.
+	for _, i := range []int{2, 0, 1} {
+		tok, err := s.DecodeToken(tokens[i])
+		require.NoError(t, err)
+		assert.Equal(t, int64(i+1), tok.PartNumber)
+
+		etag, err := srv.WritePart(ctx, tok, strings.NewReader(strings.Repeat(string(rune('a'+i)), 3)), 3)
+		require.NoError(t, err)
+		assert.NotEmpty(t, etag)
+	}
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 3)
+
+	bad := []bsw.Part{parts[0], {PartNumber: 2, ETag: "wrong"}, parts[2]}
+	assert.Error(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(bad)))
+
+	require.NoError(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(parts)))
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "aaabbbccc", string(buf))
+
+	sum := sha256.Sum256(buf)
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), oi.Checksum)
+
+	mus, err = s.ListMultipartUploads(ctx, "video", "")
+	require.NoError(t, err)
+	assert.Empty(t, mus)
+}
+
+func TestService_MultipartPartReupload(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "video", "movie.mp4", bsw.WithMultiParts(1))
+
+	tokens, uploadID, err := o.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(tokens[0])
+	require.NoError(t, err)
+
+	etag, err := srv.WritePart(ctx, tok, strings.NewReader("aaa"), 3)
+	require.NoError(t, err)
+	sum := md5.Sum([]byte("aaa"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), etag)
+	stale, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+
+	// the same size within mtime granularity must change the ETag.
+	etag, err = srv.WritePart(ctx, tok, strings.NewReader("zzz"), 3)
+	require.NoError(t, err)
+	assert.NotEqual(t, stale[0].ETag, etag)
+
+	assert.Error(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(stale)))
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+	assert.Equal(t, etag, parts[0].ETag)
+	require.NoError(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(parts)))
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "zzz", string(buf))
+}
+
+func TestService_MultipartPartRenameFailed(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "video", "movie.mp4", bsw.WithMultiParts(1))
+
+	tokens, uploadID, err := o.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(tokens[0])
+	require.NoError(t, err)
+
+	_, err = srv.WritePart(ctx, tok, strings.NewReader("aaa"), 3)
+	require.NoError(t, err)
+
+	// non empty directory in place of the part file fails the rename.
+	fp := filepath.Join(basePath, ".multipart", uploadID, "1")
+	require.NoError(t, os.Remove(fp))
+	require.NoError(t, os.MkdirAll(filepath.Join(fp, "x"), 0755))
+
+	_, err = srv.WritePart(ctx, tok, strings.NewReader("zzz"), 3)
+	require.Error(t, err)
+	_, err = os.Stat(fp + ".etag")
+	assert.True(t, os.IsNotExist(err), "orphan etag file")
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	assert.Empty(t, parts)
+}
+
+func TestService_MultipartAbort(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "video", "movie.mp4", bsw.WithMultiParts(2))
+
+	_, uploadID, err := o.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+
+	_, err = s.ListParts(ctx, bsw.NewObject(s, "video", "other.mp4"), uploadID)
+	assert.True(t, errors.Is(err, bsw.ErrNotFound))
+
+	aborted, err := bsw.SweepMultipartUploads(ctx, s, "video", "", time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, aborted)
+
+	aborted, err = bsw.SweepMultipartUploads(ctx, s, "video", "", -time.Second)
+	require.NoError(t, err)
+	assert.Len(t, aborted, 1)
+
+	assert.True(t, errors.Is(s.AbortMultipartUpload(ctx, o, uploadID), bsw.ErrNotFound))
+}
+
+func TestService_DecodeToken(t *testing.T) {
+	s := newService(t)
+	o := bsw.NewObject(s, "docs", "a:b/c.txt")
+
+	t1, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	t2, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, t1, t2, "tokens must use random nonce")
+
+	tok, err := s.DecodeToken(t1)
+	require.NoError(t, err)
+	assert.Equal(t, "docs", tok.Object.Bucket())
+	assert.Equal(t, "a:b/c.txt", tok.Object.Key())
+	assert.True(t, tok.Object.StillValid())
+
+	buf, err := base64.RawURLEncoding.DecodeString(t1)
+	require.NoError(t, err)
+
+	tampered := append([]byte{}, buf...)
+	tampered[len(tampered)/2] ^= 1
+	_, err = s.DecodeToken(base64.RawURLEncoding.EncodeToString(tampered))
+	assert.True(t, errors.Is(err, fs.ErrInvalidToken))
+
+	unknown := append([]byte{}, buf...)
+	unknown[0] = 99
+	_, err = s.DecodeToken(base64.RawURLEncoding.EncodeToString(unknown))
+	assert.True(t, errors.Is(err, fs.ErrUnsupportedTokenVersion))
+
+	_, err = s.DecodeToken("not a token")
+	assert.True(t, errors.Is(err, fs.ErrInvalidToken))
+
+	other, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "fedcba9876543210fedcba9876543210",
+		BasePath:         t.TempDir(),
+	})
+	require.NoError(t, err)
+	_, err = other.DecodeToken(t1)
+	assert.True(t, errors.Is(err, fs.ErrInvalidToken))
+}
+
+func TestToken_Allow(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "docs", "a.txt")
+
+	get, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	put, err := s.PreSignPutObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	del, err := s.PreSignDeleteObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	expired, err := s.PreSignGetObjectURL(o, -time.Hour)
+	require.NoError(t, err)
+
+	tok, err := s.DecodeToken(get)
+	require.NoError(t, err)
+	assert.NoError(t, tok.Allow(fs.OpGet))
+	assert.True(t, errors.Is(tok.Allow(fs.OpPut), fs.ErrTokenOperation))
+
+	tok, err = s.DecodeToken(put)
+	require.NoError(t, err)
+	assert.NoError(t, tok.Allow(fs.OpPut))
+	assert.True(t, errors.Is(tok.Allow(fs.OpGet), fs.ErrTokenOperation))
+
+	tok, err = s.DecodeToken(del)
+	require.NoError(t, err)
+	assert.NoError(t, tok.Allow(fs.OpDelete))
+
+	tok, err = s.DecodeToken(expired)
+	require.NoError(t, err)
+	assert.Error(t, tok.Allow(fs.OpGet))
+
+	limited, err := s.PreSignPutObjectURLWithLimits(ctx, o, time.Hour, fs.PutLimits{
+		MaxSize:      5,
+		ContentTypes: []string{"text/plain", "image/*"},
+		SHA256:       "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824",
+	})
+	require.NoError(t, err)
+
+	tok, err = s.DecodeToken(limited)
+	require.NoError(t, err)
+	assert.NoError(t, tok.Allow(fs.OpPut))
+
+	l := tok.Limits
+	assert.NoError(t, l.CheckSize(5))
+	assert.True(t, errors.Is(l.CheckSize(6), fs.ErrUploadLimit))
+
+	assert.NoError(t, l.CheckContentType("text/plain; charset=utf-8"))
+	assert.NoError(t, l.CheckContentType("image/png"))
+	assert.True(t, errors.Is(l.CheckContentType("application/pdf"), fs.ErrUploadLimit))
+	assert.True(t, errors.Is(l.CheckContentType(""), fs.ErrUploadLimit))
+
+	sum := sha256.Sum256([]byte("hello"))
+	assert.NoError(t, l.CheckSum(sum[:]))
+	sum = sha256.Sum256([]byte("hallo"))
+	assert.True(t, errors.Is(l.CheckSum(sum[:]), fs.ErrUploadLimit))
+}
+
+func TestService_KeyRotation(t *testing.T) {
+	basePath := t.TempDir()
+	k1 := fs.URLKey{ID: "2024q1", Key: "0123456789abcdef0123456789abcdef"}
+	k2 := fs.URLKey{ID: "2024q2", Key: "fedcba9876543210fedcba9876543210"}
+
+	old, err := fs.NewFileStorageWrapper(&fs.Config{URLKeys: []fs.URLKey{k1}, ActiveURLKeyID: k1.ID, BasePath: basePath})
+	require.NoError(t, err)
+
+	token, err := old.PreSignGetObjectURL(bsw.NewObject(old, "docs", "a.txt"), time.Hour)
+	require.NoError(t, err)
+
+	rotated, err := fs.NewFileStorageWrapper(&fs.Config{URLKeys: []fs.URLKey{k1, k2}, ActiveURLKeyID: k2.ID, BasePath: basePath})
+	require.NoError(t, err)
+
+	tok, err := rotated.DecodeToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", tok.Object.Key())
+
+	token, err = rotated.PreSignGetObjectURL(bsw.NewObject(rotated, "docs", "b.txt"), time.Hour)
+	require.NoError(t, err)
+	_, err = old.DecodeToken(token)
+	assert.True(t, errors.Is(err, fs.ErrUnknownTokenKey))
+
+	retired, err := fs.NewFileStorageWrapper(&fs.Config{URLKeys: []fs.URLKey{k2}, ActiveURLKeyID: k2.ID, BasePath: basePath})
+	require.NoError(t, err)
+	_, err = retired.DecodeToken(token)
+	assert.NoError(t, err)
+
+	token, err = old.PreSignGetObjectURL(bsw.NewObject(old, "docs", "a.txt"), time.Hour)
+	require.NoError(t, err)
+	_, err = retired.DecodeToken(token)
+	assert.True(t, errors.Is(err, fs.ErrUnknownTokenKey))
+
+	_, err = fs.NewFileStorageWrapper(&fs.Config{URLKeys: []fs.URLKey{k1}, ActiveURLKeyID: k2.ID, BasePath: basePath})
+	assert.Error(t, err)
+
+	_, err = fs.NewFileStorageWrapper(&fs.Config{URLKeys: []fs.URLKey{k1, k1}, ActiveURLKeyID: k1.ID, BasePath: basePath})
+	assert.Error(t, err)
+}
+
+func TestService_KeyRotationEnv(t *testing.T) {
+	basePath := t.TempDir()
+
+	old, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLKeys:        []fs.URLKey{{ID: "k1", Key: "0123456789abcdef0123456789abcdef"}},
+		ActiveURLKeyID: "k1",
+		BasePath:       basePath,
+	})
+	require.NoError(t, err)
+
+	token, err := old.PreSignGetObjectURL(bsw.NewObject(old, "docs", "a.txt"), time.Hour)
+	require.NoError(t, err)
+
+	t.Setenv("FS_URL_KEYS", "k1:0123456789abcdef0123456789abcdef, k2:fedcba9876543210fedcba9876543210")
+	t.Setenv("FS_URL_ACTIVE_KEY", "k2")
+
+	s, err := fs.NewFileStorageWrapper(&fs.Config{BasePath: basePath})
+	require.NoError(t, err)
+
+	_, err = s.DecodeToken(token)
+	assert.NoError(t, err)
+
+	token, err = s.PreSignGetObjectURL(bsw.NewObject(s, "docs", "a.txt"), time.Hour)
+	require.NoError(t, err)
+	_, err = old.DecodeToken(token)
+	assert.True(t, errors.Is(err, fs.ErrUnknownTokenKey))
+}
+
+func TestFileSystemStorageServer_WriteObject(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath).SetMaxUploadSize(8)
+	ctx := context.Background()
+	o := bsw.NewObject(s, "docs", "a.txt")
+
+	sum := sha256.Sum256([]byte("hello"))
+	l := fs.PutLimits{SHA256: hex.EncodeToString(sum[:])}
+
+	err = srv.WriteObject(ctx, o, strings.NewReader("hallo"), -1, "text/plain", &l)
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+	_, err = o.Stat(ctx)
+	assert.True(t, errors.Is(err, bsw.ErrNotFound), "file must not be created")
+
+	require.NoError(t, srv.WriteObject(ctx, o, strings.NewReader("hello"), -1, "text/plain", &l))
+
+	// server limit, size known in advance.
+	err = srv.WriteObject(ctx, o, strings.NewReader("too large content"), 17, "text/plain", &fs.PutLimits{})
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	// token limit lower than server one, size unknown.
+	err = srv.WriteObject(ctx, o, strings.NewReader("0123456"), -1, "text/plain", &fs.PutLimits{MaxSize: 4})
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "hello", string(buf), "failed uploads must not replace the file")
+
+	entries, err := os.ReadDir(filepath.Join(basePath, "docs"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files must be removed")
+
+	// no token limits, server ones apply only.
+	require.NoError(t, srv.WriteObject(ctx, o, strings.NewReader("b"), 1, "text/plain", nil))
+	err = srv.WriteObject(ctx, o, strings.NewReader("too large content"), -1, "text/plain", nil)
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	rc, _, err = o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "b", string(buf))
+}
+
+func TestFileSystemStorageServer_UploadPut(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+
+	handlers := map[string]func() vatel.Handler{}
+	for _, ep := range srv.Endpoints() {
+		if ep.Method == "PUT" {
+			handlers[ep.Path] = ep.Controller
+		}
+	}
+
+	// request body is buffered as fasthttp does with StreamRequestBody disabled.
+	put := func(path, token, content string) (*fasthttp.Response, error) {
+		var req fasthttp.Request
+		req.Header.SetMethod("PUT")
+		req.SetRequestURI(path + "?dest=" + url.QueryEscape(token))
+		req.Header.SetContentType("text/plain")
+		req.SetBody([]byte(content))
+
+		var fctx fasthttp.RequestCtx
+		fctx.Init(&req, nil, nil)
+		err := handlers[path]().Handle(vatel.NewContext(&fctx))
+		return &fctx.Response, err
+	}
+
+	o := bsw.NewObject(s, "docs", "a.txt")
+	token, err := o.UploadURL(time.Hour)
+	require.NoError(t, err)
+
+	_, err = put("/api/v1/bos/upload", token, "hello")
+	require.NoError(t, err)
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "hello", string(buf))
+	assert.Equal(t, "text/plain", oi.ContentType)
+
+	mo := bsw.NewObject(s, "docs", "b.txt", bsw.WithMultiParts(1))
+	tokens, uploadID, err := mo.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+
+	resp, err := put("/api/v1/bos/upload-part", tokens[0], "part")
+	require.NoError(t, err)
+	sum := md5.Sum([]byte("part"))
+	assert.Equal(t, `"`+hex.EncodeToString(sum[:])+`"`, string(resp.Header.Peek("ETag")))
+
+	parts, err := s.ListParts(ctx, mo, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+	assert.Equal(t, int64(4), parts[0].Size)
+}
+
+// countingReader counts bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestFileSystemStorageServer_UploadPostStreamed(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath).SetMaxUploadSize(1024)
+	ctx := context.Background()
+
+	var handler func() vatel.Handler
+	for _, ep := range srv.Endpoints() {
+		if ep.Method == "POST" && ep.Path == "/api/v1/bos/upload" {
+			handler = ep.Controller
+		}
+	}
+	require.NotNil(t, handler)
+
+	form := func(content []byte) ([]byte, string) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		require.NoError(t, mw.WriteField("comment", "skipped"))
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="file"; filename="a.txt"`)
+		h.Set("Content-Type", "text/plain")
+		w, err := mw.CreatePart(h)
+		require.NoError(t, err)
+		_, _ = w.Write(content)
+		require.NoError(t, mw.Close())
+		return buf.Bytes(), mw.FormDataContentType()
+	}
+
+	// body is streamed as fasthttp does with StreamRequestBody enabled.
+	post := func(token string, body []byte, contentType string, streamed bool) (*countingReader, error) {
+		var req fasthttp.Request
+		req.Header.SetMethod("POST")
+		req.SetRequestURI("/api/v1/bos/upload?dest=" + url.QueryEscape(token))
+		req.Header.SetContentType(contentType)
+
+		var fctx fasthttp.RequestCtx
+		fctx.Init(&req, nil, nil)
+		cr := &countingReader{r: bytes.NewReader(body)}
+		if streamed {
+			fctx.Request.SetBodyStream(cr, len(body))
+		} else {
+			fctx.Request.SetBody(body)
+		}
+		return cr, handler().Handle(vatel.NewContext(&fctx))
+	}
+
+	o := bsw.NewObject(s, "docs", "a.txt")
+	token, err := o.UploadURL(time.Hour)
+	require.NoError(t, err)
+
+	body, ct := form([]byte("hello"))
+	_, err = post(token, body, ct, true)
+	require.NoError(t, err)
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "hello", string(buf))
+	assert.Equal(t, "text/plain", oi.ContentType)
+
+	body, ct = form([]byte("buffered"))
+	_, err = post(token, body, ct, false)
+	require.NoError(t, err)
+
+	body, ct = form(bytes.Repeat([]byte("x"), 4<<20))
+	cr, err := post(token, body, ct, true)
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+	assert.Less(t, cr.n, len(body)/16, "upload must be rejected before the whole body is read")
+
+	entries, err := os.ReadDir(filepath.Join(basePath, "docs"))
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.HasPrefix(e.Name(), ".tmp-"), "temporary file must be removed")
+	}
+}
+
+func TestFileSystemStorageServer_ServeObject(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "video", "clip.txt")
+	require.NoError(t, o.Put(ctx, strings.NewReader("0123456789"), 10))
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	etag := `"` + oi.ETag + `"`
+	lastModified := oi.LastModified.UTC().Format(http.TimeFormat)
+
+	token, err := s.PreSignGetObjectURLWithOverrides(ctx, o, time.Hour, fs.ResponseOverrides{
+		ContentDisposition: `attachment; filename="clip.txt"`,
+	})
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(token)
+	require.NoError(t, err)
+
+	serve := func(method string, headers ...string) *fasthttp.Response {
+		var fctx fasthttp.RequestCtx
+		fctx.Request.Header.SetMethod(method)
+		for i := 0; i < len(headers); i += 2 {
+			fctx.Request.Header.Set(headers[i], headers[i+1])
+		}
+		require.NoError(t, srv.ServeObject(vatel.NewContext(&fctx), tok))
+		return &fctx.Response
+	}
+
+	resp := serve("GET")
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, "0123456789", string(resp.Body()))
+	assert.Equal(t, etag, string(resp.Header.Peek("ETag")))
+	assert.Equal(t, lastModified, string(resp.Header.Peek("Last-Modified")))
+	assert.Equal(t, `attachment; filename="clip.txt"`, string(resp.Header.Peek("Content-Disposition")))
+	assert.Contains(t, string(resp.Header.ContentType()), "text/plain")
+
+	resp = serve("GET", "Range", "bytes=2-4")
+	assert.Equal(t, 206, resp.StatusCode())
+	assert.Equal(t, "234", string(resp.Body()))
+	assert.Equal(t, "bytes 2-4/10", string(resp.Header.Peek("Content-Range")))
+
+	resp = serve("GET", "Range", "bytes=-3")
+	assert.Equal(t, 206, resp.StatusCode())
+	assert.Equal(t, "789", string(resp.Body()))
+
+	resp = serve("GET", "Range", "bytes=7-")
+	assert.Equal(t, "789", string(resp.Body()))
+
+	resp = serve("GET", "Range", "bytes=10-")
+	assert.Equal(t, 416, resp.StatusCode())
+	assert.Equal(t, "bytes */10", string(resp.Header.Peek("Content-Range")))
+
+	resp = serve("GET", "Range", "bytes=0-1,4-5")
+	assert.Equal(t, 200, resp.StatusCode(), "multiple ranges are ignored")
+
+	resp = serve("GET", "Range", "bytes=2-4", "If-Range", etag)
+	assert.Equal(t, 206, resp.StatusCode())
+
+	resp = serve("GET", "Range", "bytes=2-4", "If-Range", `"stale"`)
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, "0123456789", string(resp.Body()))
+
+	resp = serve("GET", "If-None-Match", `"other", `+etag)
+	assert.Equal(t, 304, resp.StatusCode())
+
+	resp = serve("GET", "If-Modified-Since", lastModified)
+	assert.Equal(t, 304, resp.StatusCode())
+
+	resp = serve("GET", "If-None-Match", `"other"`, "If-Modified-Since", lastModified)
+	assert.Equal(t, 200, resp.StatusCode(), "If-Modified-Since is ignored if If-None-Match present")
+
+	resp = serve("HEAD")
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Equal(t, 10, resp.Header.ContentLength())
+
+	missing := *tok
+	missing.Object = bsw.NewObject(s, "video", "missing.txt")
+	var fctx fasthttp.RequestCtx
+	assert.True(t, errors.Is(srv.ServeObject(vatel.NewContext(&fctx), &missing), bsw.ErrNotFound))
+}
+
+func TestService_Metadata(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "docs", "a/report.pdf").SetMetadata("filename", "Отчёт.pdf")
+	require.NoError(t, o.Put(ctx, strings.NewReader("hello"), 5))
+
+	sum := sha256.Sum256([]byte("hello"))
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "application/pdf", oi.ContentType)
+	assert.Equal(t, hex.EncodeToString(sum[:]), oi.Checksum)
+	assert.False(t, oi.Created.IsZero())
+	require.Contains(t, oi.Metadata, "filename")
+	assert.Equal(t, "Отчёт.pdf", *oi.Metadata["filename"])
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	rc.Close()
+	assert.Equal(t, "Отчёт.pdf", *oi.Metadata["filename"])
+
+	cp := bsw.NewObject(s, "docs", "b/copy.pdf")
+	require.NoError(t, bsw.Copy(ctx, o, cp, bsw.CopyOptions{}))
+	oi, err = cp.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Отчёт.pdf", *oi.Metadata["filename"])
+
+	mv := bsw.NewObject(s, "docs", "c/moved.pdf").SetMetadata("filename", "moved.pdf")
+	require.NoError(t, bsw.Move(ctx, cp, mv, bsw.CopyOptions{ReplaceMetadata: true}))
+	oi, err = mv.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "moved.pdf", *oi.Metadata["filename"])
+	assert.Equal(t, hex.EncodeToString(sum[:]), oi.Checksum)
+
+	// file replaced bypassing the service.
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "docs", "c", "moved.pdf"), []byte("replaced"), 0644))
+	oi, err = mv.Stat(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, oi.Metadata)
+	assert.Empty(t, oi.Checksum)
+
+	srv := fs.NewFileSystemStorage(s, basePath)
+	token, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(token)
+	require.NoError(t, err)
+
+	var fctx fasthttp.RequestCtx
+	require.NoError(t, srv.ServeObject(vatel.NewContext(&fctx), tok))
+	assert.Equal(t, "Отчёт.pdf", string(fctx.Response.Header.Peek("x-amz-meta-filename")))
+	assert.Equal(t, "application/pdf", string(fctx.Response.Header.ContentType()))
+
+	require.NoError(t, o.Delete(ctx))
+	require.NoError(t, mv.Delete(ctx))
+	entries, err := os.ReadDir(filepath.Join(basePath, ".metadata", "docs"))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "records of deleted objects must be removed")
+}
+
+func TestFileSystemStorageServer_WriteObjectMetadata(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "docs", "upload.bin").SetMetadata("filename", "photo.jpg")
+	token, err := o.UploadURL(time.Hour)
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(token)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.WriteObject(ctx, tok.Object, strings.NewReader("jpeg"), 4, "image/jpeg", &tok.Limits))
+
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", oi.ContentType)
+	require.Contains(t, oi.Metadata, "filename")
+	assert.Equal(t, "photo.jpg", *oi.Metadata["filename"])
+}
+
+func TestService_Headers(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "docs", "f8a1c2", bsw.WithHeaders(bsw.Headers{
+		ContentType:        "application/pdf",
+		ContentDisposition: `attachment; filename="report.pdf"`,
+		CacheControl:       "private, max-age=600",
+	}))
+	assert.Nil(t, o.UploadHeaders())
+
+	token, err := o.UploadURL(time.Hour)
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(token)
+	require.NoError(t, err)
+	require.NoError(t, srv.WriteObject(ctx, tok.Object, strings.NewReader("%PDF"), 4, "application/octet-stream", &tok.Limits))
+
+	serve := func(o *bsw.Object) *fasthttp.Response {
+		token, err := s.PreSignGetObjectURL(o, time.Hour)
+		require.NoError(t, err)
+		tok, err := s.DecodeToken(token)
+		require.NoError(t, err)
+
+		var fctx fasthttp.RequestCtx
+		require.NoError(t, srv.ServeObject(vatel.NewContext(&fctx), tok))
+		return &fctx.Response
+	}
+
+	resp := serve(bsw.NewObject(s, "docs", "f8a1c2"))
+	assert.Equal(t, "application/pdf", string(resp.Header.ContentType()))
+	assert.Equal(t, `attachment; filename="report.pdf"`, string(resp.Header.Peek("Content-Disposition")))
+	assert.Equal(t, "private, max-age=600", string(resp.Header.Peek("Cache-Control")))
+
+	resp = serve(bsw.NewObject(s, "docs", "f8a1c2", bsw.WithResponseHeaders(bsw.Headers{
+		ContentType:        "application/octet-stream",
+		ContentDisposition: `inline; filename="renamed.pdf"`,
+	})))
+	assert.Equal(t, "application/octet-stream", string(resp.Header.ContentType()))
+	assert.Equal(t, `inline; filename="renamed.pdf"`, string(resp.Header.Peek("Content-Disposition")))
+	assert.Equal(t, "private, max-age=600", string(resp.Header.Peek("Cache-Control")))
+}
+
+func TestFileSystemStorageServer_PostPolicy(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+
+	var handler func() vatel.Handler
+	for _, ep := range srv.Endpoints() {
+		if ep.Method == "POST" && ep.Path == fs.PostPolicyPath {
+			handler = ep.Controller
+		}
+	}
+	require.NotNil(t, handler)
+
+	o := bsw.NewObject(s, "avatars", "users/1/${filename}").SetMetadata("owner", "1")
+	pp, err := o.PostPolicy(bsw.PostPolicyOptions{
+		Expires:               time.Hour,
+		MinSize:               2,
+		MaxSize:               10,
+		KeyStartsWith:         "users/1/",
+		ContentTypeStartsWith: "image/",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fs.PostPolicyPath, pp.URL)
+	assert.Equal(t, "users/1/${filename}", pp.Fields["key"])
+
+	post := func(fields map[string]string, contentType, content string) (*fasthttp.Response, error) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			require.NoError(t, mw.WriteField(k, v))
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="file"; filename="a.png"`)
+		h.Set("Content-Type", contentType)
+		w, err := mw.CreatePart(h)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(content))
+		require.NoError(t, mw.Close())
+
+		var req fasthttp.Request
+		req.Header.SetMethod("POST")
+		req.Header.SetContentType(mw.FormDataContentType())
+		req.SetBody(buf.Bytes())
+
+		var fctx fasthttp.RequestCtx
+		fctx.Init(&req, nil, nil)
+		err = handler().Handle(vatel.NewContext(&fctx))
+		return &fctx.Response, err
+	}
+
+	fields := func(key string) map[string]string {
+		return map[string]string{"policy": pp.Fields["policy"], "key": key}
+	}
+
+	resp, err := post(fields("users/1/a.png"), "image/png", "png-data")
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode())
+
+	oi, err := bsw.NewObject(s, "avatars", "users/1/a.png").Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), oi.Size)
+	assert.Equal(t, "image/png", oi.ContentType)
+	assert.Equal(t, "1", *oi.Metadata["owner"])
+
+	_, err = post(fields("users/2/a.png"), "image/png", "png-data")
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	// key passes prefix check but escapes it after cleaning.
+	for _, key := range []string{"users/1/../secret.txt", "users/1/../../secret.txt", "users/1//a.png"} {
+		_, err = post(fields(key), "image/png", "png-data")
+		assert.Error(t, err, key)
+	}
+	_, err = os.Stat(filepath.Join(basePath, "avatars", "secret.txt"))
+	assert.True(t, os.IsNotExist(err), "file must not be created")
+	_, err = os.Stat(filepath.Join(basePath, "secret.txt"))
+	assert.True(t, os.IsNotExist(err), "file must not be created")
+
+	_, err = post(fields("users/1/a.pdf"), "application/pdf", "pdf-data")
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	_, err = post(fields("users/1/b.png"), "image/png", "x")
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	_, err = post(fields("users/1/b.png"), "image/png", "0123456789a")
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+
+	// oversized file is rejected before the whole body is read.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	require.NoError(t, mw.WriteField("policy", pp.Fields["policy"]))
+	require.NoError(t, mw.WriteField("key", "users/1/big.png"))
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="file"; filename="big.png"`)
+	h.Set("Content-Type", "image/png")
+	w, err := mw.CreatePart(h)
+	require.NoError(t, err)
+	_, _ = w.Write(bytes.Repeat([]byte("x"), 4<<20))
+	require.NoError(t, mw.Close())
+
+	var req fasthttp.Request
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType(mw.FormDataContentType())
+	var fctx fasthttp.RequestCtx
+	fctx.Init(&req, nil, nil)
+	cr := &countingReader{r: bytes.NewReader(buf.Bytes())}
+	fctx.Request.SetBodyStream(cr, buf.Len())
+	err = handler().Handle(vatel.NewContext(&fctx))
+	assert.True(t, errors.Is(err, fs.ErrUploadLimit))
+	assert.Less(t, cr.n, buf.Len()/16, "upload must be rejected before the whole body is read")
+	_, err = os.Stat(filepath.Join(basePath, "avatars", "users", "1", "big.png"))
+	assert.True(t, os.IsNotExist(err), "file must not be created")
+
+	put, err := s.PreSignPutObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	_, err = post(map[string]string{"policy": put, "key": "users/1/b.png"}, "image/png", "png-data")
+	assert.True(t, errors.Is(err, fs.ErrTokenOperation))
+
+	pp, err = bsw.NewObject(s, "avatars", "users/1/c.png").PostPolicy(bsw.PostPolicyOptions{
+		Expires:               time.Hour,
+		SuccessActionRedirect: "https://example.com/done?x=1",
+	})
+	require.NoError(t, err)
+	resp, err = post(map[string]string{"policy": pp.Fields["policy"]}, "image/png", "png-data")
+	require.NoError(t, err)
+	assert.Equal(t, 303, resp.StatusCode())
+	loc, err := url.Parse(string(resp.Header.Peek("Location")))
+	require.NoError(t, err)
+	assert.Equal(t, "1", loc.Query().Get("x"))
+	assert.Equal(t, "avatars", loc.Query().Get("bucket"))
+	assert.Equal(t, "users/1/c.png", loc.Query().Get("key"))
+	assert.NotEmpty(t, loc.Query().Get("etag"))
+}
+
+func TestService_AtRestEncryption(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		AtRestKey:        "fedcba9876543210fedcba9876543210",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	require.NoError(t, srv.SetAtRestKey("fedcba9876543210fedcba9876543210"))
+	ctx := context.Background()
+
+	// content spans several encrypted chunks.
+	content := strings.Repeat("0123456789abcdef", 10000)
+	o := bsw.NewObject(s, "docs", "big.txt", bsw.WithSSEManaged())
+	require.NoError(t, o.Put(ctx, strings.NewReader(content), int64(len(content))))
+
+	raw, err := os.ReadFile(filepath.Join(basePath, "docs", "big.txt"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "0123456789abcdef")
+	assert.Greater(t, len(raw), len(content))
+
+	oi, err := o.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), oi.Size)
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, content, string(buf))
+
+	token, err := s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	tok, err := s.DecodeToken(token)
+	require.NoError(t, err)
+
+	var fctx fasthttp.RequestCtx
+	fctx.Request.Header.Set("Range", "bytes=65530-65545")
+	require.NoError(t, srv.ServeObject(vatel.NewContext(&fctx), tok))
+	assert.Equal(t, 206, fctx.Response.StatusCode())
+	assert.Equal(t, content[65530:65546], string(fctx.Response.Body()))
+
+	// tampered file is detected.
+	raw[len(raw)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "docs", "big.txt"), raw, 0644))
+	rc, _, err = o.Get(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	rc.Close()
+	assert.True(t, errors.Is(err, fs.ErrCorruptedFile), "%v", err)
+}
+
+func TestService_CustomerKeyEncryption(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+	other := bytes.Repeat([]byte{2}, 32)
+
+	o := bsw.NewObject(s, "docs", "secret.txt", bsw.WithSSECustomer(key))
+	require.NoError(t, o.Put(ctx, strings.NewReader("secret"), 6))
+
+	_, _, err := bsw.NewObject(s, "docs", "secret.txt").Get(ctx)
+	assert.True(t, errors.Is(err, fs.ErrEncryptionKey))
+	_, _, err = bsw.NewObject(s, "docs", "secret.txt", bsw.WithSSECustomer(other)).Get(ctx)
+	assert.True(t, errors.Is(err, fs.ErrEncryptionKey))
+
+	rc, _, err := bsw.NewObject(s, "docs", "secret.txt", bsw.WithSSECustomer(key)).Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "secret", string(buf))
+
+	dst := bsw.NewObject(s, "docs", "copy.txt", bsw.WithSSECustomer(other))
+	require.NoError(t, bsw.Copy(ctx, o, dst, bsw.CopyOptions{}))
+	rc, _, err = dst.Get(ctx)
+	require.NoError(t, err)
+	buf, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "secret", string(buf))
+
+	h := dst.DownloadHeaders()
+	assert.Equal(t, base64.StdEncoding.EncodeToString(other), h["X-Amz-Server-Side-Encryption-Customer-Key"])
+
+	_, err = s.PreSignPostPolicy(o, bsw.PostPolicyOptions{Expires: time.Hour})
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+
+	err = bsw.NewObject(s, "docs", "managed.txt", bsw.WithSSEManaged()).Put(ctx, strings.NewReader("x"), 1)
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported), "at rest key is not configured")
+}
+
+func TestService_MultipartAtRestEncryption(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		AtRestKey:        "fedcba9876543210fedcba9876543210",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	require.NoError(t, srv.SetAtRestKey("fedcba9876543210fedcba9876543210"))
+	ctx := context.Background()
+	o := bsw.NewObject(s, "video", "movie.mp4", bsw.WithMultiParts(2))
+
+	tokens, uploadID, err := o.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+	for i, tk := range tokens {
+		tok, err := s.DecodeToken(tk)
+		require.NoError(t, err)
+		_, err = srv.WritePart(ctx, tok, strings.NewReader(strings.Repeat(string(rune('a'+i)), 3)), 3)
+		require.NoError(t, err)
+	}
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	assert.Equal(t, int64(3), parts[0].Size)
+
+	require.NoError(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(parts)))
+
+	raw, err := os.ReadFile(filepath.Join(basePath, "video", "movie.mp4"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "aaabbb")
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "aaabbb", string(buf))
+}
+
+func TestService_MultipartCustomerKey(t *testing.T) {
+	basePath := t.TempDir()
+	s, err := fs.NewFileStorageWrapper(&fs.Config{
+		URLEncryptionKey: "0123456789abcdef0123456789abcdef",
+		BasePath:         basePath,
+	})
+	require.NoError(t, err)
+	srv := fs.NewFileSystemStorage(s, basePath)
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+	other := bytes.Repeat([]byte{2}, 32)
+
+	var handler func() vatel.Handler
+	for _, ep := range srv.Endpoints() {
+		if ep.Method == "PUT" && ep.Path == "/api/v1/bos/upload-part" {
+			handler = ep.Controller
+		}
+	}
+	require.NotNil(t, handler)
+
+	put := func(token string, headers map[string]string, content string) error {
+		var req fasthttp.Request
+		req.Header.SetMethod("PUT")
+		req.SetRequestURI("/api/v1/bos/upload-part?dest=" + url.QueryEscape(token))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		req.SetBody([]byte(content))
+
+		var fctx fasthttp.RequestCtx
+		fctx.Init(&req, nil, nil)
+		return handler().Handle(vatel.NewContext(&fctx))
+	}
+
+	o := bsw.NewObject(s, "video", "movie.mp4", bsw.WithMultiParts(2), bsw.WithSSECustomer(key))
+	tokens, uploadID, err := o.MultipartUploadURLs(time.Hour)
+	require.NoError(t, err)
+
+	err = put(tokens[0], nil, "aaa")
+	assert.True(t, errors.Is(err, fs.ErrEncryptionKey), "customer key required")
+	err = put(tokens[0], bsw.NewObject(s, "video", "movie.mp4", bsw.WithSSECustomer(other)).UploadHeaders(), "aaa")
+	assert.True(t, errors.Is(err, fs.ErrEncryptionKey))
+
+	require.NoError(t, put(tokens[0], o.UploadHeaders(), "aaa"))
+	require.NoError(t, put(tokens[1], o.UploadHeaders(), "bbb"))
+
+	des, err := os.ReadDir(filepath.Join(basePath, ".multipart", uploadID))
+	require.NoError(t, err)
+	for _, de := range des {
+		raw, err := os.ReadFile(filepath.Join(basePath, ".multipart", uploadID, de.Name()))
+		require.NoError(t, err)
+		if !strings.HasSuffix(de.Name(), ".etag") && de.Name() != "upload.json" {
+			assert.NotContains(t, string(raw), "aaa", "part must be encrypted")
+		}
+	}
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	assert.Equal(t, int64(3), parts[0].Size)
+
+	plain := bsw.NewObject(s, "video", "movie.mp4")
+	assert.True(t, errors.Is(s.CompleteMultipartUpload(plain, uploadID, bsw.CompletedParts(parts)), fs.ErrEncryptionKey))
+	wrong := bsw.NewObject(s, "video", "movie.mp4", bsw.WithSSECustomer(other))
+	assert.True(t, errors.Is(s.CompleteMultipartUpload(wrong, uploadID, bsw.CompletedParts(parts)), fs.ErrEncryptionKey))
+
+	require.NoError(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(parts)))
+
+	rc, _, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "aaabbb", string(buf))
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv("FS_URL_KEYS", "k1:0123456789abcdef0123456789abcdef")
+	t.Setenv("FS_URL_ACTIVE_KEY", "k1")
+	dir := t.TempDir()
+
+	w, err := bsw.Open(context.Background(), "file://"+dir)
+	require.NoError(t, err)
+	require.NoError(t, w.Put(context.Background(), bsw.NewObject(w, "b", "a.txt"), strings.NewReader("hello"), 5))
+	_, err = os.Stat(filepath.Join(dir, "b", "a.txt"))
+	assert.NoError(t, err)
+
+	_, err = bsw.Open(context.Background(), "file://"+filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+	_, err = bsw.Open(context.Background(), "file://server/share")
+	assert.Error(t, err)
+}
@@ -0,0 +1,311 @@
+package fs
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"strings"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// tokenVersion is the version of the signed URL token format. The token is
+// base64url encoded header, random nonce and AES-GCM sealed JSON payload.
+// The header is authenticated as additional data. Version 2 header is version
+// byte, key ID length byte and key ID. Version 1 header is version byte only,
+// such tokens are verified by the key with empty ID.
+const tokenVersion byte = 2
+
+var (
+	ErrInvalidToken            = errors.New("invalid signed url token").StatusCode(403)
+	ErrUnsupportedTokenVersion = errors.New("unsupported signed url token version").StatusCode(403)
+	ErrTokenOperation          = errors.New("signed url token is not issued for the operation").StatusCode(403)
+	ErrUploadLimit             = errors.New("upload does not satisfy signed url limits").StatusCode(400)
+)
+
+// Op is the operation signed URL token is issued for.
+type Op string
+
+const (
+	OpGet    Op = "get"
+	OpPut    Op = "put"
+	OpPart   Op = "part"
+	OpDelete Op = "delete"
+	OpPost   Op = "post"
+)
+
+// PutLimits restricts content uploaded by put token. Zero values mean no limit.
+type PutLimits struct {
+	// MaxSize is the maximum size of the content in bytes.
+	MaxSize int64 `json:"s,omitempty"`
+
+	// ContentTypes lists allowed media types. Wildcard subtype like
+	// "image/*" is supported.
+	ContentTypes []string `json:"t,omitempty"`
+
+	// SHA256 is hex encoded SHA-256 checksum the content must have.
+	SHA256 string `json:"h,omitempty"`
+}
+
+// ResponseOverrides sets response headers of the download. Empty values
+// leave headers as is.
+type ResponseOverrides = bsw.Headers
+
+// tokenPayload is the content of the signed URL token.
+type tokenPayload struct {
+	Op         Op         `json:"o"`
+	Bucket     string     `json:"b"`
+	Key        string     `json:"k"`
+	Exp        int64      `json:"e"`
+	UploadID   string     `json:"u,omitempty"`
+	PartNumber int64      `json:"p,omitempty"`
+	Limits     *PutLimits `json:"l,omitempty"`
+
+	Response *ResponseOverrides `json:"r,omitempty"`
+
+	// Metadata and Headers are stored with the object uploaded by put token.
+	Metadata map[string]*string `json:"m,omitempty"`
+	Headers  *bsw.Headers       `json:"h,omitempty"`
+
+	Policy *bsw.PostPolicyOptions `json:"pp,omitempty"`
+
+	Encryption *tokenEncryption `json:"x,omitempty"`
+}
+
+// tokenEncryption is encryption of the object requested by upload token.
+// Customer key is not stored, its SHA-256 is.
+type tokenEncryption struct {
+	Mode      bsw.EncryptionMode `json:"m"`
+	KeySHA256 string             `json:"k,omitempty"`
+}
+
+// newTokenEncryption returns encryption of the object o stored in the token.
+func newTokenEncryption(o *bsw.Object) *tokenEncryption {
+	e := o.Encryption()
+	switch e.Mode {
+	case "":
+		return nil
+	case bsw.SSECustomer:
+		return &tokenEncryption{Mode: e.Mode, KeySHA256: e.CustomerKeySHA256()}
+	}
+	return &tokenEncryption{Mode: e.Mode}
+}
+
+// Token holds attributes of decoded signed URL.
+type Token struct {
+	Op     Op
+	Object *bsw.Object
+
+	// UploadID and PartNumber are set if the token is issued for
+	// uploading a part of multipart upload.
+	UploadID   string
+	PartNumber int64
+
+	// Limits is set for put tokens.
+	Limits PutLimits
+
+	// Response is set for get tokens.
+	Response ResponseOverrides
+
+	// Policy is set for post policy tokens.
+	Policy bsw.PostPolicyOptions
+
+	// CustomerKeySHA256 is set if the object must be uploaded with customer
+	// key. The key is sent by X-Amz-Server-Side-Encryption-Customer-Key header.
+	CustomerKeySHA256 string
+}
+
+// Allow checks the token is issued for op and not expired.
+func (t *Token) Allow(op Op) error {
+	if t.Op != op {
+		return ErrTokenOperation.Capture().SetPairs("op", op, "tokenOp", t.Op)
+	}
+	if !t.Object.StillValid() {
+		return errors.ValidationFailed("signed url is expired")
+	}
+	return nil
+}
+
+// CheckSize returns ErrUploadLimit if size exceeds MaxSize.
+func (l *PutLimits) CheckSize(size int64) error {
+	if l.MaxSize > 0 && size > l.MaxSize {
+		return ErrUploadLimit.Capture().SetPairs("reason", "too large", "maxSize", l.MaxSize, "size", size).StatusCode(413)
+	}
+	return nil
+}
+
+// CheckContentType returns ErrUploadLimit if contentType is not allowed.
+func (l *PutLimits) CheckContentType(contentType string) error {
+	if len(l.ContentTypes) == 0 {
+		return nil
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		for _, ct := range l.ContentTypes {
+			if ct == mt || strings.HasSuffix(ct, "/*") && strings.HasPrefix(mt, ct[:len(ct)-1]) {
+				return nil
+			}
+		}
+	}
+	return ErrUploadLimit.Capture().SetPairs("reason", "content type not allowed", "contentType", contentType).StatusCode(415)
+}
+
+// CheckSum returns ErrUploadLimit if sum, the SHA-256 of the content,
+// differs from required one.
+func (l *PutLimits) CheckSum(sum []byte) error {
+	if l.SHA256 != "" && !strings.EqualFold(l.SHA256, hex.EncodeToString(sum)) {
+		return ErrUploadLimit.Capture().SetPairs("reason", "checksum mismatch", "expected", l.SHA256)
+	}
+	return nil
+}
+
+// CheckPost returns ErrUploadLimit if the form upload of content sized size
+// and typed contentType to key does not satisfy conditions of post policy token.
+func (t *Token) CheckPost(key, contentType string, size int64) error {
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	pp := &t.Policy
+	if key != t.Object.Key() && (pp.KeyStartsWith == "" || !strings.HasPrefix(key, pp.KeyStartsWith)) {
+		return ErrUploadLimit.Capture().SetPairs("reason", "key not allowed", "key", key).StatusCode(403)
+	}
+
+	if size >= 0 && size < pp.MinSize {
+		return ErrUploadLimit.Capture().SetPairs("reason", "too small", "minSize", pp.MinSize, "size", size)
+	}
+
+	l := PutLimits{MaxSize: pp.MaxSize}
+	if err := l.CheckSize(size); err != nil {
+		return err
+	}
+
+	if (pp.ContentType != "" && contentType != pp.ContentType) ||
+		(pp.ContentTypeStartsWith != "" && !strings.HasPrefix(contentType, pp.ContentTypeStartsWith)) {
+		return ErrUploadLimit.Capture().SetPairs("reason", "content type not allowed", "contentType", contentType).StatusCode(415)
+	}
+	return nil
+}
+
+func (s *Service) encodeToken(p tokenPayload) (string, error) {
+
+	plainText, err := json.Marshal(p)
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("encoding token failed")
+	}
+
+	id, aead := s.keys.signer()
+	hl := 2 + len(id)
+
+	buf := make([]byte, hl+aead.NonceSize(), hl+aead.NonceSize()+len(plainText)+aead.Overhead())
+	buf[0] = tokenVersion
+	buf[1] = byte(len(id))
+	copy(buf[2:], id)
+	if _, err := rand.Read(buf[hl:]); err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("generating nonce failed")
+	}
+
+	buf = aead.Seal(buf, buf[hl:], plainText, buf[:hl])
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (s *Service) decodeToken(encodedStr string) (tokenPayload, error) {
+
+	var p tokenPayload
+
+	buf, err := base64.RawURLEncoding.DecodeString(encodedStr)
+	if err != nil || len(buf) == 0 {
+		return p, ErrInvalidToken.Capture().Set("reason", "malformed encoding")
+	}
+
+	// header is the part of the token authenticated as additional data.
+	var (
+		id string
+		hl int
+	)
+	switch buf[0] {
+	case 1:
+		hl = 1
+	case tokenVersion:
+		if len(buf) < 2 || len(buf) < 2+int(buf[1]) {
+			return p, ErrInvalidToken.Capture().Set("reason", "too short")
+		}
+		hl = 2 + int(buf[1])
+		id = string(buf[2:hl])
+	default:
+		return p, ErrUnsupportedTokenVersion.Capture().Set("version", buf[0])
+	}
+
+	aead, err := s.keys.verifier(id)
+	if err != nil {
+		return p, err
+	}
+
+	if len(buf) < hl+aead.NonceSize()+aead.Overhead() {
+		return p, ErrInvalidToken.Capture().Set("reason", "too short")
+	}
+
+	nonce := buf[hl : hl+aead.NonceSize()]
+	plainText, err := aead.Open(nil, nonce, buf[hl+aead.NonceSize():], buf[:hl])
+	if err != nil {
+		return p, ErrInvalidToken.Capture().Set("reason", "authentication failed")
+	}
+
+	if err := json.Unmarshal(plainText, &p); err != nil {
+		return p, ErrInvalidToken.Capture().Set("reason", "malformed payload")
+	}
+	return p, nil
+}
+
+// DecodeSignedURL returns object the signed URL is issued for.
+func (s *Service) DecodeSignedURL(encodedStr string) (*bsw.Object, error) {
+	t, err := s.DecodeToken(encodedStr)
+	if err != nil {
+		return nil, err
+	}
+	return t.Object, nil
+}
+
+// DecodeToken verifies and decodes signed URL token. Tampered tokens are
+// rejected with ErrInvalidToken, tokens of unknown format version with
+// ErrUnsupportedTokenVersion.
+func (s *Service) DecodeToken(encodedStr string) (*Token, error) {
+
+	p, err := s.decodeToken(encodedStr)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []bsw.Option{bsw.WithMetadata(p.Metadata), bsw.WithValidTill(p.Exp)}
+	if p.Headers != nil {
+		opts = append(opts, bsw.WithHeaders(*p.Headers))
+	}
+	if p.Encryption != nil && p.Encryption.Mode != bsw.SSECustomer {
+		opts = append(opts, bsw.WithEncryption(bsw.Encryption{Mode: p.Encryption.Mode}))
+	}
+
+	res := Token{
+		Op:         p.Op,
+		Object:     bsw.NewObject(s, p.Bucket, p.Key, opts...),
+		UploadID:   p.UploadID,
+		PartNumber: p.PartNumber,
+	}
+	if p.Limits != nil {
+		res.Limits = *p.Limits
+	}
+	if p.Response != nil {
+		res.Response = *p.Response
+	}
+	if p.Policy != nil {
+		res.Policy = *p.Policy
+	}
+	if p.Encryption != nil {
+		res.CustomerKeySHA256 = p.Encryption.KeySHA256
+	}
+	return &res, nil
+}
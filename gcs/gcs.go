@@ -0,0 +1,778 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+const defaultEndpoint = "https://storage.googleapis.com"
+
+type GcsCompletedPart struct {
+	// Entity tag returned when the part was uploaded.
+	ETag string
+
+	// Part number that identifies the part. This is a positive integer between
+	// 1 and 10,000.
+	PartNumber int64
+}
+
+func (p *GcsCompletedPart) ETagPtr() *string {
+	return &p.ETag
+}
+
+func (p *GcsCompletedPart) PartNumberPtr() *int64 {
+	return &p.PartNumber
+}
+
+type Service struct {
+	cfg      *Config
+	cred     *credentials
+	endpoint *url.URL
+	client   *http.Client
+}
+
+type Config struct {
+	Credentials struct {
+		// ServiceAccountKey is JSON key of the service account. URLs are
+		// signed by its private key without network calls.
+		ServiceAccountKey string `json:"serviceAccountKey"`
+
+		// ServiceAccountKeyFile is the path to JSON key file, it's read if
+		// ServiceAccountKey is empty.
+		ServiceAccountKeyFile string `json:"serviceAccountKeyFile"`
+	} `json:"credentials"`
+
+	// Endpoint is the base URL of Cloud Storage, https://storage.googleapis.com
+	// by default. Set it to use an emulator.
+	Endpoint   string `json:"endpoint"`
+	RetryCount int    `json:"retryCount"`
+}
+
+// check that Service implements interface bsw.ObjectService
+var _ bsw.BlockStorageWrapper = (*Service)(nil)
+
+func New(cfg *Config) *Service {
+	s := Service{cfg: cfg, client: http.DefaultClient}
+	if s.cfg.RetryCount == 0 {
+		s.cfg.RetryCount = 5
+	}
+	return &s
+}
+
+func (s *Service) Init(ctx context.Context) error {
+
+	key := []byte(s.cfg.Credentials.ServiceAccountKey)
+	if len(key) == 0 {
+		if s.cfg.Credentials.ServiceAccountKeyFile == "" {
+			return errors.NewCritical("service account key is not set")
+		}
+
+		var err error
+		if key, err = os.ReadFile(s.cfg.Credentials.ServiceAccountKeyFile); err != nil {
+			return errors.Catch(err).Critical().Set("path", s.cfg.Credentials.ServiceAccountKeyFile).Msg("reading service account key failed")
+		}
+	}
+
+	cred, err := parseServiceAccountKey(key, s.client)
+	if err != nil {
+		return err
+	}
+	s.cred = cred
+
+	ep := s.cfg.Endpoint
+	if ep == "" {
+		ep = defaultEndpoint
+	}
+	if s.endpoint, err = url.Parse(strings.TrimSuffix(ep, "/")); err != nil || s.endpoint.Host == "" {
+		return errors.NewCritical("invalid endpoint").Set("endpoint", ep)
+	}
+	return nil
+}
+
+func (s *Service) Name() string {
+	return "gcs"
+}
+
+// objectPath returns path of XML API object resource.
+func objectPath(endpoint *url.URL, bucket, key string) string {
+	return endpoint.Path + "/" + bucket + "/" + escape(key, true)
+}
+
+// xmlURL returns URL of XML API object resource.
+func (s *Service) xmlURL(bucket, key string) string {
+	return s.endpoint.Scheme + "://" + s.endpoint.Host + objectPath(s.endpoint, bucket, key)
+}
+
+// jsonURL returns URL of JSON API resource with path elements joined after
+// /storage/v1. Elements are escaped.
+func (s *Service) jsonURL(query url.Values, elems ...string) string {
+	var sb strings.Builder
+	sb.WriteString(s.endpoint.String() + "/storage/v1")
+	for _, e := range elems {
+		sb.WriteString("/" + escape(e, false))
+	}
+	if len(query) > 0 {
+		sb.WriteString("?" + canonicalQuery(query))
+	}
+	return sb.String()
+}
+
+// send sends authorized request. Requests without body or with in memory
+// body are retried on network errors, throttling and server errors.
+func (s *Service) send(ctx context.Context, method, u string, h map[string]string, body io.Reader, size int64) (*http.Response, error) {
+
+	buf, retry := body.(*bytes.Reader)
+	retry = retry || body == nil
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, body)
+		if err != nil {
+			return nil, errors.Catch(err).Critical().StatusCode(500).Msg("creating request failed")
+		}
+		if body != nil {
+			req.ContentLength = size
+			if size == 0 {
+				req.Body = http.NoBody
+			}
+		}
+
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+
+		token, err := s.cred.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := s.client.Do(req)
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, errors.Catch(ctx.Err()).StatusCode(503).Msg("request cancelled")
+		}
+
+		again := retry && attempt < s.cfg.RetryCount &&
+			(err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500)
+		if !again {
+			if err != nil {
+				return nil, errors.Catch(err).Critical().StatusCode(503).Msg("request failed")
+			}
+			return resp, nil
+		}
+
+		if err == nil {
+			resp.Body.Close()
+		}
+		if buf != nil {
+			buf.Seek(0, io.SeekStart)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Catch(ctx.Err()).StatusCode(503).Msg("request cancelled")
+		case <-time.After(time.Duration(attempt+1) * 100 * time.Millisecond):
+		}
+	}
+}
+
+// sendJSON sends request with JSON encoded in and decodes response to out
+// if it's not nil.
+func (s *Service) sendJSON(ctx context.Context, method, u string, h map[string]string, in, out interface{}) (*http.Response, error) {
+
+	var (
+		body io.Reader
+		size int64
+	)
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return nil, errors.Catch(err).Critical().StatusCode(500).Msg("encoding request failed")
+		}
+		body, size = bytes.NewReader(buf), int64(len(buf))
+		if h == nil {
+			h = make(map[string]string)
+		}
+		h["Content-Type"] = "application/json"
+	}
+
+	resp, err := s.send(ctx, method, u, h, body, size)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp, responseError(resp)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, errors.Catch(err).Critical().StatusCode(503).Msg("decoding response failed")
+		}
+	}
+	return resp, nil
+}
+
+// responseError returns error reported by the response.
+func responseError(resp *http.Response) *errors.CatchedError {
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return errors.New("gcs request failed").Critical().
+		SetPairs("status", resp.StatusCode, "response", strings.TrimSpace(string(buf))).StatusCode(503)
+}
+
+// objectError returns error reported by the response to object request.
+func objectError(resp *http.Response, o *bsw.Object) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+	case http.StatusRequestedRangeNotSatisfiable:
+		return bsw.ErrInvalidRange.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+	}
+	return responseError(resp).SetPairs("bucket", o.Bucket(), "key", o.Key())
+}
+
+// objectHeaders returns XML API headers of the object's content headers,
+// metadata and encryption.
+func objectHeaders(o *bsw.Object) (map[string]string, error) {
+
+	res, err := encryptionHeaders(o.Encryption(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	h := o.Headers()
+	for k, v := range map[string]string{
+		"Content-Type":        h.ContentType,
+		"Content-Disposition": h.ContentDisposition,
+		"Cache-Control":       h.CacheControl,
+		"Content-Encoding":    h.ContentEncoding,
+		"Content-Language":    h.ContentLanguage,
+	} {
+		if v != "" {
+			res[k] = v
+		}
+	}
+
+	for k, v := range o.Metadata() {
+		if v != nil {
+			res["x-goog-meta-"+k] = *v
+		}
+	}
+	return res, nil
+}
+
+// UploadHeaders returns headers the client must send with request to presigned
+// PUT URL. Parts of multipart upload are uploaded by resumable uploads, the
+// request to presigned POST URL of the part must carry x-goog-resumable header.
+func (s *Service) UploadHeaders(o *bsw.Object) map[string]string {
+	if o.Parts() > 1 {
+		res, _ := partHeaders(o)
+		return res
+	}
+	res, _ := objectHeaders(o)
+	return res
+}
+
+func (s *Service) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignPutObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignPutObjectURLContext returns V4 signed URL for PUT object request.
+// Signing is local, ctx is accepted for interface compatibility.
+func (s *Service) PreSignPutObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+
+	h, err := objectHeaders(o)
+	if err != nil {
+		return "", err
+	}
+	return s.cred.signedURL(s.endpoint, "PUT", o.Bucket(), o.Key(), timeout, h, nil, time.Now())
+}
+
+func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
+	return s.PreSignGetObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignGetObjectURLContext returns V4 signed URL for GET object request.
+// Response headers set by the object are returned instead of stored ones.
+func (s *Service) PreSignGetObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+
+	h, err := encryptionHeaders(o.Encryption(), false)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	rh := o.ResponseHeaders()
+	for k, v := range map[string]string{
+		"response-content-type":        rh.ContentType,
+		"response-content-disposition": rh.ContentDisposition,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	return s.cred.signedURL(s.endpoint, "GET", o.Bucket(), o.Key(), timeout, h, q, time.Now())
+}
+
+// Put uploads content of r by single request if size is known, by resumable
+// upload in chunks otherwise.
+func (s *Service) Put(ctx context.Context, o *bsw.Object, r io.Reader, size int64) error {
+
+	h, err := objectHeaders(o)
+	if err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return s.resumableUpload(ctx, o, h, r)
+	}
+
+	resp, err := s.send(ctx, "PUT", s.xmlURL(o.Bucket(), o.Key()), h, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return objectError(resp, o)
+	}
+	return nil
+}
+
+// uploadChunkSize is the size of resumable upload chunk, it must be
+// multiple of 256 KiB.
+const uploadChunkSize = 8 << 20
+
+// resumableUpload uploads content of r of unknown size by chunks.
+func (s *Service) resumableUpload(ctx context.Context, o *bsw.Object, h map[string]string, r io.Reader) error {
+
+	session, err := s.startResumable(ctx, o, h)
+	if err != nil {
+		return err
+	}
+
+	eh, _ := encryptionHeaders(o.Encryption(), false)
+	buf := make([]byte, uploadChunkSize)
+	var off int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return errors.Catch(err).SetPairs("bucket", o.Bucket(), "key", o.Key()).StatusCode(500).Msg("reading content failed")
+		}
+
+		ch := make(map[string]string, len(eh)+1)
+		for k, v := range eh {
+			ch[k] = v
+		}
+		switch {
+		case last && n == 0:
+			ch["Content-Range"] = "bytes */" + strconv.FormatInt(off, 10)
+		case last:
+			ch["Content-Range"] = "bytes " + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(off+int64(n)-1, 10) + "/" + strconv.FormatInt(off+int64(n), 10)
+		default:
+			ch["Content-Range"] = "bytes " + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(off+int64(n)-1, 10) + "/*"
+		}
+
+		resp, err := s.send(ctx, "PUT", session, ch, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		switch {
+		case last && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated):
+			return nil
+		case !last && resp.StatusCode == http.StatusPermanentRedirect:
+			off += int64(n)
+		default:
+			return objectError(resp, o)
+		}
+	}
+}
+
+// startResumable starts resumable upload of the object and returns its session URI.
+func (s *Service) startResumable(ctx context.Context, o *bsw.Object, h map[string]string) (string, error) {
+
+	sh := map[string]string{"x-goog-resumable": "start"}
+	for k, v := range h {
+		sh[k] = v
+	}
+
+	resp, err := s.send(ctx, "POST", s.xmlURL(o.Bucket(), o.Key()), sh, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", objectError(resp, o)
+	}
+
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return "", errors.NewCritical("resumable upload session uri is missing").SetPairs("bucket", o.Bucket(), "key", o.Key()).StatusCode(503)
+	}
+	return loc, nil
+}
+
+func (s *Service) Get(ctx context.Context, o *bsw.Object) (io.ReadCloser, bsw.ObjectInfo, error) {
+	return s.GetRange(ctx, o, 0, -1)
+}
+
+// GetRange returns n bytes of the object content starting at off.
+func (s *Service) GetRange(ctx context.Context, o *bsw.Object, off, n int64) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	h, err := encryptionHeaders(o.Encryption(), false)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+	if rng := bsw.HTTPRange(off, n); rng != "" {
+		h["Range"] = rng
+	}
+
+	resp, err := s.send(ctx, "GET", s.xmlURL(o.Bucket(), o.Key()), h, nil, 0)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, bsw.ObjectInfo{}, objectError(resp, o)
+	}
+	return resp.Body, headerInfo(o, resp), nil
+}
+
+// Stat returns object attributes using HEAD object request.
+func (s *Service) Stat(ctx context.Context, o *bsw.Object) (bsw.ObjectInfo, error) {
+
+	h, err := encryptionHeaders(o.Encryption(), false)
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+
+	resp, err := s.send(ctx, "HEAD", s.xmlURL(o.Bucket(), o.Key()), h, nil, 0)
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return bsw.ObjectInfo{}, objectError(resp, o)
+	}
+	return headerInfo(o, resp), nil
+}
+
+// headerInfo returns object attributes reported by XML API response headers.
+func headerInfo(o *bsw.Object, resp *http.Response) bsw.ObjectInfo {
+
+	oi := bsw.ObjectInfo{
+		Bucket:       o.Bucket(),
+		Key:          o.Key(),
+		Size:         bsw.ContentRangeSize(resp.Header.Get("Content-Range"), resp.ContentLength),
+		ETag:         strings.Trim(resp.Header.Get("ETag"), `"`),
+		ContentType:  resp.Header.Get("Content-Type"),
+		StorageClass: resp.Header.Get("X-Goog-Storage-Class"),
+
+		ContentDisposition: resp.Header.Get("Content-Disposition"),
+		CacheControl:       resp.Header.Get("Cache-Control"),
+		ContentEncoding:    resp.Header.Get("Content-Encoding"),
+		ContentLanguage:    resp.Header.Get("Content-Language"),
+	}
+	if v := resp.Header.Get("X-Goog-Stored-Content-Length"); v != "" && resp.Header.Get("Content-Range") == "" {
+		oi.Size, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		oi.LastModified = t
+	}
+
+	for k, v := range resp.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-goog-meta-") && len(v) > 0 {
+			if oi.Metadata == nil {
+				oi.Metadata = make(map[string]*string)
+			}
+			val := v[0]
+			oi.Metadata[name[len("x-goog-meta-"):]] = &val
+		}
+	}
+	return oi
+}
+
+// objectResource is JSON API object resource.
+type objectResource struct {
+	Name               string            `json:"name,omitempty"`
+	Bucket             string            `json:"bucket,omitempty"`
+	Size               string            `json:"size,omitempty"`
+	MD5Hash            string            `json:"md5Hash,omitempty"`
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	StorageClass       string            `json:"storageClass,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	TimeCreated        time.Time         `json:"timeCreated,omitempty"`
+	Updated            time.Time         `json:"updated,omitempty"`
+}
+
+// newObjectResource returns resource describing content headers and metadata of the object.
+func newObjectResource(o *bsw.Object) *objectResource {
+	h := o.Headers()
+	res := objectResource{
+		ContentType:        h.ContentType,
+		ContentDisposition: h.ContentDisposition,
+		CacheControl:       h.CacheControl,
+		ContentEncoding:    h.ContentEncoding,
+		ContentLanguage:    h.ContentLanguage,
+	}
+	for k, v := range o.Metadata() {
+		if v != nil {
+			if res.Metadata == nil {
+				res.Metadata = make(map[string]string)
+			}
+			res.Metadata[k] = *v
+		}
+	}
+	return &res
+}
+
+// etag returns ETag XML API reports for the object, hex encoded MD5 of
+// the content. Composite objects have no MD5.
+func (r *objectResource) etag() string {
+	sum, err := base64.StdEncoding.DecodeString(r.MD5Hash)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(sum)
+}
+
+func (r *objectResource) info(bucket string) bsw.ObjectInfo {
+	oi := bsw.ObjectInfo{
+		Bucket:       bucket,
+		Key:          r.Name,
+		ETag:         r.etag(),
+		ContentType:  r.ContentType,
+		LastModified: r.Updated,
+		StorageClass: r.StorageClass,
+		Created:      r.TimeCreated,
+
+		ContentDisposition: r.ContentDisposition,
+		CacheControl:       r.CacheControl,
+		ContentEncoding:    r.ContentEncoding,
+		ContentLanguage:    r.ContentLanguage,
+	}
+	oi.Size, _ = strconv.ParseInt(r.Size, 10, 64)
+	for k, v := range r.Metadata {
+		if oi.Metadata == nil {
+			oi.Metadata = make(map[string]*string, len(r.Metadata))
+		}
+		v := v
+		oi.Metadata[k] = &v
+	}
+	return oi
+}
+
+// objectList is JSON API list objects response.
+type objectList struct {
+	Items         []objectResource `json:"items"`
+	Prefixes      []string         `json:"prefixes"`
+	NextPageToken string           `json:"nextPageToken"`
+}
+
+func (s *Service) listPage(ctx context.Context, bucket string, q url.Values) (*objectList, error) {
+	var res objectList
+	if _, err := s.sendJSON(ctx, "GET", s.jsonURL(q, "b", bucket, "o"), nil, nil, &res); err != nil {
+		return nil, errors.Wrap(err, errors.New("list objects failed").SetPairs("bucket", bucket, "prefix", q.Get("prefix")))
+	}
+	return &res, nil
+}
+
+// List returns iterator over objects backed by JSON API list requests. Objects
+// of multipart uploads in progress are skipped.
+func (s *Service) List(ctx context.Context, bucket string, opts bsw.ListOptions) *bsw.ListIterator {
+	return bsw.NewListIterator(ctx, opts, func(ctx context.Context, token string) (bsw.ListPage, error) {
+
+		q := url.Values{}
+		if opts.Prefix != "" {
+			q.Set("prefix", opts.Prefix)
+		}
+		if opts.Delimiter != "" {
+			q.Set("delimiter", opts.Delimiter)
+		}
+		if opts.StartAfter != "" {
+			q.Set("startOffset", opts.StartAfter)
+		}
+		if opts.PageSize > 0 {
+			q.Set("maxResults", strconv.Itoa(opts.PageSize))
+		}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+
+		page, err := s.listPage(ctx, bucket, q)
+		if err != nil {
+			return bsw.ListPage{}, err
+		}
+
+		// items and prefixes are sorted each, they are merged in key order.
+		res := bsw.ListPage{NextToken: page.NextPageToken}
+		items, prefixes := page.Items, page.Prefixes
+		for len(items) > 0 || len(prefixes) > 0 {
+			if len(prefixes) == 0 || (len(items) > 0 && items[0].Name < prefixes[0]) {
+				// startOffset is inclusive.
+				if name := items[0].Name; name != opts.StartAfter && !strings.HasPrefix(name, uploadsPrefix) {
+					res.Objects = append(res.Objects, items[0].info(bucket))
+				}
+				items = items[1:]
+				continue
+			}
+			if p := prefixes[0]; p != uploadsPrefix {
+				res.Objects = append(res.Objects, bsw.ObjectInfo{Bucket: bucket, Key: p, IsPrefix: true})
+			}
+			prefixes = prefixes[1:]
+		}
+		return res, nil
+	})
+}
+
+// Copy copies the object by JSON API rewrite requests. Metadata of src is
+// kept unless opts.ReplaceMetadata is set.
+func (s *Service) Copy(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+
+	h, err := encryptionHeaders(dst.Encryption(), false)
+	if err != nil {
+		return err
+	}
+	if _, err := encryptionHeaders(src.Encryption(), false); err != nil {
+		return err
+	}
+	for k, v := range copySourceHeaders(src.Encryption()) {
+		h[k] = v
+	}
+
+	// empty request body keeps source metadata, typed nil pointer would be
+	// sent as JSON null.
+	var body interface{}
+	if opts.ReplaceMetadata {
+		res := newObjectResource(dst)
+		if res.ContentType == "" {
+			oi, err := s.Stat(ctx, src)
+			if err != nil {
+				return err
+			}
+			res.ContentType = oi.ContentType
+		}
+		body = res
+	}
+
+	q := url.Values{}
+	if kms := kmsKeyName(dst.Encryption()); kms != "" {
+		q.Set("destinationKmsKeyName", kms)
+	}
+
+	for {
+		// large objects are rewritten by several requests.
+		var rr struct {
+			Done         bool   `json:"done"`
+			RewriteToken string `json:"rewriteToken"`
+		}
+
+		u := s.jsonURL(q, "b", src.Bucket(), "o", src.Key(), "rewriteTo", "b", dst.Bucket(), "o", dst.Key())
+		resp, err := s.sendJSON(ctx, "POST", u, h, body, &rr)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				return bsw.ErrNotFound.Capture().SetPairs("bucket", src.Bucket(), "key", src.Key())
+			}
+			return errors.Wrap(err, errors.New("copy object failed").SetPairs("srcBucket", src.Bucket(), "srcKey", src.Key(),
+				"dstBucket", dst.Bucket(), "dstKey", dst.Key()))
+		}
+		if rr.Done {
+			return nil
+		}
+		q.Set("rewriteToken", rr.RewriteToken)
+	}
+}
+
+// Move copies the object and deletes src, Cloud Storage has no rename.
+func (s *Service) Move(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+	if err := s.Copy(ctx, src, dst, opts); err != nil {
+		return err
+	}
+	return s.Delete(ctx, src)
+}
+
+// Delete removes the object. Deletion of not existing object is not an error.
+func (s *Service) Delete(ctx context.Context, o *bsw.Object) error {
+
+	resp, err := s.send(ctx, "DELETE", s.xmlURL(o.Bucket(), o.Key()), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return objectError(resp, o)
+	}
+	return nil
+}
+
+// DeleteMany removes objects one by one, Cloud Storage has no bulk delete
+// besides batch requests of JSON API.
+func (s *Service) DeleteMany(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
+
+	var res []bsw.DeleteError
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return res, errors.Catch(err).Set("bucket", bucket).StatusCode(503).Msg("deletion cancelled")
+		}
+		if err := s.Delete(ctx, bsw.NewObject(s, bucket, key)); err != nil {
+			res = append(res, bsw.DeleteError{Key: key, Err: err})
+		}
+	}
+	return res, nil
+}
+
+// DeletePrefix removes all objects with keys starting with prefix.
+func (s *Service) DeletePrefix(ctx context.Context, bucket, prefix string) ([]bsw.DeleteError, error) {
+
+	if prefix == "" {
+		return nil, bsw.ErrWrongInvocation.Capture().Set("reason", "empty prefix")
+	}
+
+	var res []bsw.DeleteError
+	q := url.Values{"prefix": {prefix}}
+	for {
+		page, err := s.listPage(ctx, bucket, q)
+		if err != nil {
+			return res, err
+		}
+
+		keys := make([]string, len(page.Items))
+		for i := range page.Items {
+			keys[i] = page.Items[i].Name
+		}
+
+		failed, err := s.DeleteMany(ctx, bucket, keys)
+		res = append(res, failed...)
+		if err != nil || page.NextPageToken == "" {
+			return res, err
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+}
@@ -0,0 +1,275 @@
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axkit/errors"
+)
+
+const (
+	defaultTokenURI = "https://oauth2.googleapis.com/token"
+	storageScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// maxSignedURLExpiration is the longest validity of V4 signed URL.
+	maxSignedURLExpiration = 7 * 24 * time.Hour
+)
+
+// serviceAccountKey is JSON key of the service account downloaded from
+// Google Cloud console.
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// credentials signs URLs and issues access tokens on behalf of the service account.
+type credentials struct {
+	email    string
+	keyID    string
+	key      *rsa.PrivateKey
+	tokenURI string
+	client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func parseServiceAccountKey(buf []byte, client *http.Client) (*credentials, error) {
+
+	var sak serviceAccountKey
+	if err := json.Unmarshal(buf, &sak); err != nil {
+		return nil, errors.Catch(err).Critical().Msg("decoding service account key failed")
+	}
+
+	if sak.Type != "service_account" || sak.ClientEmail == "" {
+		return nil, errors.NewCritical("service account key expected").Set("type", sak.Type)
+	}
+
+	block, _ := pem.Decode([]byte(sak.PrivateKey))
+	if block == nil {
+		return nil, errors.NewCritical("private key of service account is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, errors.Catch(err).Critical().Msg("parsing private key of service account failed")
+		}
+	} else {
+		var ok bool
+		if key, ok = pk.(*rsa.PrivateKey); !ok {
+			return nil, errors.NewCritical("private key of service account is not RSA key")
+		}
+	}
+
+	c := credentials{
+		email:    sak.ClientEmail,
+		keyID:    sak.PrivateKeyID,
+		key:      key,
+		tokenURI: sak.TokenURI,
+		client:   client,
+	}
+	if c.tokenURI == "" {
+		c.tokenURI = defaultTokenURI
+	}
+	return &c, nil
+}
+
+func (c *credentials) sign(data []byte) ([]byte, error) {
+	sum := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, sum[:])
+}
+
+// accessToken returns OAuth2 access token obtained by JWT bearer grant.
+// The token is cached till a minute before expiration.
+func (c *credentials) accessToken(ctx context.Context) (string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	now := time.Now()
+	assertion, err := c.jwt(map[string]interface{}{
+		"iss":   c.email,
+		"scope": storageScope,
+		"aud":   c.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("creating token request failed")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(503).Msg("token request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp).Msg("token request failed")
+	}
+
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(503).Msg("decoding token response failed")
+	}
+
+	c.token = tr.AccessToken
+	c.expires = now.Add(time.Duration(tr.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// jwt returns RS256 signed JWT with claims.
+func (c *credentials) jwt(claims map[string]interface{}) (string, error) {
+
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": c.keyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("encoding jwt failed")
+	}
+
+	s := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := c.sign([]byte(s))
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("signing jwt failed")
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signedURL returns V4 signed URL of XML API request. Headers are signed and
+// must be sent by the client as is, query parameters are added to the URL.
+func (c *credentials) signedURL(endpoint *url.URL, method, bucket, key string, expires time.Duration, headers map[string]string, query url.Values, now time.Time) (string, error) {
+
+	if expires > maxSignedURLExpiration {
+		return "", errors.ValidationFailed("signed url expiration is too long").Set("expires", expires.String())
+	}
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	ts := now.Format("20060102T150405Z")
+	scope := date + "/auto/storage/goog4_request"
+
+	h := map[string]string{"host": endpoint.Host}
+	for k, v := range headers {
+		h[strings.ToLower(k)] = strings.Join(strings.Fields(v), " ")
+	}
+	payload := "UNSIGNED-PAYLOAD"
+	if v, ok := h["x-goog-content-sha256"]; ok {
+		payload = v
+	}
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var ch strings.Builder
+	for _, k := range names {
+		ch.WriteString(k + ":" + h[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	q.Set("X-Goog-Credential", c.email+"/"+scope)
+	q.Set("X-Goog-Date", ts)
+	q.Set("X-Goog-Expires", strconv.FormatInt(int64(expires.Seconds()), 10))
+	q.Set("X-Goog-SignedHeaders", signedHeaders)
+
+	path := objectPath(endpoint, bucket, key)
+	cq := canonicalQuery(q)
+
+	cr := strings.Join([]string{method, path, cq, ch.String(), signedHeaders, payload}, "\n")
+	sum := sha256.Sum256([]byte(cr))
+	sts := strings.Join([]string{"GOOG4-RSA-SHA256", ts, scope, hex.EncodeToString(sum[:])}, "\n")
+
+	sig, err := c.sign([]byte(sts))
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("signing url failed")
+	}
+
+	return endpoint.Scheme + "://" + endpoint.Host + path + "?" + cq + "&X-Goog-Signature=" + hex.EncodeToString(sig), nil
+}
+
+// canonicalQuery returns sorted and RFC 3986 encoded query string.
+func canonicalQuery(q url.Values) string {
+
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		for _, v := range q[k] {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(escape(k, false) + "=" + escape(v, false))
+		}
+	}
+	return sb.String()
+}
+
+// escape percent encodes all characters of s but unreserved ones. Slashes
+// are kept if path is true.
+func escape(s string, path bool) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', path && c == '/':
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(hexDigits[c>>4])
+			sb.WriteByte(hexDigits[c&15])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,361 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// Cloud Storage has no multipart uploads like S3. Every part is uploaded to
+// its own temporary object by resumable upload, completion composes part
+// objects into the destination object and removes them. Temporary objects
+// of the upload are stored under uploadsPrefix:
+//
+//	.bsw-uploads/<upload id>/key/<key>     the upload record
+//	.bsw-uploads/<upload id>/part/<n>      uploaded parts
+//	.bsw-uploads/<upload id>/tmp/<l>-<n>   intermediate composite objects
+const (
+	uploadsPrefix = ".bsw-uploads/"
+
+	maxParts = 10000
+
+	// maxComposeSources is the maximum number of objects composed by a single request.
+	maxComposeSources = 32
+)
+
+// uploadRecord is the content of the upload record object.
+type uploadRecord struct {
+	Metadata map[string]*string `json:"m,omitempty"`
+	Headers  bsw.Headers        `json:"h"`
+}
+
+func uploadDir(uploadID string) string {
+	return uploadsPrefix + uploadID + "/"
+}
+
+func recordName(uploadID, key string) string {
+	return uploadDir(uploadID) + "key/" + key
+}
+
+func partName(uploadID string, n int64) string {
+	return fmt.Sprintf("%spart/%05d", uploadDir(uploadID), n)
+}
+
+// partHeaders returns headers of the request starting resumable upload of
+// the part. Parts must be encrypted by the key of the object to be composed.
+func partHeaders(o *bsw.Object) (map[string]string, error) {
+
+	res := map[string]string{"x-goog-resumable": "start"}
+	if o.Encryption().Mode == bsw.SSECustomer {
+		eh, err := encryptionHeaders(o.Encryption(), true)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range eh {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+	return s.PreSignMultipartObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignMultipartObjectURLContext stores the upload record and returns V4
+// signed URL starting resumable upload for every part. The client POSTs to
+// the URL with headers returned by UploadHeaders, and PUTs the part content
+// to the session URI returned by Location header. Interrupted uploads are
+// resumed as described by Cloud Storage resumable upload protocol.
+func (s *Service) PreSignMultipartObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+
+	if o.Parts() < 1 || o.Parts() > maxParts {
+		return nil, "", errors.ValidationFailed("invalid number of parts").Set("parts", o.Parts())
+	}
+
+	if _, err := encryptionHeaders(o.Encryption(), true); err != nil {
+		return nil, "", err
+	}
+	ph, err := partHeaders(o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", errors.Catch(err).Critical().StatusCode(500).Msg("generating upload id failed")
+	}
+	uploadID := hex.EncodeToString(id)
+
+	buf, err := json.Marshal(uploadRecord{Metadata: o.Metadata(), Headers: o.Headers()})
+	if err != nil {
+		return nil, "", errors.Catch(err).Critical().StatusCode(500).Msg("encoding upload record failed")
+	}
+
+	rec := bsw.NewObject(s, o.Bucket(), recordName(uploadID, o.Key()))
+	if err := s.Put(ctx, rec, bytes.NewReader(buf), int64(len(buf))); err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	urls := make([]string, o.Parts())
+	for i := range urls {
+		if urls[i], err = s.cred.signedURL(s.endpoint, "POST", o.Bucket(), partName(uploadID, int64(i+1)), timeout, ph, nil, now); err != nil {
+			return nil, "", err
+		}
+	}
+	return urls, uploadID, nil
+}
+
+func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+	return s.CompleteMultipartUploadContext(context.Background(), o, uploadID, parts)
+}
+
+// CompleteMultipartUploadContext composes parts into the object. Parts must be
+// listed in ascending order with ETags ListParts reports. Cloud Storage records
+// MD5 of every part uploaded by resumable upload, a part without it is not
+// completed. Composite objects have no MD5, integrity of the content is
+// protected by CRC32C.
+func (s *Service) CompleteMultipartUploadContext(ctx context.Context, o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+
+	if len(parts) == 0 {
+		return errors.ValidationFailed("no parts to complete").Set("uploadId", uploadID)
+	}
+
+	ur, err := s.readUpload(ctx, o, uploadID)
+	if err != nil {
+		return err
+	}
+
+	uploaded, err := s.ListParts(ctx, o, uploadID)
+	if err != nil {
+		return err
+	}
+	byNumber := make(map[int64]*bsw.Part, len(uploaded))
+	for i := range uploaded {
+		byNumber[uploaded[i].PartNumber] = &uploaded[i]
+	}
+
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		n := *p.PartNumberPtr()
+		if i > 0 && n <= *parts[i-1].PartNumberPtr() {
+			return errors.ValidationFailed("parts must be in ascending order").Set("part", n)
+		}
+
+		up, ok := byNumber[n]
+		if !ok {
+			return errors.ValidationFailed("part is not uploaded").SetPairs("uploadId", uploadID, "part", n)
+		}
+		if up.ETag == "" {
+			return errors.ValidationFailed("part has no etag").SetPairs("uploadId", uploadID, "part", n)
+		}
+		if etag := strings.Trim(*p.ETagPtr(), `"`); etag != up.ETag {
+			return errors.ValidationFailed("part etag mismatch").SetPairs("uploadId", uploadID, "part", n, "etag", etag)
+		}
+		names[i] = partName(uploadID, n)
+	}
+
+	eh, err := encryptionHeaders(o.Encryption(), false)
+	if err != nil {
+		return err
+	}
+
+	// every compose request takes 32 objects at most, so parts are composed
+	// into intermediate objects level by level.
+	for level := 0; len(names) > maxComposeSources; level++ {
+		var next []string
+		for i := 0; i < len(names); i += maxComposeSources {
+			j := i + maxComposeSources
+			if j > len(names) {
+				j = len(names)
+			}
+			name := uploadDir(uploadID) + "tmp/" + strconv.Itoa(level) + "-" + strconv.Itoa(len(next))
+			if err := s.compose(ctx, o.Bucket(), name, names[i:j], &objectResource{}, eh, ""); err != nil {
+				return err
+			}
+			next = append(next, name)
+		}
+		names = next
+	}
+
+	dst := bsw.NewObject(s, o.Bucket(), o.Key(), bsw.WithMetadata(ur.Metadata), bsw.WithHeaders(ur.Headers))
+	if err := s.compose(ctx, o.Bucket(), o.Key(), names, newObjectResource(dst), eh, kmsKeyName(o.Encryption())); err != nil {
+		return err
+	}
+
+	// leftovers are removed by SweepMultipartUploads, the record is the last one deleted.
+	s.removeUpload(ctx, o, uploadID)
+	return nil
+}
+
+// compose composes objects of the bucket into the object name described by dst.
+func (s *Service) compose(ctx context.Context, bucket, name string, sources []string, dst *objectResource, h map[string]string, kms string) error {
+
+	type sourceObject struct {
+		Name string `json:"name"`
+	}
+	req := struct {
+		SourceObjects []sourceObject  `json:"sourceObjects"`
+		Destination   *objectResource `json:"destination"`
+	}{Destination: dst}
+	for _, n := range sources {
+		req.SourceObjects = append(req.SourceObjects, sourceObject{Name: n})
+	}
+
+	q := url.Values{}
+	if kms != "" {
+		q.Set("kmsKeyName", kms)
+	}
+
+	if _, err := s.sendJSON(ctx, "POST", s.jsonURL(q, "b", bucket, "o", name, "compose"), h, &req, nil); err != nil {
+		return errors.Wrap(err, errors.New("compose objects failed").SetPairs("bucket", bucket, "key", name, "sources", len(sources)))
+	}
+	return nil
+}
+
+// readUpload returns the record of the upload of the object.
+func (s *Service) readUpload(ctx context.Context, o *bsw.Object, uploadID string) (*uploadRecord, error) {
+
+	if uploadID == "" || strings.Contains(uploadID, "/") {
+		return nil, errors.ValidationFailed("invalid upload id").Set("uploadId", uploadID)
+	}
+
+	rc, _, err := s.Get(ctx, bsw.NewObject(s, o.Bucket(), recordName(uploadID, o.Key())))
+	if err != nil {
+		if errors.Is(err, bsw.ErrNotFound) {
+			return nil, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadId", uploadID)
+		}
+		return nil, err
+	}
+	defer rc.Close()
+
+	var ur uploadRecord
+	if err := json.NewDecoder(rc).Decode(&ur); err != nil {
+		return nil, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "uploadId", uploadID).StatusCode(500).Msg("decoding upload record failed")
+	}
+	return &ur, nil
+}
+
+// removeUpload deletes temporary objects of the upload, the record is deleted last.
+func (s *Service) removeUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+
+	q := url.Values{"prefix": {uploadDir(uploadID)}}
+	var rest []string
+	for {
+		page, err := s.listPage(ctx, o.Bucket(), q)
+		if err != nil {
+			return err
+		}
+		for i := range page.Items {
+			rest = append(rest, page.Items[i].Name)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+
+	rec := recordName(uploadID, o.Key())
+	var keys []string
+	for _, k := range rest {
+		if k != rec {
+			keys = append(keys, k)
+		}
+	}
+
+	failed, err := s.DeleteMany(ctx, o.Bucket(), keys)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return failed[0].Err
+	}
+	return s.Delete(ctx, bsw.NewObject(s, o.Bucket(), rec))
+}
+
+// AbortMultipartUpload removes uploaded parts and the upload record.
+// Unfinished resumable uploads of parts expire in a week.
+func (s *Service) AbortMultipartUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+	if _, err := s.readUpload(ctx, o, uploadID); err != nil {
+		return err
+	}
+	return s.removeUpload(ctx, o, uploadID)
+}
+
+// ListMultipartUploads returns uploads by their records.
+func (s *Service) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]bsw.MultipartUpload, error) {
+
+	var res []bsw.MultipartUpload
+	q := url.Values{"prefix": {uploadsPrefix}}
+	for {
+		page, err := s.listPage(ctx, bucket, q)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range page.Items {
+			rest := strings.TrimPrefix(page.Items[i].Name, uploadsPrefix)
+			j := strings.Index(rest, "/key/")
+			if j < 0 {
+				continue
+			}
+			if key := rest[j+len("/key/"):]; strings.HasPrefix(key, prefix) {
+				res = append(res, bsw.MultipartUpload{
+					Bucket:    bucket,
+					Key:       key,
+					UploadID:  rest[:j],
+					Initiated: page.Items[i].TimeCreated,
+				})
+			}
+		}
+
+		if page.NextPageToken == "" {
+			return res, nil
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// ListParts returns parts uploaded completely. Parts being uploaded by
+// resumable uploads are not listed.
+func (s *Service) ListParts(ctx context.Context, o *bsw.Object, uploadID string) ([]bsw.Part, error) {
+
+	var res []bsw.Part
+	q := url.Values{"prefix": {uploadDir(uploadID) + "part/"}}
+	for {
+		page, err := s.listPage(ctx, o.Bucket(), q)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range page.Items {
+			it := &page.Items[i]
+			n, err := strconv.ParseInt(it.Name[strings.LastIndexByte(it.Name, '/')+1:], 10, 64)
+			if err != nil {
+				continue
+			}
+			size, _ := strconv.ParseInt(it.Size, 10, 64)
+			res = append(res, bsw.Part{PartNumber: n, ETag: it.etag(), Size: size, LastModified: it.Updated})
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].PartNumber < res[j].PartNumber })
+	return res, nil
+}
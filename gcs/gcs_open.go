@@ -0,0 +1,36 @@
+package gcs
+
+import (
+	"context"
+	"net/url"
+	"os"
+
+	"github.com/axkit/bsw"
+)
+
+func init() {
+	bsw.Register("gs", Open)
+}
+
+// Open creates and initializes Service configured by URL
+//
+//	gs://?credentials=/etc/gcs/key.json
+//
+// Service account key file is taken from GOOGLE_APPLICATION_CREDENTIALS if
+// credentials parameter is not given. Endpoint parameter sets Config.Endpoint.
+func Open(ctx context.Context, u *url.URL) (bsw.BlockStorageWrapper, error) {
+
+	q := u.Query()
+
+	cfg := Config{Endpoint: q.Get("endpoint")}
+	cfg.Credentials.ServiceAccountKeyFile = q.Get("credentials")
+	if cfg.Credentials.ServiceAccountKeyFile == "" {
+		cfg.Credentials.ServiceAccountKeyFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	s := New(&cfg)
+	if err := s.Init(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
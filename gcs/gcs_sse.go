@@ -0,0 +1,65 @@
+package gcs
+
+import (
+	"github.com/axkit/bsw"
+)
+
+// encryptionHeaders returns XML API headers requesting encryption e. Upload
+// headers include the Cloud KMS key, customer supplied key is required by
+// both upload and download requests. Encryption context and scopes are not
+// supported by Cloud Storage.
+func encryptionHeaders(e bsw.Encryption, upload bool) (map[string]string, error) {
+
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string)
+	switch e.Mode {
+	case bsw.SSEKMS:
+		if len(e.Context) > 0 {
+			return nil, bsw.ErrNotSupported.Capture().SetPairs("backend", "gcs", "encryption", e.Mode, "reason", "encryption context")
+		}
+		if upload && e.KeyID != "" {
+			res["x-goog-encryption-kms-key-name"] = e.KeyID
+		}
+	case bsw.SSECustomer:
+		res["x-goog-encryption-algorithm"] = "AES256"
+		res["x-goog-encryption-key"] = e.CustomerKeyBase64()
+		res["x-goog-encryption-key-sha256"] = e.CustomerKeySHA256()
+	case bsw.SSEScope:
+		return nil, bsw.ErrNotSupported.Capture().SetPairs("backend", "gcs", "encryption", e.Mode)
+	}
+	return res, nil
+}
+
+// copySourceHeaders returns headers of rewrite request carrying customer
+// supplied key of the source object.
+func copySourceHeaders(e bsw.Encryption) map[string]string {
+	if e.Mode != bsw.SSECustomer {
+		return nil
+	}
+	return map[string]string{
+		"x-goog-copy-source-encryption-algorithm":  "AES256",
+		"x-goog-copy-source-encryption-key":        e.CustomerKeyBase64(),
+		"x-goog-copy-source-encryption-key-sha256": e.CustomerKeySHA256(),
+	}
+}
+
+// kmsKeyName returns Cloud KMS key the object must be encrypted by.
+func kmsKeyName(e bsw.Encryption) string {
+	if e.Mode == bsw.SSEKMS {
+		return e.KeyID
+	}
+	return ""
+}
+
+// DownloadHeaders returns headers the client must send with request to
+// presigned GET URL, only objects encrypted by customer key require them.
+func (s *Service) DownloadHeaders(o *bsw.Object) map[string]string {
+	if o.Encryption().Mode != bsw.SSECustomer {
+		return nil
+	}
+	res, _ := encryptionHeaders(o.Encryption(), false)
+	return res
+}
@@ -0,0 +1,492 @@
+package gcs_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/bsw/gcs"
+	"github.com/axkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeObject struct {
+	data     []byte
+	ct       string
+	metadata map[string]string
+	created  time.Time
+
+	// composite objects have no MD5.
+	composite bool
+}
+
+// fakeGCS is in memory Cloud Storage serving requests the package sends.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]*fakeObject
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+		return
+	}
+
+	var segs []string
+	for _, s := range strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/") {
+		v, _ := url.PathUnescape(s)
+		segs = append(segs, v)
+	}
+
+	// session URI authorizes resumable upload by itself.
+	if segs[0] == "upload" {
+		f.resumable(w, r, segs[1])
+		return
+	}
+	// signatures of signed URLs are not verified.
+	if r.Header.Get("Authorization") != "Bearer token" && r.URL.Query().Get("X-Goog-Signature") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case segs[0] == "storage":
+		f.json(w, r, segs[3:])
+	default:
+		f.xml(w, r, segs[0]+"/"+strings.Join(segs[1:], "/"))
+	}
+}
+
+func (f *fakeGCS) xml(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case "PUT":
+		buf, _ := io.ReadAll(r.Body)
+		fo := newFakeObject(r.Header, buf)
+		f.objects[name] = fo
+		w.Header().Set("ETag", `"`+fo.etag()+`"`)
+	case "POST":
+		id := strconv.Itoa(len(f.uploads))
+		f.uploads[id+"|"+name] = newFakeObject(r.Header, nil)
+		w.Header().Set("Location", "http://"+r.Host+"/upload/"+url.PathEscape(id+"|"+name))
+		w.WriteHeader(http.StatusCreated)
+	case "GET", "HEAD":
+		fo, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range fo.metadata {
+			w.Header().Set("x-goog-meta-"+k, v)
+		}
+		w.Header().Set("Content-Type", fo.ct)
+		w.Header().Set("ETag", `"`+fo.etag()+`"`)
+		data := fo.data
+		if rng := r.Header.Get("Range"); rng != "" {
+			var from, to int
+			if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &from, &to); err != nil {
+				to = len(data) - 1
+			}
+			if to >= len(data) {
+				to = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to, len(data)))
+			data = data[from : to+1]
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		}
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	case "DELETE":
+		if _, ok := f.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeGCS) resumable(w http.ResponseWriter, r *http.Request, id string) {
+	fo := f.uploads[id]
+	buf, _ := io.ReadAll(r.Body)
+	fo.data = append(fo.data, buf...)
+	if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	f.objects[id[strings.IndexByte(id, '|')+1:]] = fo
+	delete(f.uploads, id)
+}
+
+func (f *fakeGCS) json(w http.ResponseWriter, r *http.Request, segs []string) {
+	bucket := segs[0]
+	switch {
+	case len(segs) == 2:
+		prefix, delim := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+		var names, prefixes []string
+		seen := map[string]bool{}
+		for k := range f.objects {
+			if !strings.HasPrefix(k, bucket+"/"+prefix) {
+				continue
+			}
+			name := strings.TrimPrefix(k, bucket+"/")
+			if i := strings.Index(name[len(prefix):], delim); delim != "" && i >= 0 {
+				if p := name[:len(prefix)+i+len(delim)]; !seen[p] {
+					seen[p] = true
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		sort.Strings(prefixes)
+		var items []map[string]interface{}
+		for _, k := range names {
+			fo := f.objects[k]
+			item := map[string]interface{}{
+				"name": strings.TrimPrefix(k, bucket+"/"), "size": strconv.Itoa(len(fo.data)), "contentType": fo.ct,
+				"metadata": fo.metadata, "timeCreated": fo.created, "updated": fo.created,
+			}
+			if !fo.composite {
+				sum := md5.Sum(fo.data)
+				item["md5Hash"] = base64.StdEncoding.EncodeToString(sum[:])
+			}
+			items = append(items, item)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "prefixes": prefixes})
+	case segs[len(segs)-1] == "compose":
+		var req struct {
+			SourceObjects []struct{ Name string } `json:"sourceObjects"`
+			Destination   struct {
+				ContentType string            `json:"contentType"`
+				Metadata    map[string]string `json:"metadata"`
+			} `json:"destination"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fo := &fakeObject{ct: req.Destination.ContentType, metadata: req.Destination.Metadata, created: time.Now(), composite: true}
+		for _, so := range req.SourceObjects {
+			src, ok := f.objects[bucket+"/"+so.Name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fo.data = append(fo.data, src.data...)
+		}
+		f.objects[bucket+"/"+segs[2]] = fo
+		w.Write([]byte("{}"))
+	case len(segs) == 8 && segs[3] == "rewriteTo":
+		src, ok := f.objects[bucket+"/"+segs[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		dst := *src
+		// empty body keeps source metadata, resource replaces it.
+		raw, _ := io.ReadAll(r.Body)
+		if len(raw) > 0 {
+			var body *struct {
+				Metadata map[string]string `json:"metadata"`
+			}
+			if json.Unmarshal(raw, &body) != nil || body == nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			dst.metadata = body.Metadata
+		}
+		f.objects[segs[5]+"/"+segs[7]] = &dst
+		w.Write([]byte(`{"done":true}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeObject(h http.Header, data []byte) *fakeObject {
+	fo := fakeObject{data: data, ct: h.Get("Content-Type"), metadata: map[string]string{}, created: time.Now()}
+	for k, v := range h {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-goog-meta-") {
+			fo.metadata[k[len("x-goog-meta-"):]] = v[0]
+		}
+	}
+	return &fo
+}
+
+func (fo *fakeObject) etag() string {
+	sum := md5.Sum(fo.data)
+	return hex.EncodeToString(sum[:])
+}
+
+func serviceAccountKey(t *testing.T, tokenURI string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	buf, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "demo",
+		"private_key_id": "k1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "bsw@demo.iam.gserviceaccount.com",
+		"token_uri":      tokenURI,
+	})
+	require.NoError(t, err)
+	return string(buf)
+}
+
+func newService(t *testing.T) *gcs.Service {
+	t.Helper()
+	srv := httptest.NewServer(&fakeGCS{objects: map[string]*fakeObject{}, uploads: map[string]*fakeObject{}})
+	t.Cleanup(srv.Close)
+
+	cfg := gcs.Config{Endpoint: srv.URL}
+	cfg.Credentials.ServiceAccountKey = serviceAccountKey(t, srv.URL+"/token")
+	s := gcs.New(&cfg)
+	require.NoError(t, s.Init(context.Background()))
+	return s
+}
+
+func TestService_PreSign(t *testing.T) {
+	s := newService(t)
+
+	o := bsw.NewObject(s, "media", "docs/annual report.pdf", bsw.WithContentType("application/pdf")).
+		SetMetadata("owner", "alice")
+	u, err := o.UploadURL(time.Hour)
+	require.NoError(t, err)
+
+	pu, err := url.Parse(u)
+	require.NoError(t, err)
+	q := pu.Query()
+	assert.Equal(t, "/media/docs/annual%20report.pdf", pu.EscapedPath())
+	assert.Equal(t, "GOOG4-RSA-SHA256", q.Get("X-Goog-Algorithm"))
+	assert.Equal(t, "3600", q.Get("X-Goog-Expires"))
+	assert.True(t, strings.HasPrefix(q.Get("X-Goog-Credential"), "bsw@demo.iam.gserviceaccount.com/"))
+	assert.Equal(t, "content-type;host;x-goog-meta-owner", q.Get("X-Goog-SignedHeaders"))
+	assert.Len(t, q.Get("X-Goog-Signature"), 512)
+	assert.Equal(t, map[string]string{"Content-Type": "application/pdf", "x-goog-meta-owner": "alice"}, o.UploadHeaders())
+
+	o = bsw.NewObject(s, "media", "a.pdf", bsw.WithResponseHeaders(bsw.Headers{ContentDisposition: "attachment"}))
+	u, err = s.PreSignGetObjectURL(o, time.Hour)
+	require.NoError(t, err)
+	pu, err = url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "attachment", pu.Query().Get("response-content-disposition"))
+
+	_, err = s.PreSignGetObjectURL(o, 8*24*time.Hour)
+	assert.Error(t, err)
+
+	_, err = bsw.NewObject(s, "media", "a", bsw.WithEncryptionScope("scope")).UploadURL(time.Hour)
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+}
+
+func TestService_PutGet(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "media", "docs/a.txt", bsw.WithContentType("text/plain")).SetMetadata("owner", "alice")
+	require.NoError(t, o.Put(ctx, strings.NewReader("0123456789"), 10))
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "0123456789", string(buf))
+	assert.Equal(t, int64(10), oi.Size)
+	assert.Equal(t, "text/plain", oi.ContentType)
+	assert.Equal(t, "alice", *oi.Metadata["owner"])
+
+	rc, oi, err = o.GetRange(ctx, 2, 3)
+	require.NoError(t, err)
+	buf, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "234", string(buf))
+	assert.Equal(t, int64(10), oi.Size)
+
+	// unknown size is uploaded by resumable upload.
+	big := strings.Repeat("x", 9<<20)
+	b := bsw.NewObject(s, "media", "big.bin")
+	require.NoError(t, b.Put(ctx, strings.NewReader(big), -1))
+	oi, err = b.Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(big)), oi.Size)
+
+	require.NoError(t, bsw.Copy(ctx, o, bsw.NewObject(s, "media", "docs/b.txt"), bsw.CopyOptions{}))
+	oi, err = bsw.NewObject(s, "media", "docs/b.txt").Stat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", *oi.Metadata["owner"], "copy must keep source metadata")
+
+	it := s.List(ctx, "media", bsw.ListOptions{Prefix: "docs/"})
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Info().Key)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"docs/a.txt", "docs/b.txt"}, keys)
+
+	failed, err := s.DeletePrefix(ctx, "media", "docs/")
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	_, err = o.Stat(ctx)
+	assert.True(t, errors.Is(err, bsw.ErrNotFound))
+	assert.NoError(t, o.Delete(ctx))
+}
+
+func TestService_ListDelimiter(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a/1.txt", "a-b.txt", "c.txt"} {
+		require.NoError(t, bsw.NewObject(s, "media", key).Put(ctx, strings.NewReader(key), -1))
+	}
+
+	it := s.List(ctx, "media", bsw.ListOptions{Delimiter: "/"})
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Info().Key)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"a-b.txt", "a/", "c.txt"}, keys)
+}
+
+func TestService_Multipart(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "media", "movie.mp4", bsw.WithMultiParts(40), bsw.WithContentType("video/mp4"))
+	urls, uploadID, err := o.MultipartUploadURLsContext(ctx, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, urls, 40)
+
+	mus, err := s.ListMultipartUploads(ctx, "media", "movie")
+	require.NoError(t, err)
+	require.Len(t, mus, 1)
+	assert.Equal(t, uploadID, mus[0].UploadID)
+	assert.Equal(t, "movie.mp4", mus[0].Key)
+
+	var want strings.Builder
+	for i, u := range urls {
+		part := strconv.Itoa(i) + ";"
+		want.WriteString(part)
+		uploadPart(t, o, u, part)
+	}
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 40)
+
+	bad := append([]bsw.Part{}, parts...)
+	bad[1].ETag = "wrong"
+	assert.Error(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(bad)))
+	bad[1].ETag = ""
+	assert.Error(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(bad)))
+
+	require.NoError(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(parts)))
+
+	rc, oi, err := o.Get(ctx)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, want.String(), string(buf))
+	assert.Equal(t, "video/mp4", oi.ContentType)
+
+	mus, err = s.ListMultipartUploads(ctx, "media", "")
+	require.NoError(t, err)
+	assert.Empty(t, mus)
+
+	it := s.List(ctx, "media", bsw.ListOptions{})
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Info().Key)
+	}
+	assert.Equal(t, []string{"movie.mp4"}, keys)
+}
+
+// uploadPart uploads the part by resumable upload started by the signed URL.
+func uploadPart(t *testing.T, o *bsw.Object, u, part string) {
+	t.Helper()
+
+	req, _ := http.NewRequest("POST", u, nil)
+	for k, v := range o.UploadHeaders() {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	req, _ = http.NewRequest("PUT", resp.Header.Get("Location"), strings.NewReader(part))
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(part)-1, len(part)))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestService_MultipartPartWithoutETag(t *testing.T) {
+	s := newService(t)
+	ctx := context.Background()
+
+	o := bsw.NewObject(s, "media", "movie.mp4", bsw.WithMultiParts(1))
+	_, uploadID, err := o.MultipartUploadURLsContext(ctx, time.Hour)
+	require.NoError(t, err)
+
+	// composite object in place of the part has no MD5.
+	c := bsw.NewObject(s, "media", ".bsw-uploads/"+uploadID+"/part/00001", bsw.WithMultiParts(1))
+	urls, cID, err := c.MultipartUploadURLsContext(ctx, time.Hour)
+	require.NoError(t, err)
+	uploadPart(t, c, urls[0], "part")
+	cparts, err := s.ListParts(ctx, c, cID)
+	require.NoError(t, err)
+	require.NoError(t, s.CompleteMultipartUpload(c, cID, bsw.CompletedParts(cparts)))
+
+	parts, err := s.ListParts(ctx, o, uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+	assert.Empty(t, parts[0].ETag)
+	assert.Error(t, s.CompleteMultipartUpload(o, uploadID, bsw.CompletedParts(parts)))
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, os.WriteFile(path, []byte(serviceAccountKey(t, "https://oauth2.googleapis.com/token")), 0600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	w, err := bsw.Open(context.Background(), "gs://")
+	require.NoError(t, err)
+	assert.Equal(t, "gcs", w.Name())
+
+	u, err := w.PreSignGetObjectURL(bsw.NewObject(w, "media", "a.txt"), time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "https://storage.googleapis.com/media/a.txt?"), u)
+
+	_, err = bsw.Open(context.Background(), "gs://?credentials="+url.QueryEscape(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package bsw
+
+// Headers holds standard HTTP headers of object content. Empty values
+// are not set.
+type Headers struct {
+	ContentType        string `json:"contentType,omitempty"`
+	ContentDisposition string `json:"contentDisposition,omitempty"`
+	CacheControl       string `json:"cacheControl,omitempty"`
+	ContentEncoding    string `json:"contentEncoding,omitempty"`
+	ContentLanguage    string `json:"contentLanguage,omitempty"`
+}
+
+// IsZero returns true if no header is set.
+func (h Headers) IsZero() bool {
+	return h == Headers{}
+}
+
+// WithHeaders sets headers the object is stored with by presigned PUT, multipart
+// upload and Put. Presigned PUT request may require the client to send the
+// headers, see Object.UploadHeaders.
+func WithHeaders(h Headers) Option {
+	return func(o *Object) {
+		o.headers = h
+	}
+}
+
+func WithContentType(ct string) Option {
+	return func(o *Object) {
+		o.headers.ContentType = ct
+	}
+}
+
+func WithContentDisposition(cd string) Option {
+	return func(o *Object) {
+		o.headers.ContentDisposition = cd
+	}
+}
+
+func WithCacheControl(cc string) Option {
+	return func(o *Object) {
+		o.headers.CacheControl = cc
+	}
+}
+
+func WithContentEncoding(ce string) Option {
+	return func(o *Object) {
+		o.headers.ContentEncoding = ce
+	}
+}
+
+func WithContentLanguage(cl string) Option {
+	return func(o *Object) {
+		o.headers.ContentLanguage = cl
+	}
+}
+
+// WithResponseHeaders overrides response headers of presigned GET request,
+// e.g. to save the download under original file name.
+func WithResponseHeaders(h Headers) Option {
+	return func(o *Object) {
+		o.respHeaders = h
+	}
+}
+
+// Headers returns headers the object is stored with.
+func (o *Object) Headers() Headers {
+	return o.headers
+}
+
+// ResponseHeaders returns response headers overrides of presigned GET request.
+func (o *Object) ResponseHeaders() Headers {
+	return o.respHeaders
+}
+
+// UploadHeaderer is implemented by backends requiring the client to send
+// headers with presigned PUT request.
+type UploadHeaderer interface {
+	UploadHeaders(o *Object) map[string]string
+}
+
+// UploadHeaders returns headers the client must send with presigned PUT
+// request of the object. Returns nil if the backend requires none.
+func (o *Object) UploadHeaders() map[string]string {
+	if uh, ok := o.w.(UploadHeaderer); ok {
+		return uh.UploadHeaders(o)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package bsw
+
+import "time"
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Bucket       string
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	StorageClass string
+	Metadata     map[string]*string
+
+	// Created is the creation time of the object if the backend tracks it.
+	Created time.Time
+
+	// Checksum is hex encoded SHA-256 of the content if the backend tracks it.
+	Checksum string
+
+	// ContentDisposition, CacheControl, ContentEncoding and ContentLanguage
+	// are headers the object is stored with.
+	ContentDisposition string
+	CacheControl       string
+	ContentEncoding    string
+	ContentLanguage    string
+
+	// IsPrefix is true if the entry is a common prefix returned by listing
+	// with delimiter. Only Bucket and Key are set then.
+	IsPrefix bool
+}
+
+// Headers returns headers the object is stored with.
+func (oi *ObjectInfo) Headers() Headers {
+	return Headers{
+		ContentType:        oi.ContentType,
+		ContentDisposition: oi.ContentDisposition,
+		CacheControl:       oi.CacheControl,
+		ContentEncoding:    oi.ContentEncoding,
+		ContentLanguage:    oi.ContentLanguage,
+	}
+}
@@ -0,0 +1,181 @@
+package bsw
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/axkit/errors"
+)
+
+// ListOptions describes parameters of objects listing.
+type ListOptions struct {
+	// Prefix limits listing to keys starting with the prefix.
+	Prefix string
+
+	// Delimiter groups keys containing delimiter after the prefix into
+	// common prefixes ("folders"). Usually "/".
+	Delimiter string
+
+	// StartAfter limits listing to keys lexicographically greater than StartAfter.
+	StartAfter string
+
+	// PageSize is a maximum number of entries requested from backend at once.
+	// Zero means backend's default.
+	PageSize int
+
+	// ContinuationToken resumes listing from the point returned by
+	// ListIterator.ContinuationToken.
+	ContinuationToken string
+}
+
+// ListPage is a single page of listing returned by a backend.
+type ListPage struct {
+	Objects []ObjectInfo
+
+	// NextToken is a backend specific token of the next page.
+	// Empty if the page is the last one.
+	NextToken string
+}
+
+// PageFetcher returns a page of listing identified by backend specific token.
+// Empty token means the first page.
+type PageFetcher func(ctx context.Context, token string) (ListPage, error)
+
+// ListIterator iterates over listing result fetching pages on demand.
+//
+//	it := w.List(ctx, "bucket", bsw.ListOptions{Prefix: "reports/"})
+//	for it.Next() {
+//		oi := it.Info()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type ListIterator struct {
+	ctx   context.Context
+	fetch PageFetcher
+
+	page      []ObjectInfo
+	pos       int
+	pageToken string
+	nextToken string
+	fetched   bool
+	skip      int
+	startTok  string
+
+	cur ObjectInfo
+	err error
+
+	mapInfo func(ObjectInfo) ObjectInfo
+}
+
+// NewListIterator returns iterator over pages provided by fetch.
+// It's intended to be used by backends implementing List.
+func NewListIterator(ctx context.Context, opts ListOptions, fetch PageFetcher) *ListIterator {
+	it := ListIterator{ctx: ctx, fetch: fetch, startTok: opts.ContinuationToken}
+	if opts.ContinuationToken != "" {
+		it.skip, it.pageToken, it.err = decodeContinuationToken(opts.ContinuationToken)
+	}
+	return &it
+}
+
+// Next advances iterator to the next entry. Returns false when listing
+// is over or an error occurred.
+func (it *ListIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for {
+		if it.pos < len(it.page) {
+			it.cur = it.page[it.pos]
+			it.pos++
+			if it.mapInfo != nil && !it.cur.IsPrefix {
+				it.cur = it.mapInfo(it.cur)
+			}
+			return true
+		}
+
+		token := it.pageToken
+		if it.fetched {
+			if it.nextToken == "" {
+				return false
+			}
+			token = it.nextToken
+		}
+
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+
+		p, err := it.fetch(it.ctx, token)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page, it.pos, it.pageToken, it.nextToken, it.fetched = p.Objects, 0, token, p.NextToken, true
+		if it.skip > 0 {
+			it.pos, it.skip = it.skip, 0
+			if it.pos > len(it.page) {
+				it.pos = len(it.page)
+			}
+		}
+	}
+}
+
+// Map sets f converting entries returned by Info, common prefixes are
+// returned as is. Decorators of BlockStorageWrapper use it to adjust
+// listing of the wrapped backend. Returns it.
+func (it *ListIterator) Map(f func(ObjectInfo) ObjectInfo) *ListIterator {
+	it.mapInfo = f
+	return it
+}
+
+// Info returns the entry the iterator points to.
+func (it *ListIterator) Info() ObjectInfo {
+	return it.cur
+}
+
+// Err returns the error stopped the iteration.
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+// ContinuationToken returns a token resuming listing right after the entry
+// returned by the latest Next call. Empty string means listing is over.
+func (it *ListIterator) ContinuationToken() string {
+	if !it.fetched {
+		return it.startTok
+	}
+	if it.pos < len(it.page) {
+		return encodeContinuationToken(it.pos, it.pageToken)
+	}
+	if it.nextToken == "" {
+		return ""
+	}
+	return encodeContinuationToken(0, it.nextToken)
+}
+
+func encodeContinuationToken(skip int, token string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(skip) + ":" + token))
+}
+
+func decodeContinuationToken(s string) (int, string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, "", errors.ValidationFailed("invalid continuation token")
+	}
+
+	parts := strings.SplitN(string(buf), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.ValidationFailed("invalid continuation token")
+	}
+
+	skip, err := strconv.Atoi(parts[0])
+	if err != nil || skip < 0 {
+		return 0, "", errors.ValidationFailed("invalid continuation token")
+	}
+	return skip, parts[1], nil
+}
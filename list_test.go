@@ -0,0 +1,57 @@
+package bsw_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/axkit/bsw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pages returns fetcher splitting n objects by pages of size.
+func pages(n, size int) bsw.PageFetcher {
+	return func(ctx context.Context, token string) (bsw.ListPage, error) {
+		from := 0
+		if token != "" {
+			from, _ = strconv.Atoi(token)
+		}
+
+		var res bsw.ListPage
+		for i := from; i < n && i < from+size; i++ {
+			res.Objects = append(res.Objects, bsw.ObjectInfo{Key: strconv.Itoa(i)})
+		}
+		if from+size < n {
+			res.NextToken = strconv.Itoa(from + size)
+		}
+		return res, nil
+	}
+}
+
+func TestListIterator(t *testing.T) {
+	ctx := context.Background()
+
+	it := bsw.NewListIterator(ctx, bsw.ListOptions{}, pages(7, 3))
+	var keys []string
+	var tokens []string
+	for it.Next() {
+		keys = append(keys, it.Info().Key)
+		tokens = append(tokens, it.ContinuationToken())
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6"}, keys)
+	assert.Empty(t, tokens[6])
+
+	for i := 0; i < 6; i++ {
+		it := bsw.NewListIterator(ctx, bsw.ListOptions{ContinuationToken: tokens[i]}, pages(7, 3))
+		require.True(t, it.Next())
+		assert.Equal(t, strconv.Itoa(i+1), it.Info().Key)
+	}
+}
+
+func TestListIteratorInvalidToken(t *testing.T) {
+	it := bsw.NewListIterator(context.Background(), bsw.ListOptions{ContinuationToken: "%%"}, pages(1, 1))
+	assert.False(t, it.Next())
+	assert.Error(t, it.Err())
+}
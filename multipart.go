@@ -0,0 +1,90 @@
+package bsw
+
+import (
+	"context"
+	"time"
+
+	"github.com/axkit/errors"
+)
+
+// MultipartUpload describes multipart upload started but not completed or aborted yet.
+type MultipartUpload struct {
+	Bucket    string
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// Part describes uploaded part of multipart upload. Part implements CompletedPart,
+// so the result of ListParts can be passed to CompleteMultipartUpload as is.
+type Part struct {
+	PartNumber   int64
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+func (p *Part) ETagPtr() *string {
+	return &p.ETag
+}
+
+func (p *Part) PartNumberPtr() *int64 {
+	return &p.PartNumber
+}
+
+// CompletedParts converts parts to the argument of CompleteMultipartUpload.
+func CompletedParts(parts []Part) []CompletedPart {
+	res := make([]CompletedPart, len(parts))
+	for i := range parts {
+		res[i] = &parts[i]
+	}
+	return res
+}
+
+// SweepMultipartUploads aborts multipart uploads in the bucket with keys starting
+// with prefix initiated earlier than age ago. Failure of a single abort does not
+// stop the sweeping, the first failure is returned after all uploads processed.
+// Uploads the backend can't abort, ErrNotSupported is returned for, are skipped
+// and not counted as failures: the backend expires them itself. Returns aborted
+// uploads.
+func SweepMultipartUploads(ctx context.Context, w BlockStorageWrapper, bucket, prefix string, age time.Duration) ([]MultipartUpload, error) {
+
+	mus, err := w.ListMultipartUploads(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		res    []MultipartUpload
+		first  error
+		failed int
+	)
+
+	before := time.Now().Add(-age)
+	for _, mu := range mus {
+		if !mu.Initiated.Before(before) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		if err := w.AbortMultipartUpload(ctx, NewObject(w, mu.Bucket, mu.Key), mu.UploadID); err != nil {
+			if errors.Is(err, ErrNotSupported) {
+				continue
+			}
+			if first == nil {
+				first = err
+			}
+			failed++
+			continue
+		}
+		res = append(res, mu)
+	}
+
+	if first != nil {
+		return res, errors.Catch(first).SetPairs("bucket", bucket, "prefix", prefix, "failed", failed)
+	}
+	return res, nil
+}
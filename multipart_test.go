@@ -0,0 +1,67 @@
+package bsw_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadsWrapper overrides multipart upload listing and aborting.
+type uploadsWrapper struct {
+	bsw.BlockStorageWrapper
+	uploads []bsw.MultipartUpload
+	aborted []string
+}
+
+func (w *uploadsWrapper) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]bsw.MultipartUpload, error) {
+	return w.uploads, nil
+}
+
+func (w *uploadsWrapper) AbortMultipartUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+	switch uploadID {
+	case "broken":
+		return errors.New("abort failed")
+	case "expiring":
+		return bsw.ErrNotSupported.Capture()
+	}
+	w.aborted = append(w.aborted, uploadID)
+	return nil
+}
+
+func TestSweepMultipartUploads(t *testing.T) {
+	now := time.Now()
+	w := uploadsWrapper{uploads: []bsw.MultipartUpload{
+		{Bucket: "b", Key: "k1", UploadID: "old", Initiated: now.Add(-48 * time.Hour)},
+		{Bucket: "b", Key: "k2", UploadID: "fresh", Initiated: now.Add(-time.Hour)},
+		{Bucket: "b", Key: "k3", UploadID: "broken", Initiated: now.Add(-72 * time.Hour)},
+		{Bucket: "b", Key: "k4", UploadID: "older", Initiated: now.Add(-96 * time.Hour)},
+		{Bucket: "b", Key: "k5", UploadID: "expiring", Initiated: now.Add(-96 * time.Hour)},
+	}}
+
+	res, err := bsw.SweepMultipartUploads(context.Background(), &w, "b", "", 24*time.Hour)
+	assert.Error(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, []string{"old", "older"}, w.aborted)
+
+	// not supported abort is not a failure.
+	w.uploads = w.uploads[3:]
+	w.aborted = nil
+	res, err = bsw.SweepMultipartUploads(context.Background(), &w, "b", "", 24*time.Hour)
+	assert.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, []string{"older"}, w.aborted)
+}
+
+func TestCompletedParts(t *testing.T) {
+	parts := []bsw.Part{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}}
+
+	cps := bsw.CompletedParts(parts)
+	require.Len(t, cps, 2)
+	assert.Equal(t, int64(2), *cps[1].PartNumberPtr())
+	assert.Equal(t, "b", *cps[1].ETagPtr())
+}
@@ -0,0 +1,66 @@
+package bsw
+
+import (
+	"time"
+
+	"github.com/axkit/errors"
+)
+
+// ErrNotSupported is returned when the backend does not support the operation.
+var ErrNotSupported = errors.New("operation is not supported by the backend").StatusCode(501)
+
+// PostPolicyOptions describes conditions of browser form upload.
+type PostPolicyOptions struct {
+	// Expires is the time the policy is valid for.
+	Expires time.Duration `json:"-"`
+
+	// MinSize and MaxSize limit the content length. Zero MaxSize means no limit.
+	MinSize int64 `json:"minSize,omitempty"`
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// KeyStartsWith allows the form to submit any key starting with the
+	// prefix. The object's key is used as the default value of the key field.
+	KeyStartsWith string `json:"keyStartsWith,omitempty"`
+
+	// ContentType requires exact content type, ContentTypeStartsWith
+	// requires content type starting with the prefix.
+	ContentType           string `json:"contentType,omitempty"`
+	ContentTypeStartsWith string `json:"contentTypeStartsWith,omitempty"`
+
+	// SuccessActionRedirect is the URL the client is redirected to after
+	// successful upload. SuccessActionStatus is the status code returned
+	// if there is no redirect: 200, 201 or 204 (default).
+	SuccessActionRedirect string `json:"successActionRedirect,omitempty"`
+	SuccessActionStatus   int    `json:"successActionStatus,omitempty"`
+}
+
+// PostPolicy is the form the browser submits with the file as the last field.
+type PostPolicy struct {
+	URL    string
+	Fields map[string]string
+}
+
+// PostPolicySigner is implemented by backends supporting browser form uploads.
+type PostPolicySigner interface {
+	PreSignPostPolicy(o *Object, opts PostPolicyOptions) (*PostPolicy, error)
+}
+
+// PostPolicy returns the form for uploading the object by browser.
+func (o *Object) PostPolicy(opts PostPolicyOptions) (*PostPolicy, error) {
+
+	pps, ok := o.w.(PostPolicySigner)
+	if !ok {
+		return nil, ErrNotSupported.Capture().SetPairs("backend", o.w.Name(), "op", "post policy")
+	}
+
+	if opts.Expires <= 0 || opts.MaxSize < 0 || opts.MinSize < 0 || (opts.MaxSize > 0 && opts.MinSize > opts.MaxSize) {
+		return nil, ErrWrongInvocation.Capture().SetPairs("expires", opts.Expires, "minSize", opts.MinSize, "maxSize", opts.MaxSize)
+	}
+
+	switch opts.SuccessActionStatus {
+	case 0, 200, 201, 204:
+	default:
+		return nil, ErrWrongInvocation.Capture().Set("successActionStatus", opts.SuccessActionStatus)
+	}
+	return pps.PreSignPostPolicy(o, opts)
+}
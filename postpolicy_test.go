@@ -0,0 +1,24 @@
+package bsw_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// plainBackend implements nothing but the name.
+type plainBackend struct {
+	bsw.BlockStorageWrapper
+}
+
+func (plainBackend) Name() string { return "plain" }
+
+func TestObject_PostPolicy(t *testing.T) {
+	o := bsw.NewObject(plainBackend{}, "b", "k")
+
+	_, err := o.PostPolicy(bsw.PostPolicyOptions{Expires: time.Hour})
+	assert.True(t, errors.Is(err, bsw.ErrNotSupported))
+}
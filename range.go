@@ -0,0 +1,96 @@
+package bsw
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/axkit/errors"
+)
+
+// ErrInvalidRange is returned when requested range starts beyond the end of the object.
+var ErrInvalidRange = errors.New("requested range not satisfiable").StatusCode(416)
+
+// RangeGetter is implemented by backends able to read a range of the object
+// content without reading it from the beginning.
+type RangeGetter interface {
+	// GetRange returns n bytes of the object content starting at off, n < 0
+	// means till the end of the object. Size of returned ObjectInfo is the
+	// size of the whole object. Caller must close returned reader.
+	GetRange(ctx context.Context, o *Object, off, n int64) (io.ReadCloser, ObjectInfo, error)
+}
+
+// GetRange returns n bytes of the object content starting at off, n < 0 means
+// till the end of the object. If the backend does not implement RangeGetter,
+// the content is read from the beginning and first off bytes are skipped.
+func (o *Object) GetRange(ctx context.Context, off, n int64) (io.ReadCloser, ObjectInfo, error) {
+
+	if off < 0 || n == 0 {
+		return nil, ObjectInfo{}, errors.ValidationFailed("invalid range").SetPairs("off", off, "n", n)
+	}
+
+	if rg, ok := o.w.(RangeGetter); ok {
+		return rg.GetRange(ctx, o, off, n)
+	}
+
+	rc, oi, err := o.w.Get(ctx, o)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	if err := CheckRange(off, oi.Size); err != nil {
+		rc.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	if _, err := io.CopyN(io.Discard, rc, off); err != nil {
+		rc.Close()
+		return nil, ObjectInfo{}, errors.Catch(err).SetPairs("bucket", o.bucket, "key", o.key).StatusCode(500).Msg("skipping content failed")
+	}
+
+	if n < 0 {
+		return rc, oi, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(rc, n), Closer: rc}, oi, nil
+}
+
+// CheckRange returns ErrInvalidRange if range starting at off can't be read
+// from the object of size bytes. Range starting at zero is valid for empty object.
+func CheckRange(off, size int64) error {
+	if off > 0 && off >= size {
+		return ErrInvalidRange.Capture().SetPairs("off", off, "size", size)
+	}
+	return nil
+}
+
+// HTTPRange returns value of Range header requesting n bytes starting at off,
+// n < 0 means till the end. Returns empty string if the whole content is requested.
+func HTTPRange(off, n int64) string {
+	switch {
+	case n < 0 && off == 0:
+		return ""
+	case n < 0:
+		return "bytes=" + strconv.FormatInt(off, 10) + "-"
+	}
+	return "bytes=" + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(off+n-1, 10)
+}
+
+// ContentRangeSize returns the size of the whole object reported by
+// Content-Range header cr like "bytes 0-99/1000". Returns def if the size
+// is unknown.
+func ContentRangeSize(cr string, def int64) int64 {
+	for i := len(cr) - 1; i >= 0; i-- {
+		if cr[i] == '/' {
+			if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				return size
+			}
+			break
+		}
+	}
+	return def
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
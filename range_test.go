@@ -0,0 +1,49 @@
+package bsw_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stringBackend serves every object with the same content.
+type stringBackend struct {
+	plainBackend
+	content string
+}
+
+func (b *stringBackend) Get(ctx context.Context, o *bsw.Object) (io.ReadCloser, bsw.ObjectInfo, error) {
+	return io.NopCloser(strings.NewReader(b.content)), bsw.ObjectInfo{Size: int64(len(b.content))}, nil
+}
+
+func TestObject_GetRange(t *testing.T) {
+	ctx := context.Background()
+	o := bsw.NewObject(&stringBackend{content: "0123456789"}, "b", "k")
+
+	rc, oi, err := o.GetRange(ctx, 2, 3)
+	require.NoError(t, err)
+	buf, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "234", string(buf))
+	assert.Equal(t, int64(10), oi.Size)
+
+	rc, _, err = o.GetRange(ctx, 7, -1)
+	require.NoError(t, err)
+	buf, _ = io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "789", string(buf))
+
+	_, _, err = o.GetRange(ctx, 10, -1)
+	assert.True(t, errors.Is(err, bsw.ErrInvalidRange))
+
+	assert.Equal(t, "bytes=2-4", bsw.HTTPRange(2, 3))
+	assert.Equal(t, "", bsw.HTTPRange(0, -1))
+	assert.Equal(t, int64(1000), bsw.ContentRangeSize("bytes 0-99/1000", 100))
+	assert.Equal(t, int64(100), bsw.ContentRangeSize("bytes 0-99/*", 100))
+}
@@ -0,0 +1,83 @@
+package bsw
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/axkit/errors"
+)
+
+// ErrUnknownScheme is returned by Open if no backend is registered for URL scheme.
+// Backend packages register themselves on import, so the package may be
+// just not imported.
+var ErrUnknownScheme = errors.New("unknown storage URL scheme").Critical()
+
+// Factory creates and initializes backend configured by URL.
+type Factory func(ctx context.Context, u *url.URL) (BlockStorageWrapper, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes backend available by URL scheme for Open. It's called from
+// init function of the backend package. Register panics if f is nil or the
+// scheme is already registered.
+func Register(scheme string, f Factory) {
+
+	scheme = strings.ToLower(scheme)
+
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if f == nil {
+		panic("bsw: Register factory is nil for " + scheme)
+	}
+	if _, ok := factories[scheme]; ok {
+		panic("bsw: Register called twice for " + scheme)
+	}
+	factories[scheme] = f
+}
+
+// Schemes returns sorted list of registered URL schemes.
+func Schemes() []string {
+
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	res := make([]string, 0, len(factories))
+	for k := range factories {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Open returns initialized backend configured by URL, the scheme selects
+// the backend:
+//
+//	s3://?region=eu-west-1
+//	azure://account/container
+//	gs://?credentials=/etc/gcs/key.json
+//	file:///var/data
+//
+// Supported query parameters are documented by the backend packages.
+func Open(ctx context.Context, rawURL string) (BlockStorageWrapper, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Catch(err).Critical().Msg("invalid storage URL")
+	}
+
+	factoriesMu.RLock()
+	f, ok := factories[strings.ToLower(u.Scheme)]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownScheme.Capture().Set("scheme", u.Scheme)
+	}
+	return f(ctx, u)
+}
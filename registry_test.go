@@ -0,0 +1,35 @@
+package bsw_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	var got *url.URL
+	bsw.Register("Test", func(ctx context.Context, u *url.URL) (bsw.BlockStorageWrapper, error) {
+		got = u
+		return &stringBackend{content: "hello"}, nil
+	})
+
+	w, err := bsw.Open(context.Background(), "test://host/path?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "host", got.Host)
+	assert.Equal(t, "1", got.Query().Get("x"))
+	assert.NotNil(t, w)
+	assert.Contains(t, bsw.Schemes(), "test")
+
+	_, err = bsw.Open(context.Background(), "nope:///data")
+	assert.True(t, errors.Is(err, bsw.ErrUnknownScheme))
+
+	assert.Panics(t, func() {
+		bsw.Register("test", func(ctx context.Context, u *url.URL) (bsw.BlockStorageWrapper, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { bsw.Register("other", nil) })
+}
@@ -2,14 +2,17 @@ package s3
 
 import (
 	"context"
+	"io"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/axkit/bsw"
 	"github.com/axkit/errors"
 
@@ -37,17 +40,60 @@ type Service struct {
 	cfg  *Config
 	sess *session.Session
 	svc  *s3.S3
+
+	// psvc signs URLs handed out to clients. It differs from svc only when
+	// Config.PublicEndpoint is set.
+	psvc *s3.S3
 }
 
 type Config struct {
+	// Region is taken from environment or shared config if not specified.
 	Region      *string `json:"region"`
 	Credentials struct {
+		// Static keys take precedence over the default chain: environment,
+		// shared config profile, web identity token, container and EC2 role.
 		AwsAccessKeyID     string `json:"awsAccessKeyId"`
 		AwsSecretAccessKey string `json:"awsSecretAccessKey"`
+		AwsSessionToken    string `json:"awsSessionToken"`
+
+		// Profile selects the shared config profile instead of AWS_PROFILE.
+		Profile string `json:"profile"`
+
+		// RoleARN is assumed using credentials resolved above.
+		RoleARN         string `json:"roleArn"`
+		ExternalID      string `json:"externalId"`
+		RoleSessionName string `json:"roleSessionName"`
+
+		// STSEndpoint overrides STS endpoint used to assume the role.
+		STSEndpoint string `json:"stsEndpoint"`
 	} `json:"credentials"`
 	RetryCount int `json:"retryCount"`
+
+	// Endpoint is the URL of S3 compatible service (MinIO, Ceph, R2, etc).
+	// AWS endpoint resolved from Region is used if empty.
+	Endpoint string `json:"endpoint"`
+
+	// PublicEndpoint replaces Endpoint in presigned URLs and POST policies.
+	// Needed when the service reaches storage by internal hostname, but
+	// clients have to use external one.
+	PublicEndpoint string `json:"publicEndpoint"`
+
+	// ForcePathStyle addresses buckets as http://host/bucket/key instead of
+	// http://bucket.host/key.
+	ForcePathStyle bool `json:"forcePathStyle"`
+
+	// DisableSSL switches to http if endpoint is given without scheme.
+	DisableSSL bool `json:"disableSSL"`
+
+	// CABundleFile is the path to PEM file with certificates trusted in
+	// addition to system ones.
+	CABundleFile string `json:"caBundleFile"`
 }
 
+// defaultRegion is used with custom endpoint when region is not specified.
+// S3 compatible services usually accept any region in signature.
+const defaultRegion = "us-east-1"
+
 // check that Service implements interface bsw.ObjectService
 var _ bsw.BlockStorageWrapper = (*Service)(nil)
 
@@ -62,30 +108,84 @@ func New(cfg *Config) *Service {
 func (s *Service) Init(ctx context.Context) error {
 	var err error
 
-	if s.cfg.Region == nil {
-		return errors.NewCritical("aws region not specified")
+	opts := session.Options{
+		Config: aws.Config{
+			Region:           s.cfg.Region,
+			Credentials:      s.staticCredentials(),
+			S3ForcePathStyle: aws.Bool(s.cfg.ForcePathStyle),
+			DisableSSL:       aws.Bool(s.cfg.DisableSSL),
+		},
+		Profile:           s.cfg.Credentials.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if s.cfg.Endpoint != "" {
+		opts.Config.Endpoint = aws.String(s.cfg.Endpoint)
+	}
+	if s.cfg.CABundleFile != "" {
+		f, err := os.Open(s.cfg.CABundleFile)
+		if err != nil {
+			return errors.Catch(err).Critical().Set("file", s.cfg.CABundleFile).Msg("opening CA bundle failed")
+		}
+		defer f.Close()
+		opts.CustomCABundle = f
 	}
 
-	s.sess, err = session.NewSession(&aws.Config{
-		Region:      s.cfg.Region,
-		Credentials: credentials.NewStaticCredentials(s.cfg.Credentials.AwsAccessKeyID, s.cfg.Credentials.AwsSecretAccessKey, ""),
-	})
+	s.sess, err = session.NewSessionWithOptions(opts)
 	if err != nil {
-		return err
+		return errors.Catch(err).Critical().Msg("aws session init failed")
+	}
+
+	if aws.StringValue(s.sess.Config.Region) == "" {
+		if s.cfg.Endpoint == "" {
+			return errors.NewCritical("aws region not specified")
+		}
+		s.sess.Config.Region = aws.String(defaultRegion)
+	}
+
+	if s.cfg.Credentials.RoleARN != "" {
+		s.sess.Config.Credentials = s.assumeRoleCredentials()
 	}
 	s.svc = s3.New(s.sess)
+
+	s.psvc = s.svc
+	if s.cfg.PublicEndpoint != "" {
+		s.psvc = s3.New(s.sess, &aws.Config{Endpoint: aws.String(s.cfg.PublicEndpoint)})
+	}
 	return nil
 }
 
 func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+	return s.PreSignMultipartObjectURLContext(context.Background(), o, timeout)
+}
+
+// PreSignMultipartObjectURLContext creates multipart upload and returns presigned URL for every part.
+func (s *Service) PreSignMultipartObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) ([]string, string, error) {
+
+	sse, err := sseOf(o)
+	if err != nil {
+		return nil, "", err
+	}
 
+	h := o.Headers()
 	mui := &s3.CreateMultipartUploadInput{
-		Bucket: aws.String(o.Bucket()),
-		Key:    aws.String(o.Key()),
+		Bucket:                  aws.String(o.Bucket()),
+		Key:                     aws.String(o.Key()),
+		Metadata:                o.Metadata(),
+		ContentType:             optString(h.ContentType),
+		ContentDisposition:      optString(h.ContentDisposition),
+		CacheControl:            optString(h.CacheControl),
+		ContentEncoding:         optString(h.ContentEncoding),
+		ContentLanguage:         optString(h.ContentLanguage),
+		ServerSideEncryption:    sse.ServerSideEncryption,
+		SSEKMSKeyId:             sse.SSEKMSKeyID,
+		SSEKMSEncryptionContext: sse.SSEKMSEncryptionContext,
+		SSECustomerAlgorithm:    sse.SSECustomerAlgorithm,
+		SSECustomerKey:          sse.SSECustomerKey,
 	}
 
 	req, resp := s.svc.CreateMultipartUploadRequest(mui)
 
+	req.SetContext(ctx)
 	req.RetryCount = s.cfg.RetryCount
 
 	if err := req.Send(); err != nil {
@@ -100,12 +200,17 @@ func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration
 
 	var res []string
 	for i := 0; i < o.Parts(); i++ {
-		x := s.svc.NewRequest(&request.Operation{
+		x := s.psvc.NewRequest(&request.Operation{
 			Name:       "PutObject",
 			HTTPMethod: "PUT",
 			HTTPPath:   "/" + o.Bucket() + "/" + o.Key() + "?partNumber=" + strconv.Itoa(i+1) + "&uploadId=" + *resp.UploadId,
 		}, nil, nil)
 
+		// parts of object encrypted by customer key are uploaded with the key.
+		for k, v := range sseHeaders(o.Encryption(), false) {
+			x.HTTPRequest.Header.Set(k, v)
+		}
+
 		u, err := x.Presign(timeout)
 		if err != nil {
 			return nil, "", errors.Catch(err).
@@ -121,6 +226,16 @@ func (s *Service) PreSignMultipartObjectURL(o *bsw.Object, timeout time.Duration
 
 // CompleteMultipartUpload
 func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+	return s.CompleteMultipartUploadContext(context.Background(), o, uploadID, parts)
+}
+
+// CompleteMultipartUploadContext merges uploaded parts into a single object.
+func (s *Service) CompleteMultipartUploadContext(ctx context.Context, o *bsw.Object, uploadID string, parts []bsw.CompletedPart) error {
+
+	sse, err := sseOf(o)
+	if err != nil {
+		return err
+	}
 
 	var cmu s3.CompletedMultipartUpload
 	for i := range parts {
@@ -130,11 +245,13 @@ func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts
 		})
 	}
 
-	_, err := s.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-		Bucket:          aws.String(o.Bucket()),
-		Key:             aws.String(o.Bucket()),
-		UploadId:        aws.String(uploadID),
-		MultipartUpload: &cmu,
+	_, err = s.svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:               aws.String(o.Bucket()),
+		Key:                  aws.String(o.Key()),
+		UploadId:             aws.String(uploadID),
+		MultipartUpload:      &cmu,
+		SSECustomerAlgorithm: sse.SSECustomerAlgorithm,
+		SSECustomerKey:       sse.SSECustomerKey,
 	})
 
 	if err != nil {
@@ -147,11 +264,32 @@ func (s *Service) CompleteMultipartUpload(o *bsw.Object, uploadID string, parts
 
 // PreSignPutObjectURL_ returns presigned URL for PUT object request.
 func (s *Service) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
-	req, _ := s.svc.PutObjectRequest(&s3.PutObjectInput{
-		Bucket:   aws.String(o.Bucket()),
-		Key:      aws.String(o.Key()),
-		Metadata: o.Metadata(),
+	return s.PreSignPutObjectURLContext(context.Background(), o, timeout)
+}
+
+func (s *Service) PreSignPutObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+	sse, err := sseOf(o)
+	if err != nil {
+		return "", err
+	}
+
+	h := o.Headers()
+	req, _ := s.psvc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:                  aws.String(o.Bucket()),
+		Key:                     aws.String(o.Key()),
+		Metadata:                o.Metadata(),
+		ContentType:             optString(h.ContentType),
+		ContentDisposition:      optString(h.ContentDisposition),
+		CacheControl:            optString(h.CacheControl),
+		ContentEncoding:         optString(h.ContentEncoding),
+		ContentLanguage:         optString(h.ContentLanguage),
+		ServerSideEncryption:    sse.ServerSideEncryption,
+		SSEKMSKeyId:             sse.SSEKMSKeyID,
+		SSEKMSEncryptionContext: sse.SSEKMSEncryptionContext,
+		SSECustomerAlgorithm:    sse.SSECustomerAlgorithm,
+		SSECustomerKey:          sse.SSECustomerKey,
 	})
+	req.SetContext(ctx)
 
 	res, err := req.Presign(timeout)
 	if err != nil {
@@ -162,10 +300,28 @@ func (s *Service) PreSignPutObjectURL(o *bsw.Object, timeout time.Duration) (str
 }
 
 func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (string, error) {
-	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
-		Bucket: aws.String(o.Bucket()),
-		Key:    aws.String(o.Key()),
+	return s.PreSignGetObjectURLContext(context.Background(), o, timeout)
+}
+
+func (s *Service) PreSignGetObjectURLContext(ctx context.Context, o *bsw.Object, timeout time.Duration) (string, error) {
+	sse, err := sseOf(o)
+	if err != nil {
+		return "", err
+	}
+
+	rh := o.ResponseHeaders()
+	req, _ := s.psvc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:                     aws.String(o.Bucket()),
+		Key:                        aws.String(o.Key()),
+		ResponseContentType:        optString(rh.ContentType),
+		ResponseContentDisposition: optString(rh.ContentDisposition),
+		ResponseCacheControl:       optString(rh.CacheControl),
+		ResponseContentEncoding:    optString(rh.ContentEncoding),
+		ResponseContentLanguage:    optString(rh.ContentLanguage),
+		SSECustomerAlgorithm:       sse.SSECustomerAlgorithm,
+		SSECustomerKey:             sse.SSECustomerKey,
 	})
+	req.SetContext(ctx)
 
 	res, err := req.Presign(timeout)
 	if err != nil {
@@ -176,6 +332,373 @@ func (s *Service) PreSignGetObjectURL(o *bsw.Object, timeout time.Duration) (str
 
 }
 
+// UploadHeaders returns headers signed into presigned PUT URL. The client
+// must send them with the request.
+func (s *Service) UploadHeaders(o *bsw.Object) map[string]string {
+
+	res := sseHeaders(o.Encryption(), true)
+	h := o.Headers()
+	for k, v := range map[string]string{
+		"Content-Type":        h.ContentType,
+		"Content-Disposition": h.ContentDisposition,
+		"Cache-Control":       h.CacheControl,
+		"Content-Encoding":    h.ContentEncoding,
+		"Content-Language":    h.ContentLanguage,
+	} {
+		if v != "" {
+			res[k] = v
+		}
+	}
+
+	for k, v := range o.Metadata() {
+		if v != nil {
+			res["X-Amz-Meta-"+k] = *v
+		}
+	}
+	return res
+}
+
+// optString returns nil for empty string.
+func optString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// Put uploads content of r to the object. Large content is uploaded by parts.
+func (s *Service) Put(ctx context.Context, o *bsw.Object, r io.Reader, size int64) error {
+
+	u := s3manager.NewUploaderWithClient(s.svc, func(u *s3manager.Uploader) {
+		if size > u.PartSize*s3manager.MaxUploadParts {
+			u.PartSize = size/s3manager.MaxUploadParts + 1
+		}
+	})
+
+	sse, err := sseOf(o)
+	if err != nil {
+		return err
+	}
+
+	h := o.Headers()
+	_, err = u.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:                  aws.String(o.Bucket()),
+		Key:                     aws.String(o.Key()),
+		Metadata:                o.Metadata(),
+		ContentType:             optString(h.ContentType),
+		ContentDisposition:      optString(h.ContentDisposition),
+		CacheControl:            optString(h.CacheControl),
+		ContentEncoding:         optString(h.ContentEncoding),
+		ContentLanguage:         optString(h.ContentLanguage),
+		ServerSideEncryption:    sse.ServerSideEncryption,
+		SSEKMSKeyId:             sse.SSEKMSKeyID,
+		SSEKMSEncryptionContext: sse.SSEKMSEncryptionContext,
+		SSECustomerAlgorithm:    sse.SSECustomerAlgorithm,
+		SSECustomerKey:          sse.SSECustomerKey,
+		Body:                    r,
+	})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "size", size).
+			StatusCode(500).Msg("put object failed")
+	}
+	return nil
+}
+
+// Get returns object content.
+func (s *Service) Get(ctx context.Context, o *bsw.Object) (io.ReadCloser, bsw.ObjectInfo, error) {
+	return s.getObject(ctx, o, "")
+}
+
+// GetRange returns n bytes of the object content starting at off using
+// GetObject request with Range header.
+func (s *Service) GetRange(ctx context.Context, o *bsw.Object, off, n int64) (io.ReadCloser, bsw.ObjectInfo, error) {
+	return s.getObject(ctx, o, bsw.HTTPRange(off, n))
+}
+
+func (s *Service) getObject(ctx context.Context, o *bsw.Object, rng string) (io.ReadCloser, bsw.ObjectInfo, error) {
+
+	sse, err := sseOf(o)
+	if err != nil {
+		return nil, bsw.ObjectInfo{}, err
+	}
+
+	in := s3.GetObjectInput{
+		Bucket:               aws.String(o.Bucket()),
+		Key:                  aws.String(o.Key()),
+		SSECustomerAlgorithm: sse.SSECustomerAlgorithm,
+		SSECustomerKey:       sse.SSECustomerKey,
+	}
+	if rng != "" {
+		in.Range = aws.String(rng)
+	}
+
+	resp, err := s.svc.GetObjectWithContext(ctx, &in)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, bsw.ObjectInfo{}, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+			return nil, bsw.ObjectInfo{}, bsw.ErrInvalidRange.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "range", rng)
+		}
+		return nil, bsw.ObjectInfo{}, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("get object failed")
+	}
+
+	oi := bsw.ObjectInfo{
+		Bucket:       o.Bucket(),
+		Key:          o.Key(),
+		Size:         bsw.ContentRangeSize(aws.StringValue(resp.ContentRange), aws.Int64Value(resp.ContentLength)),
+		ETag:         strings.Trim(aws.StringValue(resp.ETag), `"`),
+		ContentType:  aws.StringValue(resp.ContentType),
+		LastModified: aws.TimeValue(resp.LastModified),
+		StorageClass: aws.StringValue(resp.StorageClass),
+		Metadata:     resp.Metadata,
+
+		ContentDisposition: aws.StringValue(resp.ContentDisposition),
+		CacheControl:       aws.StringValue(resp.CacheControl),
+		ContentEncoding:    aws.StringValue(resp.ContentEncoding),
+		ContentLanguage:    aws.StringValue(resp.ContentLanguage),
+	}
+	return resp.Body, oi, nil
+}
+
+// Stat returns object attributes using HeadObject request.
+func (s *Service) Stat(ctx context.Context, o *bsw.Object) (bsw.ObjectInfo, error) {
+
+	resp, err := s.headObject(ctx, o)
+	if err != nil {
+		return bsw.ObjectInfo{}, err
+	}
+
+	return bsw.ObjectInfo{
+		Bucket:       o.Bucket(),
+		Key:          o.Key(),
+		Size:         aws.Int64Value(resp.ContentLength),
+		ETag:         strings.Trim(aws.StringValue(resp.ETag), `"`),
+		ContentType:  aws.StringValue(resp.ContentType),
+		LastModified: aws.TimeValue(resp.LastModified),
+		StorageClass: aws.StringValue(resp.StorageClass),
+		Metadata:     resp.Metadata,
+
+		ContentDisposition: aws.StringValue(resp.ContentDisposition),
+		CacheControl:       aws.StringValue(resp.CacheControl),
+		ContentEncoding:    aws.StringValue(resp.ContentEncoding),
+		ContentLanguage:    aws.StringValue(resp.ContentLanguage),
+	}, nil
+}
+
+// headObject returns HeadObject response of the object.
+func (s *Service) headObject(ctx context.Context, o *bsw.Object) (*s3.HeadObjectOutput, error) {
+
+	sse, err := sseOf(o)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(o.Bucket()),
+		Key:                  aws.String(o.Key()),
+		SSECustomerAlgorithm: sse.SSECustomerAlgorithm,
+		SSECustomerKey:       sse.SSECustomerKey,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key())
+		}
+		return nil, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("head object failed")
+	}
+	return resp, nil
+}
+
+// isNotFound returns true if err reports missing object. HeadObject
+// has no response body, so it returns generic NotFound code.
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+// List returns iterator over objects backed by ListObjectsV2 requests.
+func (s *Service) List(ctx context.Context, bucket string, opts bsw.ListOptions) *bsw.ListIterator {
+	return bsw.NewListIterator(ctx, opts, func(ctx context.Context, token string) (bsw.ListPage, error) {
+
+		in := s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+		}
+		if opts.Prefix != "" {
+			in.Prefix = aws.String(opts.Prefix)
+		}
+		if opts.Delimiter != "" {
+			in.Delimiter = aws.String(opts.Delimiter)
+		}
+		if opts.StartAfter != "" {
+			in.StartAfter = aws.String(opts.StartAfter)
+		}
+		if opts.PageSize > 0 {
+			in.MaxKeys = aws.Int64(int64(opts.PageSize))
+		}
+		if token != "" {
+			in.ContinuationToken = aws.String(token)
+		}
+
+		resp, err := s.svc.ListObjectsV2WithContext(ctx, &in)
+		if err != nil {
+			return bsw.ListPage{}, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", opts.Prefix).
+				StatusCode(500).Msg("list objects failed")
+		}
+
+		var res bsw.ListPage
+		if aws.BoolValue(resp.IsTruncated) {
+			res.NextToken = aws.StringValue(resp.NextContinuationToken)
+		}
+
+		// objects and common prefixes are sorted separately, merge them keeping the order.
+		objs, cps := resp.Contents, resp.CommonPrefixes
+		for len(objs) > 0 || len(cps) > 0 {
+			if len(cps) == 0 || (len(objs) > 0 && aws.StringValue(objs[0].Key) < aws.StringValue(cps[0].Prefix)) {
+				res.Objects = append(res.Objects, bsw.ObjectInfo{
+					Bucket:       bucket,
+					Key:          aws.StringValue(objs[0].Key),
+					Size:         aws.Int64Value(objs[0].Size),
+					ETag:         strings.Trim(aws.StringValue(objs[0].ETag), `"`),
+					LastModified: aws.TimeValue(objs[0].LastModified),
+					StorageClass: aws.StringValue(objs[0].StorageClass),
+				})
+				objs = objs[1:]
+				continue
+			}
+			res.Objects = append(res.Objects, bsw.ObjectInfo{
+				Bucket:   bucket,
+				Key:      aws.StringValue(cps[0].Prefix),
+				IsPrefix: true,
+			})
+			cps = cps[1:]
+		}
+		return res, nil
+	})
+}
+
+func isNoSuchUpload(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchUpload
+}
+
+// Delete removes the object.
+func (s *Service) Delete(ctx context.Context, o *bsw.Object) error {
+
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(o.Bucket()),
+		Key:    aws.String(o.Key()),
+	})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key()).
+			StatusCode(500).Msg("delete object failed")
+	}
+	return nil
+}
+
+// maxDeleteObjects is the limit of keys in a single DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// DeleteMany removes objects by DeleteObjects requests, up to 1000 keys per request.
+func (s *Service) DeleteMany(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
+
+	var res []bsw.DeleteError
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxDeleteObjects {
+			n = maxDeleteObjects
+		}
+
+		failed, err := s.deleteObjects(ctx, bucket, keys[:n])
+		if err != nil {
+			return res, err
+		}
+		res = append(res, failed...)
+		keys = keys[n:]
+	}
+	return res, nil
+}
+
+func (s *Service) deleteObjects(ctx context.Context, bucket string, keys []string) ([]bsw.DeleteError, error) {
+
+	ids := make([]*s3.ObjectIdentifier, len(keys))
+	for i := range keys {
+		ids[i] = &s3.ObjectIdentifier{Key: aws.String(keys[i])}
+	}
+
+	resp, err := s.svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{Objects: ids, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Catch(err).Set("bucket", bucket).StatusCode(500).Msg("delete objects cancelled")
+		}
+
+		// the whole request failed, every key in it is reported.
+		ex := errors.Catch(err).Critical().Set("bucket", bucket).StatusCode(500)
+		if aerr, ok := err.(awserr.Error); ok {
+			ex.Set("awsErrCode", aerr.Code())
+		}
+		res := make([]bsw.DeleteError, len(keys))
+		for i := range keys {
+			res[i] = bsw.DeleteError{Key: keys[i], Err: ex}
+		}
+		return res, nil
+	}
+
+	var res []bsw.DeleteError
+	for _, e := range resp.Errors {
+		res = append(res, bsw.DeleteError{
+			Key: aws.StringValue(e.Key),
+			Err: errors.New(aws.StringValue(e.Message)).Set("awsErrCode", aws.StringValue(e.Code)).StatusCode(500),
+		})
+	}
+	return res, nil
+}
+
+// DeletePrefix lists objects with the prefix and removes them page by page.
+func (s *Service) DeletePrefix(ctx context.Context, bucket, prefix string) ([]bsw.DeleteError, error) {
+
+	if prefix == "" {
+		return nil, bsw.ErrWrongInvocation.Capture().Set("reason", "empty prefix")
+	}
+
+	var (
+		res  []bsw.DeleteError
+		derr error
+	)
+
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		keys := make([]string, len(page.Contents))
+		for i := range page.Contents {
+			keys[i] = aws.StringValue(page.Contents[i].Key)
+		}
+
+		var failed []bsw.DeleteError
+		failed, derr = s.DeleteMany(ctx, bucket, keys)
+		res = append(res, failed...)
+		return derr == nil
+	})
+	if derr != nil {
+		return res, derr
+	}
+	if err != nil {
+		return res, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", prefix).
+			StatusCode(500).Msg("list objects failed")
+	}
+	return res, nil
+}
+
 func (s *Service) Name() string {
 	return "s3"
 }
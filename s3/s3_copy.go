@@ -0,0 +1,188 @@
+package s3
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+const (
+	// maxCopyObjectSize is the largest object CopyObject can copy.
+	maxCopyObjectSize = 5 << 30
+
+	// minCopyPartSize is the part size used by UploadPartCopy.
+	minCopyPartSize = 512 << 20
+)
+
+// Copy copies src object to dst using CopyObject request. Objects larger
+// than 5 GiB are copied by parts using UploadPartCopy.
+func (s *Service) Copy(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+
+	head, err := s.headObject(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	ssrc, err := sseOf(src)
+	if err != nil {
+		return err
+	}
+
+	sdst, err := sseOf(dst)
+	if err != nil {
+		return err
+	}
+
+	if size := aws.Int64Value(head.ContentLength); size > maxCopyObjectSize {
+		// UploadPartCopy does not copy headers, they are taken from the source
+		// the same way CopyObject does.
+		md, h := head.Metadata, bsw.Headers{
+			ContentType:        aws.StringValue(head.ContentType),
+			ContentDisposition: aws.StringValue(head.ContentDisposition),
+			CacheControl:       aws.StringValue(head.CacheControl),
+			ContentEncoding:    aws.StringValue(head.ContentEncoding),
+			ContentLanguage:    aws.StringValue(head.ContentLanguage),
+		}
+		if opts.ReplaceMetadata {
+			md, h = dst.Metadata(), dst.Headers()
+		}
+		return s.copyByParts(ctx, src, dst, size, md, h, ssrc, sdst)
+	}
+
+	// encryption of dst is set explicitly, otherwise bucket default applies.
+	in := s3.CopyObjectInput{
+		Bucket:                         aws.String(dst.Bucket()),
+		Key:                            aws.String(dst.Key()),
+		CopySource:                     aws.String(copySource(src)),
+		CopySourceSSECustomerAlgorithm: ssrc.SSECustomerAlgorithm,
+		CopySourceSSECustomerKey:       ssrc.SSECustomerKey,
+		ServerSideEncryption:           sdst.ServerSideEncryption,
+		SSEKMSKeyId:                    sdst.SSEKMSKeyID,
+		SSEKMSEncryptionContext:        sdst.SSEKMSEncryptionContext,
+		SSECustomerAlgorithm:           sdst.SSECustomerAlgorithm,
+		SSECustomerKey:                 sdst.SSECustomerKey,
+	}
+	if opts.ReplaceMetadata {
+		h := dst.Headers()
+		in.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+		in.Metadata = dst.Metadata()
+		in.ContentType = optString(h.ContentType)
+		in.ContentDisposition = optString(h.ContentDisposition)
+		in.CacheControl = optString(h.CacheControl)
+		in.ContentEncoding = optString(h.ContentEncoding)
+		in.ContentLanguage = optString(h.ContentLanguage)
+	}
+
+	if _, err := s.svc.CopyObjectWithContext(ctx, &in); err != nil {
+		return errors.Catch(err).Critical().
+			SetPairs("srcBucket", src.Bucket(), "srcKey", src.Key(), "bucket", dst.Bucket(), "key", dst.Key()).
+			StatusCode(500).Msg("copy object failed")
+	}
+	return nil
+}
+
+func (s *Service) copyByParts(ctx context.Context, src, dst *bsw.Object, size int64, md map[string]*string, h bsw.Headers, ssrc, sdst sseParams) error {
+
+	resp, err := s.svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                  aws.String(dst.Bucket()),
+		Key:                     aws.String(dst.Key()),
+		Metadata:                md,
+		ContentType:             optString(h.ContentType),
+		ContentDisposition:      optString(h.ContentDisposition),
+		CacheControl:            optString(h.CacheControl),
+		ContentEncoding:         optString(h.ContentEncoding),
+		ContentLanguage:         optString(h.ContentLanguage),
+		ServerSideEncryption:    sdst.ServerSideEncryption,
+		SSEKMSKeyId:             sdst.SSEKMSKeyID,
+		SSEKMSEncryptionContext: sdst.SSEKMSEncryptionContext,
+		SSECustomerAlgorithm:    sdst.SSECustomerAlgorithm,
+		SSECustomerKey:          sdst.SSECustomerKey,
+	})
+	if err != nil {
+		return errors.Catch(err).Critical().SetPairs("bucket", dst.Bucket(), "key", dst.Key()).
+			StatusCode(500).Msg("create multipart upload request failed")
+	}
+
+	partSize := int64(minCopyPartSize)
+	if ps := size/s3manager.MaxUploadParts + 1; ps > partSize {
+		partSize = ps
+	}
+
+	var cmu s3.CompletedMultipartUpload
+	for pn, from := int64(1), int64(0); from < size; pn, from = pn+1, from+partSize {
+		to := from + partSize - 1
+		if to >= size {
+			to = size - 1
+		}
+
+		pr, err := s.svc.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(dst.Bucket()),
+			Key:             aws.String(dst.Key()),
+			UploadId:        resp.UploadId,
+			PartNumber:      aws.Int64(pn),
+			CopySource:      aws.String(copySource(src)),
+			CopySourceRange: aws.String("bytes=" + strconv.FormatInt(from, 10) + "-" + strconv.FormatInt(to, 10)),
+
+			CopySourceSSECustomerAlgorithm: ssrc.SSECustomerAlgorithm,
+			CopySourceSSECustomerKey:       ssrc.SSECustomerKey,
+			SSECustomerAlgorithm:           sdst.SSECustomerAlgorithm,
+			SSECustomerKey:                 sdst.SSECustomerKey,
+		})
+		if err != nil {
+			s.abort(dst, *resp.UploadId)
+			return errors.Catch(err).Critical().
+				SetPairs("srcBucket", src.Bucket(), "srcKey", src.Key(), "bucket", dst.Bucket(), "key", dst.Key(), "part", pn).
+				StatusCode(500).Msg("upload part copy failed")
+		}
+		cmu.Parts = append(cmu.Parts, &s3.CompletedPart{ETag: pr.CopyPartResult.ETag, PartNumber: aws.Int64(pn)})
+	}
+
+	_, err = s.svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:               aws.String(dst.Bucket()),
+		Key:                  aws.String(dst.Key()),
+		UploadId:             resp.UploadId,
+		MultipartUpload:      &cmu,
+		SSECustomerAlgorithm: sdst.SSECustomerAlgorithm,
+		SSECustomerKey:       sdst.SSECustomerKey,
+	})
+	if err != nil {
+		s.abort(dst, *resp.UploadId)
+		return errors.Catch(err).Critical().SetPairs("bucket", dst.Bucket(), "key", dst.Key(), "uploadID", *resp.UploadId).
+			StatusCode(500).Msg("multipart complete failed")
+	}
+	return nil
+}
+
+// abort aborts multipart upload ignoring errors. It does not use caller's
+// context because it's called mostly when the context is done.
+func (s *Service) abort(o *bsw.Object, uploadID string) {
+	_, _ = s.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(o.Bucket()),
+		Key:      aws.String(o.Key()),
+		UploadId: aws.String(uploadID),
+	})
+}
+
+// Move copies src object to dst and deletes src.
+func (s *Service) Move(ctx context.Context, src, dst *bsw.Object, opts bsw.CopyOptions) error {
+	if err := s.Copy(ctx, src, dst, opts); err != nil {
+		return err
+	}
+	return s.Delete(ctx, src)
+}
+
+// copySource returns URL encoded value of x-amz-copy-source header.
+func copySource(o *bsw.Object) string {
+	segs := strings.Split(o.Key(), "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	return o.Bucket() + "/" + strings.Join(segs, "/")
+}
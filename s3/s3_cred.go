@@ -0,0 +1,55 @@
+package s3
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/axkit/errors"
+)
+
+// defaultRoleSessionName is used if Config.Credentials.RoleSessionName is empty.
+const defaultRoleSessionName = "bsw"
+
+// staticCredentials returns provider of keys given in config. Nil is returned
+// if keys are not given, so the session resolves them by the default chain.
+func (s *Service) staticCredentials() *credentials.Credentials {
+	c := &s.cfg.Credentials
+	if c.AwsAccessKeyID == "" && c.AwsSecretAccessKey == "" {
+		return nil
+	}
+	return credentials.NewStaticCredentials(c.AwsAccessKeyID, c.AwsSecretAccessKey, c.AwsSessionToken)
+}
+
+// assumeRoleCredentials returns provider of temporary credentials of the role
+// assumed by the credentials the session already has.
+func (s *Service) assumeRoleCredentials() *credentials.Credentials {
+	c := &s.cfg.Credentials
+
+	sess := s.sess
+	if c.STSEndpoint != "" {
+		sess = sess.Copy(&aws.Config{Endpoint: aws.String(c.STSEndpoint)})
+	}
+
+	return stscreds.NewCredentials(sess, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = c.RoleSessionName
+		if p.RoleSessionName == "" {
+			p.RoleSessionName = defaultRoleSessionName
+		}
+		if c.ExternalID != "" {
+			p.ExternalID = aws.String(c.ExternalID)
+		}
+	})
+}
+
+// CredentialsProvider resolves credentials and returns the name of provider
+// supplied them: StaticProvider, EnvConfigCredentials, SharedConfigCredentials,
+// WebIdentityCredentials, AssumeRoleProvider, EC2RoleProvider, etc.
+func (s *Service) CredentialsProvider(ctx context.Context) (string, error) {
+	v, err := s.sess.Config.Credentials.GetWithContext(ctx)
+	if err != nil {
+		return "", errors.Catch(err).Critical().StatusCode(500).Msg("retrieving aws credentials failed")
+	}
+	return v.ProviderName, nil
+}
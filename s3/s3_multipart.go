@@ -0,0 +1,87 @@
+package s3
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// AbortMultipartUpload aborts multipart upload. Parts uploaded are removed.
+func (s *Service) AbortMultipartUpload(ctx context.Context, o *bsw.Object, uploadID string) error {
+
+	_, err := s.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(o.Bucket()),
+		Key:      aws.String(o.Key()),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		if isNoSuchUpload(err) {
+			return bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+		}
+		return errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(500).Msg("abort multipart upload failed")
+	}
+	return nil
+}
+
+// ListMultipartUploads returns multipart uploads in progress.
+func (s *Service) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]bsw.MultipartUpload, error) {
+
+	in := s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	}
+	if prefix != "" {
+		in.Prefix = aws.String(prefix)
+	}
+
+	var res []bsw.MultipartUpload
+	err := s.svc.ListMultipartUploadsPagesWithContext(ctx, &in, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, u := range page.Uploads {
+			res = append(res, bsw.MultipartUpload{
+				Bucket:    bucket,
+				Key:       aws.StringValue(u.Key),
+				UploadID:  aws.StringValue(u.UploadId),
+				Initiated: aws.TimeValue(u.Initiated),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Catch(err).Critical().SetPairs("bucket", bucket, "prefix", prefix).
+			StatusCode(500).Msg("list multipart uploads failed")
+	}
+	return res, nil
+}
+
+// ListParts returns parts uploaded within multipart upload.
+func (s *Service) ListParts(ctx context.Context, o *bsw.Object, uploadID string) ([]bsw.Part, error) {
+
+	var res []bsw.Part
+	err := s.svc.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(o.Bucket()),
+		Key:      aws.String(o.Key()),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, p := range page.Parts {
+			res = append(res, bsw.Part{
+				PartNumber:   aws.Int64Value(p.PartNumber),
+				ETag:         strings.Trim(aws.StringValue(p.ETag), `"`),
+				Size:         aws.Int64Value(p.Size),
+				LastModified: aws.TimeValue(p.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		if isNoSuchUpload(err) {
+			return nil, bsw.ErrNotFound.Capture().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID)
+		}
+		return nil, errors.Catch(err).Critical().SetPairs("bucket", o.Bucket(), "key", o.Key(), "uploadID", uploadID).
+			StatusCode(500).Msg("list parts failed")
+	}
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package s3
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+func init() {
+	bsw.Register("s3", Open)
+}
+
+// Open creates and initializes Service configured by URL
+//
+//	s3://[accessKeyId:secretAccessKey@]?region=eu-west-1
+//
+// Query parameters: region, endpoint, publicEndpoint, forcePathStyle,
+// disableSSL, caBundleFile, profile, roleArn, externalId, roleSessionName,
+// stsEndpoint and retryCount. Credentials are resolved by the default AWS
+// chain if not given in URL.
+func Open(ctx context.Context, u *url.URL) (bsw.BlockStorageWrapper, error) {
+
+	q := u.Query()
+
+	var cfg Config
+	if v := q.Get("region"); v != "" {
+		cfg.Region = aws.String(v)
+	}
+	cfg.Endpoint = q.Get("endpoint")
+	cfg.PublicEndpoint = q.Get("publicEndpoint")
+	cfg.CABundleFile = q.Get("caBundleFile")
+
+	var err error
+	if cfg.ForcePathStyle, err = boolParam(q, "forcePathStyle"); err != nil {
+		return nil, err
+	}
+	if cfg.DisableSSL, err = boolParam(q, "disableSSL"); err != nil {
+		return nil, err
+	}
+	if v := q.Get("retryCount"); v != "" {
+		if cfg.RetryCount, err = strconv.Atoi(v); err != nil {
+			return nil, errors.ValidationFailed("invalid retryCount").Set("value", v)
+		}
+	}
+
+	if u.User != nil {
+		cfg.Credentials.AwsAccessKeyID = u.User.Username()
+		cfg.Credentials.AwsSecretAccessKey, _ = u.User.Password()
+	}
+	cfg.Credentials.Profile = q.Get("profile")
+	cfg.Credentials.RoleARN = q.Get("roleArn")
+	cfg.Credentials.ExternalID = q.Get("externalId")
+	cfg.Credentials.RoleSessionName = q.Get("roleSessionName")
+	cfg.Credentials.STSEndpoint = q.Get("stsEndpoint")
+
+	s := New(&cfg)
+	if err := s.Init(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func boolParam(q url.Values, name string) (bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.ValidationFailed("invalid boolean parameter").SetPairs("param", name, "value", v)
+	}
+	return res, nil
+}
@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+const (
+	amzAlgorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+
+	// maxPostObjectSize is the largest object POST upload accepts.
+	maxPostObjectSize = 5 << 30
+)
+
+// PreSignPostPolicy returns the form for browser upload signed by SigV4 POST policy.
+func (s *Service) PreSignPostPolicy(o *bsw.Object, opts bsw.PostPolicyOptions) (*bsw.PostPolicy, error) {
+
+	sse, err := sseOf(o)
+	if err != nil {
+		return nil, err
+	}
+
+	cv, err := s.sess.Config.Credentials.Get()
+	if err != nil {
+		return nil, errors.Catch(err).Critical().StatusCode(500).Msg("retrieving credentials failed")
+	}
+
+	// bucket URL is taken from the request the SDK builds, so endpoint and
+	// addressing style settings are respected.
+	req, _ := s.psvc.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(o.Bucket())})
+	if err := req.Build(); err != nil {
+		return nil, errors.Catch(err).Critical().Set("bucket", o.Bucket()).StatusCode(500).Msg("building bucket URL failed")
+	}
+	u := *req.HTTPRequest.URL
+	u.RawQuery = ""
+
+	now := time.Now().UTC()
+	region := aws.StringValue(s.sess.Config.Region)
+	scope := now.Format("20060102") + "/" + region + "/s3/aws4_request"
+
+	fields := map[string]string{
+		"key":              o.Key(),
+		"x-amz-algorithm":  amzAlgorithm,
+		"x-amz-credential": cv.AccessKeyID + "/" + scope,
+		"x-amz-date":       now.Format(amzDateFormat),
+	}
+
+	conds := []interface{}{
+		map[string]string{"bucket": o.Bucket()},
+		map[string]string{"x-amz-algorithm": fields["x-amz-algorithm"]},
+		map[string]string{"x-amz-credential": fields["x-amz-credential"]},
+		map[string]string{"x-amz-date": fields["x-amz-date"]},
+	}
+
+	if opts.KeyStartsWith != "" {
+		conds = append(conds, []string{"starts-with", "$key", opts.KeyStartsWith})
+	} else {
+		conds = append(conds, map[string]string{"key": o.Key()})
+	}
+
+	switch {
+	case opts.MaxSize > 0:
+		conds = append(conds, []interface{}{"content-length-range", opts.MinSize, opts.MaxSize})
+	case opts.MinSize > 0:
+		conds = append(conds, []interface{}{"content-length-range", opts.MinSize, maxPostObjectSize})
+	}
+
+	switch {
+	case opts.ContentType != "":
+		fields["Content-Type"] = opts.ContentType
+		conds = append(conds, map[string]string{"Content-Type": opts.ContentType})
+	case opts.ContentTypeStartsWith != "":
+		conds = append(conds, []string{"starts-with", "$Content-Type", opts.ContentTypeStartsWith})
+	}
+
+	if opts.SuccessActionRedirect != "" {
+		fields["success_action_redirect"] = opts.SuccessActionRedirect
+		conds = append(conds, map[string]string{"success_action_redirect": opts.SuccessActionRedirect})
+	} else if opts.SuccessActionStatus != 0 {
+		fields["success_action_status"] = strconv.Itoa(opts.SuccessActionStatus)
+		conds = append(conds, map[string]string{"success_action_status": fields["success_action_status"]})
+	}
+
+	for k, v := range sseHeaders(o.Encryption(), true) {
+		fields[k] = v
+		conds = append(conds, map[string]string{k: v})
+	}
+	if sse.SSEKMSEncryptionContext != nil {
+		fields["X-Amz-Server-Side-Encryption-Context"] = *sse.SSEKMSEncryptionContext
+		conds = append(conds, map[string]string{"X-Amz-Server-Side-Encryption-Context": *sse.SSEKMSEncryptionContext})
+	}
+
+	if cv.SessionToken != "" {
+		fields["x-amz-security-token"] = cv.SessionToken
+		conds = append(conds, map[string]string{"x-amz-security-token": cv.SessionToken})
+	}
+
+	for k, v := range o.Metadata() {
+		if v != nil {
+			fields["x-amz-meta-"+k] = *v
+			conds = append(conds, map[string]string{"x-amz-meta-" + k: *v})
+		}
+	}
+
+	buf, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(opts.Expires).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conds,
+	})
+	if err != nil {
+		return nil, errors.Catch(err).Critical().StatusCode(500).Msg("encoding post policy failed")
+	}
+
+	policy := base64.StdEncoding.EncodeToString(buf)
+	fields["policy"] = policy
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey(cv.SecretAccessKey, now, region), policy))
+
+	return &bsw.PostPolicy{URL: u.String(), Fields: fields}, nil
+}
+
+// signingKey derives SigV4 signing key for S3 service.
+func signingKey(secret string, t time.Time, region string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), t.Format("20060102"))
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
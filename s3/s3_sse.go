@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/axkit/bsw"
+	"github.com/axkit/errors"
+)
+
+// sseParams holds server-side encryption fields of S3 request inputs.
+// Customer key is raw, SDK encodes it and computes its MD5.
+type sseParams struct {
+	ServerSideEncryption    *string
+	SSEKMSKeyID             *string
+	SSEKMSEncryptionContext *string
+	SSECustomerAlgorithm    *string
+	SSECustomerKey          *string
+}
+
+// sseOf converts encryption of the object to request fields. Encryption
+// scope is not supported by S3.
+func sseOf(o *bsw.Object) (sseParams, error) {
+
+	var res sseParams
+
+	e := o.Encryption()
+	if err := e.Validate(); err != nil {
+		return res, err
+	}
+
+	switch e.Mode {
+	case bsw.SSEManaged:
+		res.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAes256)
+	case bsw.SSEKMS:
+		res.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		res.SSEKMSKeyID = optString(e.KeyID)
+		if len(e.Context) > 0 {
+			buf, err := json.Marshal(e.Context)
+			if err != nil {
+				return res, errors.Catch(err).StatusCode(500).Msg("encoding kms encryption context failed")
+			}
+			res.SSEKMSEncryptionContext = aws.String(base64.StdEncoding.EncodeToString(buf))
+		}
+	case bsw.SSECustomer:
+		res.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		res.SSECustomerKey = aws.String(string(e.CustomerKey))
+	case bsw.SSEScope:
+		return res, bsw.ErrNotSupported.Capture().SetPairs("backend", "s3", "encryption", e.Mode)
+	}
+	return res, nil
+}
+
+// sseHeaders returns encryption headers the client must send with presigned
+// requests. Upload requests need all of them, downloads customer key only.
+// KMS encryption context is not returned, it's signed into query string.
+func sseHeaders(e bsw.Encryption, upload bool) map[string]string {
+
+	res := make(map[string]string)
+	switch e.Mode {
+	case bsw.SSEManaged:
+		if upload {
+			res["X-Amz-Server-Side-Encryption"] = s3.ServerSideEncryptionAes256
+		}
+	case bsw.SSEKMS:
+		if upload {
+			res["X-Amz-Server-Side-Encryption"] = s3.ServerSideEncryptionAwsKms
+			if e.KeyID != "" {
+				res["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"] = e.KeyID
+			}
+		}
+	case bsw.SSECustomer:
+		res["X-Amz-Server-Side-Encryption-Customer-Algorithm"] = s3.ServerSideEncryptionAes256
+		res["X-Amz-Server-Side-Encryption-Customer-Key"] = e.CustomerKeyBase64()
+		res["X-Amz-Server-Side-Encryption-Customer-Key-Md5"] = e.CustomerKeyMD5()
+	}
+	return res
+}
+
+// DownloadHeaders returns headers signed into presigned GET URL. Only objects
+// encrypted by customer key require them.
+func (s *Service) DownloadHeaders(o *bsw.Object) map[string]string {
+	res := sseHeaders(o.Encryption(), false)
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}